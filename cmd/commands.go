@@ -0,0 +1,255 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"login-with-oauth/internal/keyring"
+	"login-with-oauth/internal/repository"
+	"login-with-oauth/internal/services"
+	"os"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// runCommand runs a maintenance command instead of the server
+func runCommand(db *sql.DB, name string, args []string) error {
+	switch name {
+	case "rotate-keys":
+		return rotateKeys(db, args)
+	case "clients":
+		return manageClients(db, args)
+	default:
+		return fmt.Errorf("unknown command %q", name)
+	}
+}
+
+// rotateKeys replaces the active signing key. With -emergency the previous
+// keys are deleted at once, for when a key may have leaked. Running servers
+// pick the new key up within keys.checkInterval.
+func rotateKeys(db *sql.DB, args []string) error {
+	flags := flag.NewFlagSet("rotate-keys", flag.ContinueOnError)
+	emergency := flags.Bool("emergency", false, "delete the previous keys instead of keeping them published")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	manager, err := newKeyManager(db)
+	if err != nil {
+		return err
+	}
+
+	id, err := manager.Rotate(*emergency)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("New signing key:", id)
+	return nil
+}
+
+// newKeyManager builds the KeyManager from the keys section
+func newKeyManager(db *sql.DB) (*services.KeyManager, error) {
+	var repo repository.SigningKeyRepository
+	switch viper.GetString("keys.store") {
+	case "postgres":
+		repo = repository.NewSigningKeyRepository(db)
+	case "file":
+		if viper.GetString("keys.dir") == "" {
+			return nil, fmt.Errorf("keys.dir must be set for the file key store")
+		}
+		repo = repository.NewFileSigningKeyRepository(viper.GetString("keys.dir"))
+	default:
+		return nil, fmt.Errorf("unknown key store %q", viper.GetString("keys.store"))
+	}
+
+	keys, err := keyring.ParseKeys(viper.GetStringSlice("keys.encryptionKeys"))
+	if err != nil {
+		return nil, fmt.Errorf("keys.encryptionKeys: %v", err)
+	}
+	ring, err := keyring.New(keys...)
+	if err != nil {
+		return nil, fmt.Errorf("keys.encryptionKeys: %v", err)
+	}
+
+	return services.NewKeyManager(repo, ring, services.KeyManagerConfig{
+		Algorithm:      viper.GetString("keys.algorithm"),
+		RotationPeriod: viper.GetDuration("keys.rotationPeriod"),
+		Overlap:        viper.GetDuration("keys.overlap"),
+	})
+}
+
+// manageClients runs "clients list|get|create|update|delete|rotate-secret",
+// the command line version of the /admin/clients API. Unlike PUT, update
+// changes only the settings whose flags are given.
+func manageClients(db *sql.DB, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: clients list|get|create|update|delete|rotate-secret [flags] [id]")
+	}
+	clientService, err := newClientService(db)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "list":
+		clients, err := clientService.ListClients()
+		if err != nil {
+			return err
+		}
+		return printJSON(clients)
+	case "create":
+		flags, _, err := parseClientFlags("clients create", args[1:], false)
+		if err != nil {
+			return err
+		}
+		client, secret, err := clientService.CreateClient(applyClientFlags(flags, services.ClientInput{}))
+		if err != nil {
+			return err
+		}
+		if err := printJSON(client); err != nil {
+			return err
+		}
+		if secret != "" {
+			fmt.Println("Client secret (shown once):", secret)
+		}
+		return nil
+	case "update":
+		flags, id, err := parseClientFlags("clients update", args[1:], true)
+		if err != nil {
+			return err
+		}
+		// Only the settings whose flags were given change
+		current, err := clientService.GetClient(id)
+		if err != nil {
+			return err
+		}
+		input := applyClientFlags(flags, services.ClientInput{
+			Name:         current.Name,
+			RedirectURIs: current.RedirectURIs,
+			Scopes:       current.Scopes,
+			GrantTypes:   current.GrantTypes,
+			Public:       current.Public,
+			FirstParty:   current.FirstParty,
+		})
+		client, secret, err := clientService.UpdateClient(id, input)
+		if err != nil {
+			return err
+		}
+		if err := printJSON(client); err != nil {
+			return err
+		}
+		if secret != "" {
+			fmt.Println("Client secret (shown once):", secret)
+		}
+		return nil
+	}
+
+	if len(args) != 2 {
+		return fmt.Errorf("usage: clients %s <id>", args[0])
+	}
+	id := args[1]
+
+	switch args[0] {
+	case "get":
+		client, err := clientService.GetClient(id)
+		if err != nil {
+			return err
+		}
+		return printJSON(client)
+	case "delete":
+		if err := clientService.DeleteClient(id); err != nil {
+			return err
+		}
+		fmt.Println("Deleted client:", id)
+		return nil
+	case "rotate-secret":
+		secret, err := clientService.RotateSecret(id)
+		if err != nil {
+			return err
+		}
+		fmt.Println("New client secret (shown once):", secret)
+		return nil
+	default:
+		return fmt.Errorf("unknown clients command %q", args[0])
+	}
+}
+
+// parseClientFlags reads the flags describing a client. The client id, needed
+// by update, is the only positional argument and may come before or after the
+// flags.
+func parseClientFlags(name string, args []string, wantID bool) (*flag.FlagSet, string, error) {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.String("name", "", "name shown to users")
+	flags.String("redirect-uris", "", "allowed redirect URIs, matched exactly")
+	flags.String("scopes", "", "allowed scopes, including API scopes from clients.apiScopes (default: the OpenID Connect scopes)")
+	flags.String("grant-types", "", "allowed grant types (default: authorization_code,refresh_token)")
+	flags.Bool("public", false, "the client cannot keep a secret and must use PKCE")
+	flags.Bool("first-party", false, "our own app, which users are not asked to approve")
+	// flag stops at the first positional argument, so parse what follows it
+	// too
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, "", err
+		}
+		if flags.NArg() == 0 {
+			break
+		}
+		positional = append(positional, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+
+	id := ""
+	switch {
+	case wantID && len(positional) == 1:
+		id = positional[0]
+	case wantID:
+		return nil, "", fmt.Errorf("usage: %s <id> [flags]", name)
+	case len(positional) > 0:
+		return nil, "", fmt.Errorf("%s: unexpected argument %q", name, positional[0])
+	}
+
+	return flags, id, nil
+}
+
+// applyClientFlags overwrites the settings of input whose flags were given.
+// Lists are comma separated.
+func applyClientFlags(flags *flag.FlagSet, input services.ClientInput) services.ClientInput {
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "name":
+			input.Name = f.Value.String()
+		case "redirect-uris":
+			input.RedirectURIs = splitList(f.Value.String())
+		case "scopes":
+			input.Scopes = splitList(f.Value.String())
+		case "grant-types":
+			input.GrantTypes = splitList(f.Value.String())
+		case "public":
+			input.Public = f.Value.(flag.Getter).Get().(bool)
+		case "first-party":
+			input.FirstParty = f.Value.(flag.Getter).Get().(bool)
+		}
+	})
+	return input
+}
+
+// splitList splits a comma separated flag value, ignoring empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func printJSON(v any) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
@@ -1,15 +1,22 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
 	"log"
 	"login-with-oauth/internal/configs"
 	"login-with-oauth/internal/database"
 	"login-with-oauth/internal/handlers"
+	"login-with-oauth/internal/jwt"
+	"login-with-oauth/internal/keyring"
 	"login-with-oauth/internal/logger"
 	"login-with-oauth/internal/repository"
 	"login-with-oauth/internal/services"
 	"net/http"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/spf13/viper"
 )
@@ -28,38 +35,305 @@ func main() {
 	}
 	defer db.Close()
 
+	if err := database.RunMigrations(); err != nil {
+		logger.Log.Fatal("Failed to run migrations:" + err.Error())
+	}
+
+	// Commands other than serving, such as rotate-keys
+	if len(os.Args) > 1 {
+		if err := runCommand(db, os.Args[1], os.Args[2:]); err != nil {
+			logger.Log.Fatal(os.Args[1] + " failed: " + err.Error())
+		}
+		return
+	}
+
 	// Initialize Repository
 	userRepo := repository.NewUserRepository(db)
 
-	// Initialize Oauth2 Services
-	googleService := services.NewGoogleService(
-		viper.GetString("google.clientID"),
-		viper.GetString("google.clientSecret"),
-		userRepo,
-	)
+	// Initialize OAuth state store
+	stateStore, err := newStateStore(db)
+	if err != nil {
+		logger.Log.Fatal("Failed to initialize state store:" + err.Error())
+	}
 
-	githubService := services.NewGitHubService(
-		viper.GetString("github.clientID"),
-		viper.GetString("github.clientSecret"),
+	// Initialize Oauth2 Providers
+	registry := services.NewRegistry()
+	for _, cfg := range providerConfigs() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		provider, err := services.NewProvider(ctx, cfg, userRepo)
+		cancel()
+		if err != nil {
+			logger.Log.Error("Failed to set up provider " + cfg.Name + ": " + err.Error())
+			continue
+		}
+		if err := registry.Register(provider); err != nil {
+			logger.Log.Fatal("Failed to register provider:" + err.Error())
+		}
+	}
+
+	// Initialize Services
+	accountService := services.NewAccountService(userRepo, repository.NewIdentityRepository(db))
+	sessionStore, err := newSessionStore(db)
+	if err != nil {
+		logger.Log.Fatal("Failed to initialize session store:" + err.Error())
+	}
+	sessionService := services.NewSessionService(
+		sessionStore,
 		userRepo,
+		viper.GetDuration("session.ttl"),
+		viper.GetDuration("session.idleTimeout"),
 	)
-	// Initialize Oauth2 Services
-	googleHandler := handlers.NewGoogleHandler(googleService)
+	go sessionService.RunSweeper(context.Background(), viper.GetDuration("session.sweepInterval"))
 
-	// Initialize Handlers
-	authHandler := handlers.NewAuthHandler(githubService)
+	var signer services.TokenSigner
+	var tokenService *services.TokenService
+	var clientService *services.ClientService
+	var authorizationServer *services.AuthorizationServer
+	var registrationService *services.RegistrationService
+	var consentService *services.ConsentService
+	var deviceService *services.DeviceService
+	if viper.GetBool("tokens.enabled") {
+		signer, err = newTokenSigner(db)
+		if err != nil {
+			logger.Log.Fatal("Failed to initialize signing keys:" + err.Error())
+		}
+		tokenService, err = newTokenService(db, userRepo, signer)
+		if err != nil {
+			logger.Log.Fatal("Failed to initialize token service:" + err.Error())
+		}
+		go tokenService.RunSweeper(context.Background(), viper.GetDuration("tokens.sweepInterval"))
+		tokenService.SetSessionRevoker(sessionService)
 
-	if err := database.RunMigrations(); err != nil {
-		logger.Log.Fatal("Failed to run migrations:" + err.Error())
+		clientService, err = newClientService(db)
+		if err != nil {
+			logger.Log.Fatal("Failed to initialize clients:" + err.Error())
+		}
+		authorizationServer = newAuthorizationServer(db, userRepo, tokenService, clientService)
+		consentService = services.NewConsentService(repository.NewConsentRepository(db), repository.NewRefreshTokenRepository(db), repository.NewAccessTokenRepository(db), clientService)
+		consentService.SetAPIScopes(apiScopes())
+		if viper.GetBool("registration.enabled") {
+			registrationService, err = services.NewRegistrationService(clientService, viper.GetStringSlice("registration.initialAccessTokens"), tokenIssuer())
+			if err != nil {
+				logger.Log.Fatal("Failed to initialize client registration:" + err.Error())
+			}
+		}
+		go authorizationServer.RunSweeper(context.Background(), viper.GetDuration("tokens.sweepInterval"))
+		deviceService = newDeviceService(db, userRepo, tokenService, clientService)
+		go deviceService.RunSweeper(context.Background(), viper.GetDuration("tokens.sweepInterval"))
 	}
 
+	vault, err := newTokenVault(db, registry)
+	if err != nil {
+		logger.Log.Fatal("Failed to initialize token vault:" + err.Error())
+	}
+
+	// Initialize Handlers
+	oauthHandler := handlers.NewOAuthHandler(registry, stateStore, accountService, sessionService, tokenService, vault)
+	sessionHandler := handlers.NewSessionHandler(sessionService, accountService, registry, consentService)
+
 	// Routes for the application
-	http.HandleFunc("/", services.HandleMain)
-	http.HandleFunc("/login-gl", googleHandler.GoogleLogin)
-	http.HandleFunc("/callback-gl", googleHandler.GoogleCallback)
-	http.HandleFunc("/login-gh", authHandler.GitHubLogin)
-	http.HandleFunc("/gh-cb", authHandler.GitHubCallback)
+	mux := http.NewServeMux()
+	oauthHandler.RegisterRoutes(mux)
+	sessionHandler.RegisterRoutes(mux)
+	if tokenService != nil {
+		handlers.NewTokenHandler(tokenService, authorizationServer, deviceService).RegisterRoutes(mux)
+		handlers.NewDeviceHandler(deviceService, sessionService, consentService).RegisterRoutes(mux)
+		handlers.NewKeysHandler(signer).RegisterRoutes(mux)
+		handlers.NewAuthorizationHandler(authorizationServer, sessionService, consentService).RegisterRoutes(mux)
+		handlers.NewClientHandler(clientService, tokenService).RegisterRoutes(mux)
+		handlers.NewUserHandler(sessionService, tokenService).RegisterRoutes(mux)
+		if registrationService != nil {
+			handlers.NewRegistrationHandler(registrationService).RegisterRoutes(mux)
+		}
+	}
+
+	// Routes registered with Google and GitHub before /callback/{provider}
+	mux.HandleFunc("GET /login-gl", handlers.For("google", oauthHandler.Login))
+	mux.HandleFunc("GET /callback-gl", handlers.For("google", oauthHandler.Callback))
+	mux.HandleFunc("GET /login-gh", handlers.For("github", oauthHandler.Login))
+	mux.HandleFunc("GET /gh-cb", handlers.For("github", oauthHandler.Callback))
 
 	logger.Log.Info("Started running on http://localhost:" + viper.GetString("port"))
-	log.Fatal(http.ListenAndServe(":"+viper.GetString("port"), nil))
+	log.Fatal(http.ListenAndServe(":"+viper.GetString("port"), mux))
+}
+
+// newStateStore builds the OAuth state store selected by state.store
+func newStateStore(db *sql.DB) (services.StateStore, error) {
+	ttl := viper.GetDuration("state.ttl")
+	secure := viper.GetBool("cookie.secure")
+
+	switch viper.GetString("state.store") {
+	case "memory":
+		return services.NewMemoryStateStore(ttl, secure), nil
+	case "postgres":
+		return services.NewPostgresStateStore(repository.NewStateRepository(db), ttl, secure), nil
+	case "cookie":
+		secret := viper.GetString("state.cookieSecret")
+		if len(secret) < 32 {
+			return nil, fmt.Errorf("state.cookieSecret must be at least 32 characters")
+		}
+		return services.NewCookieStateStore([]byte(secret), ttl, secure), nil
+	default:
+		return nil, fmt.Errorf("unknown state store %q", viper.GetString("state.store"))
+	}
+}
+
+// newSessionStore builds the session store selected by session.store
+func newSessionStore(db *sql.DB) (services.SessionStore, error) {
+	secure := viper.GetBool("cookie.secure")
+
+	switch viper.GetString("session.store") {
+	case "memory":
+		return services.NewMemorySessionStore(secure), nil
+	case "postgres":
+		return services.NewPostgresSessionStore(repository.NewSessionRepository(db), secure), nil
+	case "cookie":
+		keys, err := keyring.ParseKeys(viper.GetStringSlice("session.cookieKeys"))
+		if err != nil {
+			return nil, fmt.Errorf("session.cookieKeys: %v", err)
+		}
+		ring, err := keyring.New(keys...)
+		if err != nil {
+			return nil, fmt.Errorf("session.cookieKeys: %v", err)
+		}
+		return services.NewCookieSessionStore(ring, viper.GetDuration("session.ttl"), secure), nil
+	default:
+		return nil, fmt.Errorf("unknown session store %q", viper.GetString("session.store"))
+	}
+}
+
+// newTokenVault keeps the providers' tokens encrypted with
+// vault.encryptionKeys, or returns nil if no keys are configured
+func newTokenVault(db *sql.DB, registry *services.Registry) (*services.TokenVault, error) {
+	specs := viper.GetStringSlice("vault.encryptionKeys")
+	if len(specs) == 0 {
+		return nil, nil
+	}
+
+	keys, err := keyring.ParseKeys(specs)
+	if err != nil {
+		return nil, fmt.Errorf("vault.encryptionKeys: %v", err)
+	}
+	ring, err := keyring.New(keys...)
+	if err != nil {
+		return nil, fmt.Errorf("vault.encryptionKeys: %v", err)
+	}
+	return services.NewTokenVault(repository.NewProviderTokenRepository(db), ring, registry), nil
+}
+
+// newTokenSigner signs with the managed keys selected by keys.store, or
+// with the single key at tokens.signingKey if keys.store is not set
+func newTokenSigner(db *sql.DB) (services.TokenSigner, error) {
+	if viper.GetString("keys.store") != "" {
+		manager, err := newKeyManager(db)
+		if err != nil {
+			return nil, err
+		}
+		go manager.Run(context.Background(), viper.GetDuration("keys.checkInterval"))
+		return manager, nil
+	}
+
+	pemData, err := os.ReadFile(viper.GetString("tokens.signingKey"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tokens.signingKey: %v", err)
+	}
+	key, err := jwt.ParsePrivateKey(pemData)
+	if err != nil {
+		return nil, fmt.Errorf("tokens.signingKey: %v", err)
+	}
+	return services.NewStaticSigner(key, viper.GetString("tokens.algorithm"))
+}
+
+// newTokenService builds the TokenService from the tokens section
+func newTokenService(db *sql.DB, userRepo repository.UserRepository, signer services.TokenSigner) (*services.TokenService, error) {
+	return services.NewTokenService(signer, repository.NewRefreshTokenRepository(db), repository.NewAccessTokenRepository(db), userRepo, services.TokenConfig{
+		Issuer:            tokenIssuer(),
+		Audience:          viper.GetStringSlice("tokens.audience"),
+		AccessTokenTTL:    viper.GetDuration("tokens.accessTokenTTL"),
+		RefreshTokenTTL:   viper.GetDuration("tokens.refreshTokenTTL"),
+		Claims:            viper.GetStringSlice("tokens.claims"),
+		Roles:             viper.GetStringMapStringSlice("tokens.roles"),
+		AccessTokenFormat: viper.GetString("tokens.accessTokenFormat"),
+	})
+}
+
+// newAuthorizationServer builds the OpenID provider for the registered clients
+func newAuthorizationServer(db *sql.DB, userRepo repository.UserRepository, tokenService *services.TokenService, clientService *services.ClientService) *services.AuthorizationServer {
+	return services.NewAuthorizationServer(clientService, repository.NewAuthorizationCodeRepository(db), tokenService, userRepo, services.AuthorizationServerConfig{
+		Issuer:              tokenIssuer(),
+		CodeTTL:             viper.GetDuration("tokens.codeTTL"),
+		RegistrationEnabled: viper.GetBool("registration.enabled"),
+		APIScopes:           apiScopes(),
+	})
+}
+
+// newDeviceService builds the device authorization grant from the device section
+func newDeviceService(db *sql.DB, userRepo repository.UserRepository, tokenService *services.TokenService, clientService *services.ClientService) *services.DeviceService {
+	return services.NewDeviceService(clientService, repository.NewDeviceCodeRepository(db), tokenService, userRepo, services.DeviceAuthorizationConfig{
+		VerificationURI: strings.TrimSuffix(tokenIssuer(), "/") + "/device",
+		CodeTTL:         viper.GetDuration("device.codeTTL"),
+		Interval:        viper.GetDuration("device.interval"),
+	})
+}
+
+// newClientService manages the clients table
+func newClientService(db *sql.DB) (*services.ClientService, error) {
+	clientService := services.NewClientService(repository.NewClientRepository(db), viper.GetDuration("clients.secretGracePeriod"))
+	if err := clientService.SetAPIScopes(apiScopes()); err != nil {
+		return nil, err
+	}
+	return clientService, nil
+}
+
+// apiScopes reads clients.apiScopes, the scopes of our own API that admins
+// can assign to clients
+func apiScopes() []services.APIScope {
+	var scopes []services.APIScope
+	if err := viper.UnmarshalKey("clients.apiScopes", &scopes); err != nil {
+		logger.Log.Error("Invalid configuration for clients.apiScopes: " + err.Error())
+	}
+	return scopes
+}
+
+// tokenIssuer is the iss of our tokens, defaulting to the local address
+func tokenIssuer() string {
+	if issuer := viper.GetString("tokens.issuer"); issuer != "" {
+		return issuer
+	}
+	return "http://localhost:" + viper.GetString("port")
+}
+
+// providerConfigs reads the google and github sections and every
+// oidc.<name> section. Providers without a clientID are skipped.
+func providerConfigs() []services.ProviderConfig {
+	var configs []services.ProviderConfig
+
+	sections := []struct{ key, name, kind string }{
+		{"google", "google", "google"},
+		{"github", "github", "github"},
+	}
+	for name := range viper.GetStringMap("oidc") {
+		sections = append(sections, struct{ key, name, kind string }{"oidc." + name, name, "oidc"})
+	}
+
+	for _, section := range sections {
+		var cfg services.ProviderConfig
+		if err := viper.UnmarshalKey(section.key, &cfg); err != nil {
+			logger.Log.Error("Invalid configuration for provider " + section.name + ": " + err.Error())
+			continue
+		}
+		if cfg.ClientID == "" {
+			continue
+		}
+
+		cfg.Name = section.name
+		cfg.Type = section.kind
+		if cfg.RedirectURL == "" && cfg.Type == "oidc" {
+			cfg.RedirectURL = "http://localhost:" + viper.GetString("port") + "/callback/" + cfg.Name
+		}
+
+		configs = append(configs, cfg)
+	}
+
+	return configs
 }
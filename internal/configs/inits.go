@@ -2,6 +2,7 @@ package configs
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/spf13/viper"
 )
@@ -14,7 +15,76 @@ func InitializeViper() {
 
 	viper.SetConfigType("yaml")
 
+	setDefaults()
+
 	if err := viper.ReadInConfig(); err != nil {
 		fmt.Println("Error reading config file, ", err)
 	}
 }
+
+func setDefaults() {
+	viper.SetDefault("cookie.secure", true)
+
+	// OAuth state store: memory, postgres or cookie
+	viper.SetDefault("state.store", "memory")
+	viper.SetDefault("state.ttl", 10*time.Minute)
+
+	// Session store: memory, postgres or cookie. The cookie store needs
+	// session.cookieKeys, a list of "<id>:<base64 32-byte key>" with the
+	// current key first.
+	viper.SetDefault("session.store", "memory")
+	viper.SetDefault("session.sweepInterval", 10*time.Minute)
+
+	// Sessions end after ttl, or after idleTimeout without a request
+	viper.SetDefault("session.ttl", 24*time.Hour)
+	viper.SetDefault("session.idleTimeout", 2*time.Hour)
+
+	// Our own access and refresh tokens, signed with the PEM private key at
+	// tokens.signingKey. tokens.roles maps role names to verified user
+	// emails and provider memberships like "github:acme/admins".
+	viper.SetDefault("tokens.enabled", false)
+	viper.SetDefault("tokens.accessTokenTTL", 15*time.Minute)
+	viper.SetDefault("tokens.refreshTokenTTL", 30*24*time.Hour)
+	viper.SetDefault("tokens.claims", []string{"email", "provider", "roles"})
+	viper.SetDefault("tokens.sweepInterval", time.Hour)
+	// jwt or opaque; opaque access tokens can be revoked and introspected
+	viper.SetDefault("tokens.accessTokenFormat", "jwt")
+
+	// With tokens enabled we are also an OpenID provider for the registered
+	// clients, which users with the admin role manage under /admin/clients.
+	// A rotated client secret keeps working for clients.secretGracePeriod.
+	// clients.apiScopes lists the scopes of our own API, each a name and a
+	// description for the consent page, which admins can assign to clients
+	// on top of the OpenID Connect ones; client_credentials tokens carry only
+	// these.
+	viper.SetDefault("tokens.codeTTL", time.Minute)
+	viper.SetDefault("clients.secretGracePeriod", 24*time.Hour)
+
+	// Dynamic client registration at /register, for requests carrying one
+	// of registration.initialAccessTokens
+	viper.SetDefault("registration.enabled", false)
+
+	// Device authorization for CLIs: devices show a code that users enter at
+	// /device within device.codeTTL, polling /token every device.interval
+	viper.SetDefault("device.codeTTL", 10*time.Minute)
+	viper.SetDefault("device.interval", 5*time.Second)
+
+	// The tokens providers issue at login are kept for calling their APIs
+	// later if vault.encryptionKeys is set, a list of
+	// "<id>:<base64 32-byte key>" with the current key first.
+	viper.SetDefault("vault.encryptionKeys", []string{})
+
+	// Providers (google, github and oidc.<name>) may set offline to get a
+	// refresh token for the vault, prompt, and includeGrantedScopes. Google
+	// providers may set hostedDomains to only let Workspace accounts of
+	// those domains in, GitHub providers organizations and teams
+	// ("org/team-slug") to only let their members in.
+
+	// Managed signing keys: keys.store is postgres or file (in keys.dir).
+	// Keys are encrypted with keys.encryptionKeys, a list of
+	// "<id>:<base64 32-byte key>" with the current key first.
+	viper.SetDefault("keys.algorithm", "RS256")
+	viper.SetDefault("keys.rotationPeriod", 30*24*time.Hour)
+	viper.SetDefault("keys.overlap", 24*time.Hour)
+	viper.SetDefault("keys.checkInterval", 5*time.Minute)
+}
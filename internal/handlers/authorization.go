@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"errors"
+	"html/template"
+	"login-with-oauth/internal/helpers/pages"
+	"login-with-oauth/internal/logger"
+	"login-with-oauth/internal/models"
+	"login-with-oauth/internal/services"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var consentTemplate = template.Must(template.New("consent").Parse(pages.ConsentPage))
+
+// AuthorizationHandler serves the OpenID provider endpoints used by our
+// internal apps
+type AuthorizationHandler struct {
+	authorizationServer *services.AuthorizationServer
+	sessionService      *services.SessionService
+	consentService      *services.ConsentService
+}
+
+func NewAuthorizationHandler(authorizationServer *services.AuthorizationServer, sessionService *services.SessionService, consentService *services.ConsentService) *AuthorizationHandler {
+	return &AuthorizationHandler{
+		authorizationServer: authorizationServer,
+		sessionService:      sessionService,
+		consentService:      consentService,
+	}
+}
+
+// RegisterRoutes mounts /authorize, /userinfo and the discovery document
+func (h *AuthorizationHandler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /.well-known/openid-configuration", h.Discovery)
+	mux.HandleFunc("GET /authorize", h.Authorize)
+	mux.HandleFunc("POST /authorize", h.Consent)
+	mux.HandleFunc("GET /userinfo", h.UserInfo)
+	mux.HandleFunc("POST /userinfo", h.UserInfo)
+}
+
+// Discovery serves our OpenID provider metadata
+func (h *AuthorizationHandler) Discovery(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	writeJSON(w, http.StatusOK, h.authorizationServer.Metadata())
+}
+
+// Authorize starts the authorization code flow. Users without a session log
+// in with an upstream provider first and come back here; users who have not
+// approved the client yet are asked to.
+func (h *AuthorizationHandler) Authorize(w http.ResponseWriter, r *http.Request) {
+	req, ok := h.parseRequest(w, r, r.URL.Query())
+	if !ok {
+		return
+	}
+
+	session, user, err := h.sessionService.Current(w, r)
+	if err != nil {
+		if !errors.Is(err, services.ErrNoSession) && !errors.Is(err, services.ErrSessionExpired) {
+			logger.Log.Error("Failed to load session: " + err.Error())
+			h.redirectError(w, r, req, "server_error", "The session could not be loaded.")
+			return
+		}
+		if req.HasPrompt("none") {
+			h.redirectError(w, r, req, "login_required", "The user is not signed in.")
+			return
+		}
+		http.Redirect(w, r, "/?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+		return
+	}
+
+	required, err := h.consentService.Required(user.ID, req)
+	if err != nil {
+		logger.Log.Error("Failed to check consent: " + err.Error())
+		h.redirectError(w, r, req, "server_error", "The consent could not be checked.")
+		return
+	}
+	if required {
+		if req.HasPrompt("none") {
+			h.redirectError(w, r, req, "consent_required", "The user has not approved the client.")
+			return
+		}
+		h.renderConsent(w, req, user, session, r.URL.Query())
+		return
+	}
+
+	h.issueCode(w, r, req, user, session)
+}
+
+// Consent handles the user's answer on the consent page
+func (h *AuthorizationHandler) Consent(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		renderError(w, http.StatusBadRequest, "Invalid request", "The form could not be read. Please try again.")
+		return
+	}
+
+	session, user, err := h.sessionService.Current(w, r)
+	if err != nil {
+		renderError(w, http.StatusUnauthorized, "Not signed in", "Your session has ended. Please sign in to continue.")
+		return
+	}
+	if !validCSRFToken(r, session) {
+		renderError(w, http.StatusForbidden, "Request not allowed", "This form has expired. Please go back and try again.")
+		return
+	}
+
+	params := url.Values{}
+	for name, values := range r.PostForm {
+		if name != "csrf_token" && name != "decision" {
+			params[name] = values
+		}
+	}
+	req, ok := h.parseRequest(w, r, params)
+	if !ok {
+		return
+	}
+
+	if r.PostForm.Get("decision") != "allow" {
+		h.redirectError(w, r, req, "access_denied", "The user denied the request.")
+		return
+	}
+	if err := h.consentService.Grant(user.ID, req.Client.ID, req.Scope); err != nil {
+		logger.Log.Error("Failed to save consent: " + err.Error())
+		h.redirectError(w, r, req, "server_error", "The consent could not be saved.")
+		return
+	}
+
+	h.issueCode(w, r, req, user, session)
+}
+
+// parseRequest checks an authorization request, answering the errors it
+// finds. It reports false if a response was written.
+func (h *AuthorizationHandler) parseRequest(w http.ResponseWriter, r *http.Request, values url.Values) (*services.AuthorizationRequest, bool) {
+	req, err := h.authorizationServer.ParseAuthorizationRequest(values)
+	var authErr *services.AuthorizationError
+	switch {
+	case errors.As(err, &authErr):
+		h.redirectError(w, r, req, authErr.Code, authErr.Description)
+		return nil, false
+	case errors.Is(err, services.ErrUnknownClient):
+		renderError(w, http.StatusBadRequest, "Unknown application", "The application that sent you here is not registered.")
+		return nil, false
+	case errors.Is(err, services.ErrInvalidRedirectURI):
+		renderError(w, http.StatusBadRequest, "Invalid redirect", "The application that sent you here asked to return to an address it has not registered.")
+		return nil, false
+	case err != nil:
+		logger.Log.Error("Failed to parse authorization request: " + err.Error())
+		renderError(w, http.StatusInternalServerError, "Something went wrong", "Could not process the sign-in request. Please try again.")
+		return nil, false
+	}
+	return req, true
+}
+
+// renderConsent asks the user to approve req. The form posts params back to
+// /authorize.
+func (h *AuthorizationHandler) renderConsent(w http.ResponseWriter, req *services.AuthorizationRequest, user *models.User, session *models.Session, params url.Values) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	// The page must not be framed, or other sites could trick users into
+	// clicking Allow
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+	w.WriteHeader(http.StatusOK)
+
+	data := struct {
+		User      *models.User
+		Client    *models.Client
+		Scopes    []services.ScopeDescription
+		Params    url.Values
+		CSRFToken string
+	}{user, req.Client, h.consentService.DescribeScopes(req.Scope), params, session.CSRFToken}
+
+	if err := consentTemplate.Execute(w, data); err != nil {
+		logger.Log.Error("Failed to render consent page: " + err.Error())
+	}
+}
+
+// issueCode redirects to the client with a new authorization code
+func (h *AuthorizationHandler) issueCode(w http.ResponseWriter, r *http.Request, req *services.AuthorizationRequest, user *models.User, session *models.Session) {
+	redirect, err := h.authorizationServer.Authorize(req, user, session)
+	if err != nil {
+		logger.Log.Error("Failed to issue authorization code: " + err.Error())
+		h.redirectError(w, r, req, "server_error", "The authorization code could not be issued.")
+		return
+	}
+
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
+
+// redirectError sends an authorization error back to the client
+func (h *AuthorizationHandler) redirectError(w http.ResponseWriter, r *http.Request, req *services.AuthorizationRequest, code, description string) {
+	params := url.Values{"error": {code}, "error_description": {description}}
+	http.Redirect(w, r, h.authorizationServer.RedirectURL(req, params), http.StatusSeeOther)
+}
+
+// UserInfo returns the claims about the owner of a bearer access token
+func (h *AuthorizationHandler) UserInfo(w http.ResponseWriter, r *http.Request) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		w.Header().Set("WWW-Authenticate", `Bearer`)
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_token", "A bearer access token is required.")
+		return
+	}
+
+	claims, err := h.authorizationServer.UserInfo(token)
+	if errors.Is(err, services.ErrInvalidToken) {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_token", "The access token is invalid or expired.")
+		return
+	}
+	if err != nil {
+		logger.Log.Error("Failed to load userinfo: " + err.Error())
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "The user could not be loaded.")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, claims)
+}
@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"login-with-oauth/internal/logger"
+	"login-with-oauth/internal/models"
+	"login-with-oauth/internal/services"
+	"net/http"
+	"strings"
+)
+
+// adminRole is the tokens.roles role allowed to use the admin API
+const adminRole = "admin"
+
+// ClientHandler serves the admin API for registered clients
+type ClientHandler struct {
+	clientService *services.ClientService
+	tokenService  *services.TokenService
+}
+
+func NewClientHandler(clientService *services.ClientService, tokenService *services.TokenService) *ClientHandler {
+	return &ClientHandler{
+		clientService: clientService,
+		tokenService:  tokenService,
+	}
+}
+
+// clientResponse is a client with its secret, which is only shown when it
+// is created or rotated
+type clientResponse struct {
+	*models.Client
+	Secret string `json:"client_secret,omitempty"`
+}
+
+// RegisterRoutes mounts /admin/clients
+func (h *ClientHandler) RegisterRoutes(mux *http.ServeMux) {
+	mux.Handle("GET /admin/clients", h.RequireAdmin(http.HandlerFunc(h.List)))
+	mux.Handle("POST /admin/clients", h.RequireAdmin(http.HandlerFunc(h.Create)))
+	mux.Handle("GET /admin/clients/{id}", h.RequireAdmin(http.HandlerFunc(h.Get)))
+	mux.Handle("PUT /admin/clients/{id}", h.RequireAdmin(http.HandlerFunc(h.Update)))
+	mux.Handle("DELETE /admin/clients/{id}", h.RequireAdmin(http.HandlerFunc(h.Delete)))
+	mux.Handle("POST /admin/clients/{id}/secret", h.RequireAdmin(http.HandlerFunc(h.RotateSecret)))
+}
+
+// RequireAdmin only lets through requests with a bearer access token of a
+// user holding the admin role
+func (h *ClientHandler) RequireAdmin(next http.Handler) http.Handler {
+	return requireAdmin(h.tokenService, next)
+}
+
+func requireAdmin(tokenService *services.TokenService, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer`)
+			writeOAuthError(w, http.StatusUnauthorized, "invalid_token", "A bearer access token is required.")
+			return
+		}
+
+		user, err := tokenService.RequireRole(token, adminRole)
+		switch {
+		case errors.Is(err, services.ErrInvalidToken):
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			writeOAuthError(w, http.StatusUnauthorized, "invalid_token", "The access token is invalid or expired.")
+			return
+		case errors.Is(err, services.ErrMissingRole):
+			writeOAuthError(w, http.StatusForbidden, "insufficient_scope", "The admin role is required.")
+			return
+		case err != nil:
+			logger.Log.Error("Failed to check admin token: " + err.Error())
+			writeOAuthError(w, http.StatusInternalServerError, "server_error", "The access token could not be checked.")
+			return
+		}
+
+		logger.Log.Info("Admin request " + r.Method + " " + r.URL.Path + " by user " + user.ID)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// List returns every client
+func (h *ClientHandler) List(w http.ResponseWriter, r *http.Request) {
+	clients, err := h.clientService.ListClients()
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	if clients == nil {
+		clients = []models.Client{}
+	}
+
+	writeJSON(w, http.StatusOK, clients)
+}
+
+// Create registers a client and returns its secret
+func (h *ClientHandler) Create(w http.ResponseWriter, r *http.Request) {
+	input, ok := decodeClientInput(w, r)
+	if !ok {
+		return
+	}
+
+	client, secret, err := h.clientService.CreateClient(input)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, clientResponse{client, secret})
+}
+
+// Get returns one client
+func (h *ClientHandler) Get(w http.ResponseWriter, r *http.Request) {
+	client, err := h.clientService.GetClient(r.PathValue("id"))
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, clientResponse{Client: client})
+}
+
+// Update replaces the settings of a client, returning the secret of a
+// client that was made confidential
+func (h *ClientHandler) Update(w http.ResponseWriter, r *http.Request) {
+	input, ok := decodeClientInput(w, r)
+	if !ok {
+		return
+	}
+
+	client, secret, err := h.clientService.UpdateClient(r.PathValue("id"), input)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, clientResponse{client, secret})
+}
+
+// Delete removes a client
+func (h *ClientHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	if err := h.clientService.DeleteClient(r.PathValue("id")); err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RotateSecret issues a new secret; the old one works for the grace period
+func (h *ClientHandler) RotateSecret(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	secret, err := h.clientService.RotateSecret(id)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	client, err := h.clientService.GetClient(id)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, clientResponse{client, secret})
+}
+
+func (h *ClientHandler) writeError(w http.ResponseWriter, err error) {
+	var validationErr *services.ClientValidationError
+	switch {
+	case errors.As(err, &validationErr):
+		writeOAuthError(w, http.StatusBadRequest, validationErr.Code, validationErr.Message)
+	case errors.Is(err, services.ErrClientNotFound):
+		writeOAuthError(w, http.StatusNotFound, "not_found", "The client does not exist.")
+	default:
+		logger.Log.Error("Failed to manage client: " + err.Error())
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "The request could not be completed.")
+	}
+}
+
+// decodeClientInput reads a JSON client description from the request body
+func decodeClientInput(w http.ResponseWriter, r *http.Request) (services.ClientInput, bool) {
+	var input services.ClientInput
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&input); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "The request body is not a valid client description.")
+		return input, false
+	}
+	return input, true
+}
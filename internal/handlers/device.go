@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"errors"
+	"html/template"
+	"login-with-oauth/internal/helpers/pages"
+	"login-with-oauth/internal/logger"
+	"login-with-oauth/internal/models"
+	"login-with-oauth/internal/services"
+	"net/http"
+	"net/url"
+)
+
+var deviceTemplate = template.Must(template.New("device").Parse(pages.DevicePage))
+
+// DeviceHandler serves the page where users approve the devices that show
+// them a user code
+type DeviceHandler struct {
+	deviceService  *services.DeviceService
+	sessionService *services.SessionService
+	consentService *services.ConsentService
+}
+
+func NewDeviceHandler(deviceService *services.DeviceService, sessionService *services.SessionService, consentService *services.ConsentService) *DeviceHandler {
+	return &DeviceHandler{
+		deviceService:  deviceService,
+		sessionService: sessionService,
+		consentService: consentService,
+	}
+}
+
+// RegisterRoutes mounts /device
+func (h *DeviceHandler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /device", h.Device)
+	mux.HandleFunc("POST /device", h.Decide)
+}
+
+// devicePageData is what DevicePage expects
+type devicePageData struct {
+	User      *models.User
+	Client    *models.Client
+	Scopes    []services.ScopeDescription
+	UserCode  string
+	CSRFToken string
+	Error     string
+	Message   string
+}
+
+// Device asks for the user code, or for approval of the device once the
+// code is known. Users without a session log in first and come back here.
+func (h *DeviceHandler) Device(w http.ResponseWriter, r *http.Request) {
+	session, user, err := h.sessionService.Current(w, r)
+	if err != nil {
+		if !errors.Is(err, services.ErrNoSession) && !errors.Is(err, services.ErrSessionExpired) {
+			logger.Log.Error("Failed to load session: " + err.Error())
+			renderError(w, http.StatusInternalServerError, "Something went wrong", "Could not load your session. Please try again.")
+			return
+		}
+		http.Redirect(w, r, "/?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+		return
+	}
+
+	userCode := r.URL.Query().Get("user_code")
+	if userCode == "" {
+		h.render(w, devicePageData{User: user})
+		return
+	}
+
+	code, client, ok := h.lookup(w, user, userCode)
+	if !ok {
+		return
+	}
+
+	h.render(w, devicePageData{
+		User:      user,
+		Client:    client,
+		Scopes:    h.consentService.DescribeScopes(code.Scope),
+		UserCode:  code.UserCode,
+		CSRFToken: session.CSRFToken,
+	})
+}
+
+// Decide handles the user's answer for a device
+func (h *DeviceHandler) Decide(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		renderError(w, http.StatusBadRequest, "Invalid request", "The form could not be read. Please try again.")
+		return
+	}
+
+	session, user, err := h.sessionService.Current(w, r)
+	if err != nil {
+		renderError(w, http.StatusUnauthorized, "Not signed in", "Your session has ended. Please sign in to continue.")
+		return
+	}
+	if !validCSRFToken(r, session) {
+		renderError(w, http.StatusForbidden, "Request not allowed", "This form has expired. Please go back and try again.")
+		return
+	}
+
+	code, client, ok := h.lookup(w, user, r.PostForm.Get("user_code"))
+	if !ok {
+		return
+	}
+
+	if r.PostForm.Get("decision") != "allow" {
+		if err := h.deviceService.Deny(code, user); err != nil && !errors.Is(err, services.ErrUnknownUserCode) {
+			logger.Log.Error("Failed to deny device: " + err.Error())
+			renderError(w, http.StatusInternalServerError, "Something went wrong", "Could not save your answer. Please try again.")
+			return
+		}
+		h.render(w, devicePageData{User: user, Message: "The device was not allowed to access your account."})
+		return
+	}
+
+	err = h.deviceService.Approve(code, user, session)
+	if errors.Is(err, services.ErrUnknownUserCode) {
+		h.render(w, devicePageData{User: user, Error: "That code was already used or has expired. Check the code shown on your device."})
+		return
+	}
+	if err != nil {
+		logger.Log.Error("Failed to approve device: " + err.Error())
+		renderError(w, http.StatusInternalServerError, "Something went wrong", "Could not save your answer. Please try again.")
+		return
+	}
+	if h.consentService != nil {
+		if err := h.consentService.Grant(user.ID, client.ID, code.Scope); err != nil {
+			logger.Log.Warn("Failed to save consent of device " + client.ID + ": " + err.Error())
+		}
+	}
+
+	h.render(w, devicePageData{User: user, Message: "Your device is connected. You can close this page and return to it."})
+}
+
+// lookup finds the device of a user code, asking for the code again if it
+// is not valid. It reports false if a response was written.
+func (h *DeviceHandler) lookup(w http.ResponseWriter, user *models.User, userCode string) (*models.DeviceCode, *models.Client, bool) {
+	code, client, err := h.deviceService.Lookup(userCode)
+	if errors.Is(err, services.ErrUnknownUserCode) {
+		h.render(w, devicePageData{User: user, UserCode: userCode, Error: "That code is not valid or has expired. Check the code shown on your device."})
+		return nil, nil, false
+	}
+	if err != nil {
+		logger.Log.Error("Failed to look up user code: " + err.Error())
+		renderError(w, http.StatusInternalServerError, "Something went wrong", "Could not check the code. Please try again.")
+		return nil, nil, false
+	}
+	return code, client, true
+}
+
+func (h *DeviceHandler) render(w http.ResponseWriter, data devicePageData) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	// The page must not be framed, or other sites could trick users into
+	// clicking Allow
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+	w.WriteHeader(http.StatusOK)
+
+	if err := deviceTemplate.Execute(w, data); err != nil {
+		logger.Log.Error("Failed to render device page: " + err.Error())
+	}
+}
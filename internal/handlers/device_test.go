@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"login-with-oauth/internal/models"
+	"login-with-oauth/internal/repository"
+	"login-with-oauth/internal/repository/mock"
+	"login-with-oauth/internal/services"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeviceFlow(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	signer, err := services.NewStaticSigner(key, "")
+	require.NoError(t, err)
+
+	userRepo := mock.NewMockUserRepository(ctrl)
+	clientRepo := mock.NewMockClientRepository(ctrl)
+	deviceRepo := mock.NewMockDeviceCodeRepository(ctrl)
+	user := &models.User{ID: "123", Username: "testuser", Email: "test@example.com"}
+	userRepo.EXPECT().GetUserByID("123").Return(user, nil).AnyTimes()
+	clientRepo.EXPECT().GetClient("cli").Return(&models.Client{
+		ID:         "cli",
+		Name:       "Deploy CLI",
+		Public:     true,
+		Scopes:     []string{"openid", "email"},
+		GrantTypes: []string{services.DeviceCodeGrantType},
+	}, nil).AnyTimes()
+
+	// codes stands in for the device_codes table
+	codes := map[string]models.DeviceCode{}
+	deviceRepo.EXPECT().CreateDeviceCode(gomock.Any()).DoAndReturn(func(code models.DeviceCode) error {
+		codes[code.ID] = code
+		return nil
+	}).AnyTimes()
+	deviceRepo.EXPECT().GetDeviceCode(gomock.Any()).DoAndReturn(func(id string) (*models.DeviceCode, error) {
+		code, ok := codes[id]
+		if !ok {
+			return nil, repository.ErrNotFound
+		}
+		return &code, nil
+	}).AnyTimes()
+	deviceRepo.EXPECT().GetDeviceCodeByUserCode(gomock.Any()).DoAndReturn(func(userCode string) (*models.DeviceCode, error) {
+		for _, code := range codes {
+			if code.UserCode == userCode {
+				return &code, nil
+			}
+		}
+		return nil, repository.ErrNotFound
+	}).AnyTimes()
+	deviceRepo.EXPECT().DecideDeviceCode(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(func(id, status, userID, provider string, authTime time.Time) error {
+		code := codes[id]
+		code.Status, code.UserID, code.Provider, code.AuthTime = status, userID, provider, &authTime
+		codes[id] = code
+		return nil
+	}).AnyTimes()
+	deviceRepo.EXPECT().UpdateDeviceCodePoll(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(func(id string, polledAt time.Time, interval int) error {
+		code := codes[id]
+		code.LastPolledAt, code.Interval = &polledAt, interval
+		codes[id] = code
+		return nil
+	}).AnyTimes()
+	deviceRepo.EXPECT().DeleteDeviceCode(gomock.Any()).DoAndReturn(func(id string) error {
+		delete(codes, id)
+		return nil
+	}).AnyTimes()
+
+	tokenService, err := services.NewTokenService(signer, mock.NewMockRefreshTokenRepository(ctrl), mock.NewMockAccessTokenRepository(ctrl), userRepo, services.TokenConfig{
+		Issuer:         "https://auth.example.com",
+		AccessTokenTTL: 15 * time.Minute,
+	})
+	require.NoError(t, err)
+	clientService := services.NewClientService(clientRepo, time.Hour)
+	authorizationServer := services.NewAuthorizationServer(clientService, mock.NewMockAuthorizationCodeRepository(ctrl), tokenService, userRepo, services.AuthorizationServerConfig{
+		Issuer: "https://auth.example.com",
+	})
+	deviceService := services.NewDeviceService(clientService, deviceRepo, tokenService, userRepo, services.DeviceAuthorizationConfig{
+		VerificationURI: "https://auth.example.com/device",
+		CodeTTL:         10 * time.Minute,
+		Interval:        5 * time.Second,
+	})
+	sessionService := services.NewSessionService(services.NewMemorySessionStore(false), userRepo, time.Hour, 0)
+
+	mux := http.NewServeMux()
+	NewTokenHandler(tokenService, authorizationServer, deviceService).RegisterRoutes(mux)
+	NewDeviceHandler(deviceService, sessionService, nil).RegisterRoutes(mux)
+
+	post := func(path string, form url.Values, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+	oauthError := func(t *testing.T, rec *httptest.ResponseRecorder) string {
+		var body struct {
+			Error string `json:"error"`
+		}
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&body))
+		return body.Error
+	}
+
+	rec := post("/device/code", url.Values{"client_id": {"cli"}, "scope": {"openid email"}})
+	require.Equal(t, http.StatusOK, rec.Code)
+	var started services.DeviceAuthorizationResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&started))
+	assert.NotEmpty(t, started.DeviceCode)
+	assert.NotEmpty(t, started.UserCode)
+	assert.Equal(t, "https://auth.example.com/device", started.VerificationURI)
+
+	poll := url.Values{"grant_type": {services.DeviceCodeGrantType}, "client_id": {"cli"}, "device_code": {started.DeviceCode}}
+
+	t.Run("Pending", func(t *testing.T) {
+		rec := post("/token", poll)
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Equal(t, "authorization_pending", oauthError(t, rec))
+	})
+
+	t.Run("SlowDown", func(t *testing.T) {
+		rec := post("/token", poll)
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Equal(t, "slow_down", oauthError(t, rec))
+	})
+
+	t.Run("LoginRequired", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/device?user_code="+started.UserCode, nil))
+
+		assert.Equal(t, http.StatusSeeOther, rec.Code)
+		assert.Equal(t, "/?next="+url.QueryEscape("/device?user_code="+started.UserCode), rec.Header().Get("Location"))
+	})
+
+	login := httptest.NewRecorder()
+	session, err := sessionService.Start(login, httptest.NewRequest(http.MethodGet, "/", nil), user, "github")
+	require.NoError(t, err)
+	cookies := login.Result().Cookies()
+
+	t.Run("UnknownCode", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/device?user_code=BCDF-GHJK", nil)
+		req.AddCookie(cookies[0])
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), "not valid or has expired")
+	})
+
+	t.Run("Confirm", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/device?user_code="+strings.ToLower(started.UserCode), nil)
+		req.AddCookie(cookies[0])
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), "Deploy CLI wants to access your account")
+		assert.Contains(t, rec.Body.String(), started.UserCode)
+	})
+
+	t.Run("MissingCSRFToken", func(t *testing.T) {
+		rec := post("/device", url.Values{"user_code": {started.UserCode}, "decision": {"allow"}}, cookies...)
+
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+	})
+
+	t.Run("Approve", func(t *testing.T) {
+		rec := post("/device", url.Values{"user_code": {started.UserCode}, "decision": {"allow"}, "csrf_token": {session.CSRFToken}}, cookies...)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), "Your device is connected")
+	})
+
+	t.Run("Tokens", func(t *testing.T) {
+		rec := post("/token", poll)
+		require.Equal(t, http.StatusOK, rec.Code)
+		var issued services.TokenResponse
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&issued))
+		assert.NotEmpty(t, issued.AccessToken)
+		assert.NotEmpty(t, issued.IDToken)
+
+		claims, err := tokenService.VerifyAccessToken(issued.AccessToken)
+		require.NoError(t, err)
+		assert.Equal(t, "123", claims.Subject)
+		assert.Equal(t, "cli", claims.ClientID)
+	})
+
+	t.Run("AlreadyUsed", func(t *testing.T) {
+		rec := post("/token", poll)
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Equal(t, "invalid_grant", oauthError(t, rec))
+	})
+}
@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"errors"
+	"html/template"
+	"login-with-oauth/internal/helpers/pages"
+	"login-with-oauth/internal/logger"
+	"login-with-oauth/internal/services"
+	"net/http"
+)
+
+var errorTemplate = template.Must(template.New("error").Parse(pages.ErrorPage))
+
+// renderError writes the error page with the given status
+func renderError(w http.ResponseWriter, status int, title, message string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+
+	data := struct {
+		Title   string
+		Message string
+	}{title, message}
+
+	if err := errorTemplate.Execute(w, data); err != nil {
+		logger.Log.Error("Failed to render error page: " + err.Error())
+	}
+}
+
+// renderStateError explains why a callback's state was rejected
+func renderStateError(w http.ResponseWriter, provider string, err error) {
+	logger.Log.Warn("Rejected " + provider + " callback: " + err.Error())
+
+	message := "This sign-in link is not valid. Please start the sign-in again."
+	switch {
+	case errors.Is(err, services.ErrStateExpired):
+		message = "This sign-in attempt took too long and has expired. Please start the sign-in again."
+	case errors.Is(err, services.ErrStateNotFound):
+		message = "This sign-in attempt has already been used or is unknown. Please start the sign-in again."
+	case errors.Is(err, services.ErrStateMismatch):
+		message = "This sign-in attempt was started in a different browser. Please start the sign-in again here."
+	}
+
+	renderError(w, http.StatusBadRequest, "Sign-in could not be verified", message)
+}
@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"encoding/json"
+	"login-with-oauth/internal/logger"
+	"login-with-oauth/internal/services"
+	"net/http"
+)
+
+// KeysHandler publishes the public keys our tokens are signed with
+type KeysHandler struct {
+	signer services.TokenSigner
+}
+
+func NewKeysHandler(signer services.TokenSigner) *KeysHandler {
+	return &KeysHandler{
+		signer: signer,
+	}
+}
+
+// RegisterRoutes mounts /.well-known/jwks.json
+func (h *KeysHandler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /.well-known/jwks.json", h.JWKS)
+}
+
+// JWKS serves the key set. Verifiers may cache it briefly and refetch it
+// when they meet an unknown kid.
+func (h *KeysHandler) JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.WriteHeader(http.StatusOK)
+
+	if err := json.NewEncoder(w).Encode(h.signer.KeySet()); err != nil {
+		logger.Log.Error("Failed to write JWKS: " + err.Error())
+	}
+}
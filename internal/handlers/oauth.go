@@ -0,0 +1,398 @@
+package handlers
+
+import (
+	"errors"
+	"html/template"
+	"login-with-oauth/internal/helpers/pages"
+	"login-with-oauth/internal/logger"
+	"login-with-oauth/internal/models"
+	"login-with-oauth/internal/services"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+var (
+	indexTemplate    = template.Must(template.New("index").Parse(pages.IndexPage))
+	conflictTemplate = template.Must(template.New("conflict").Parse(pages.ConflictPage))
+)
+
+// OAuthHandler serves the login and callback routes of every registered provider
+type OAuthHandler struct {
+	registry       *services.Registry
+	stateStore     services.StateStore
+	accountService *services.AccountService
+	sessionService *services.SessionService
+	tokenService   *services.TokenService
+	vault          *services.TokenVault
+}
+
+// NewOAuthHandler creates a new OAuthHandler. tokenService may be nil if we
+// do not issue our own tokens, and vault if we do not keep the providers'
+// tokens.
+func NewOAuthHandler(registry *services.Registry, stateStore services.StateStore, accountService *services.AccountService, sessionService *services.SessionService, tokenService *services.TokenService, vault *services.TokenVault) *OAuthHandler {
+	return &OAuthHandler{
+		registry:       registry,
+		stateStore:     stateStore,
+		accountService: accountService,
+		sessionService: sessionService,
+		tokenService:   tokenService,
+		vault:          vault,
+	}
+}
+
+// RegisterRoutes mounts the index page and /login/{provider},
+// /connect/{provider}, /connect/{provider}/scopes and /callback/{provider}
+// for all providers
+func (h *OAuthHandler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /{$}", h.Index)
+	mux.HandleFunc("GET /login/{provider}", h.Login)
+	mux.HandleFunc("POST /connect/{provider}", h.Connect)
+	mux.HandleFunc("GET /connect/{provider}/scopes", h.RequestScopes)
+	mux.HandleFunc("GET /callback/{provider}", h.Callback)
+}
+
+// Index lists the providers users can log in with
+func (h *OAuthHandler) Index(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+
+	data := struct {
+		Providers []services.Provider
+		Next      string
+	}{h.registry.Providers(), localPath(r.URL.Query().Get("next"))}
+
+	if err := indexTemplate.Execute(w, data); err != nil {
+		logger.Log.Error("Failed to render index page: " + err.Error())
+	}
+}
+
+// For serves handler with the provider fixed, for routes that predate
+// /login/{provider} and /callback/{provider}
+func For(provider string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.SetPathValue("provider", provider)
+		handler(w, r)
+	}
+}
+
+func (h *OAuthHandler) provider(w http.ResponseWriter, r *http.Request) (services.Provider, bool) {
+	provider, ok := h.registry.Get(r.PathValue("provider"))
+	if !ok {
+		renderError(w, http.StatusNotFound, "Unknown sign-in method", "This sign-in method is not available.")
+	}
+	return provider, ok
+}
+
+// Login sends the browser to the provider's authorize URL. With
+// response=tokens the callback answers with our own access and refresh
+// tokens instead of starting a session.
+func (h *OAuthHandler) Login(w http.ResponseWriter, r *http.Request) {
+	provider, ok := h.provider(w, r)
+	if !ok {
+		return
+	}
+
+	// Generate a random state and remember it for this browser
+	state, err := services.NewOAuthState(provider.Name())
+	if err != nil {
+		logger.Log.Error("Failed to generate " + provider.Name() + " state: " + err.Error())
+		renderError(w, http.StatusInternalServerError, "Sign-in failed", "Could not start the sign-in. Please try again.")
+		return
+	}
+	state.ReturnTo = localPath(r.URL.Query().Get("next"))
+	state.LoginHint = r.URL.Query().Get("login_hint")
+	if r.URL.Query().Get("response") == "tokens" {
+		if h.tokenService == nil {
+			renderError(w, http.StatusBadRequest, "Sign-in failed", "Tokens are not issued by this server.")
+			return
+		}
+		state.IssueTokens = true
+	}
+
+	authURL := provider.AuthURL(state)
+	if err := h.stateStore.Issue(w, r, state); err != nil {
+		logger.Log.Error("Failed to issue " + provider.Name() + " state: " + err.Error())
+		renderError(w, http.StatusInternalServerError, "Sign-in failed", "Could not start the sign-in. Please try again.")
+		return
+	}
+
+	http.Redirect(w, r, authURL, http.StatusTemporaryRedirect)
+}
+
+// Connect sends a logged-in user to the provider to link another login to
+// their account. The form must carry the session's CSRF token so that other
+// sites cannot start it. With reconsent set, the provider asks the user to
+// consent again so that it issues a new refresh token.
+func (h *OAuthHandler) Connect(w http.ResponseWriter, r *http.Request) {
+	provider, ok := h.provider(w, r)
+	if !ok {
+		return
+	}
+
+	session, user, err := h.sessionService.Current(w, r)
+	if err != nil {
+		renderError(w, http.StatusUnauthorized, "Not signed in", "Your session has ended. Please sign in to continue.")
+		return
+	}
+	if !validCSRFToken(r, session) {
+		renderError(w, http.StatusForbidden, "Request not allowed", "This form has expired. Please go back and try again.")
+		return
+	}
+
+	state, err := services.NewOAuthState(provider.Name())
+	if err != nil {
+		logger.Log.Error("Failed to generate " + provider.Name() + " state: " + err.Error())
+		renderError(w, http.StatusInternalServerError, "Connecting failed", "Could not start connecting your account. Please try again.")
+		return
+	}
+	state.ConnectUserID = user.ID
+	state.ReturnTo = "/account"
+	state.LoginHint = r.PostFormValue("login_hint")
+	if r.PostFormValue("reconsent") != "" {
+		state.Prompt = "consent"
+	}
+
+	authURL := provider.AuthURL(state)
+	if err := h.stateStore.Issue(w, r, state); err != nil {
+		logger.Log.Error("Failed to issue " + provider.Name() + " state: " + err.Error())
+		renderError(w, http.StatusInternalServerError, "Connecting failed", "Could not start connecting your account. Please try again.")
+		return
+	}
+
+	http.Redirect(w, r, authURL, http.StatusSeeOther)
+}
+
+// ScopesURL is where handlers send a logged-in user who has not granted a
+// provider all of scopes yet. The user comes back to next.
+func ScopesURL(provider string, scopes []string, next string) string {
+	query := url.Values{"scope": {strings.Join(scopes, " ")}, "next": {next}}
+	return "/connect/" + url.PathEscape(provider) + "/scopes?" + query.Encode()
+}
+
+// RequestScopes sends a logged-in user back to the provider to grant the
+// scopes in the scope parameter they have not granted yet, and then on to
+// next. Users who granted them all go straight to next. Being a GET without a
+// CSRF token, it only re-asks for a login that is already linked; users
+// without one are sent to /account to connect it there.
+func (h *OAuthHandler) RequestScopes(w http.ResponseWriter, r *http.Request) {
+	provider, ok := h.provider(w, r)
+	if !ok {
+		return
+	}
+
+	_, user, err := h.sessionService.Current(w, r)
+	if err != nil {
+		if !errors.Is(err, services.ErrNoSession) && !errors.Is(err, services.ErrSessionExpired) {
+			logger.Log.Error("Failed to load session: " + err.Error())
+			renderError(w, http.StatusInternalServerError, "Something went wrong", "Could not load your session. Please try again.")
+			return
+		}
+		http.Redirect(w, r, "/?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+		return
+	}
+
+	returnTo := localPath(r.URL.Query().Get("next"))
+	if returnTo == "" {
+		returnTo = "/account"
+	}
+
+	missing, identity, err := h.accountService.MissingScopes(user.ID, provider.Name(), strings.Fields(r.URL.Query().Get("scope")))
+	if err != nil {
+		logger.Log.Error("Failed to check " + provider.Name() + " scopes: " + err.Error())
+		renderError(w, http.StatusInternalServerError, "Something went wrong", "Could not check your account. Please try again.")
+		return
+	}
+	if identity == nil {
+		http.Redirect(w, r, "/account", http.StatusSeeOther)
+		return
+	}
+	if len(missing) == 0 {
+		http.Redirect(w, r, returnTo, http.StatusSeeOther)
+		return
+	}
+
+	state, err := services.NewOAuthState(provider.Name())
+	if err != nil {
+		logger.Log.Error("Failed to generate " + provider.Name() + " state: " + err.Error())
+		renderError(w, http.StatusInternalServerError, "Connecting failed", "Could not start connecting your account. Please try again.")
+		return
+	}
+	state.ConnectUserID = user.ID
+	state.ReturnTo = returnTo
+	state.Scopes = missing
+	state.LoginHint = identity.Email
+
+	authURL := provider.AuthURL(state)
+	if err := h.stateStore.Issue(w, r, state); err != nil {
+		logger.Log.Error("Failed to issue " + provider.Name() + " state: " + err.Error())
+		renderError(w, http.StatusInternalServerError, "Connecting failed", "Could not start connecting your account. Please try again.")
+		return
+	}
+
+	http.Redirect(w, r, authURL, http.StatusSeeOther)
+}
+
+// Callback verifies the state, exchanges the code and starts a session for
+// the user
+func (h *OAuthHandler) Callback(w http.ResponseWriter, r *http.Request) {
+	provider, ok := h.provider(w, r)
+	if !ok {
+		return
+	}
+	name := provider.Name()
+
+	// Verify state was issued to this browser for this provider and has not
+	// been used yet
+	state, err := h.stateStore.Consume(w, r, r.URL.Query().Get("state"))
+	if err == nil && state.Provider != name {
+		err = services.ErrStateMismatch
+	}
+	if err != nil {
+		renderStateError(w, name, err)
+		return
+	}
+
+	if reason := r.URL.Query().Get("error"); reason != "" {
+		logger.Log.Info(name + " sign-in was not completed: " + reason)
+		renderError(w, http.StatusUnauthorized, "Sign-in cancelled", "The sign-in was not completed. Please try again.")
+		return
+	}
+
+	code := r.URL.Query().Get("code")
+	if code == "" {
+		http.Error(w, "Code not found", http.StatusBadRequest)
+		return
+	}
+
+	// Exchange code for token
+	token, err := provider.Exchange(r.Context(), code, state)
+	if errors.Is(err, services.ErrPKCEVerifierMissing) {
+		renderStateError(w, name, err)
+		return
+	}
+	if err != nil {
+		logger.Log.Error("Failed to exchange " + name + " code: " + err.Error())
+		http.Error(w, "Failed to exchange token", http.StatusInternalServerError)
+		return
+	}
+
+	// Get user data
+	identity, err := provider.FetchIdentity(r.Context(), token, state)
+	if errors.Is(err, services.ErrNoVerifiedEmail) {
+		logger.Log.Info("Refused " + name + " sign-in without a verified email")
+		renderError(w, http.StatusForbidden, "Verified email required", "Your account has no verified primary email address. Verify an email address with "+provider.DisplayName()+" and sign in again.")
+		return
+	}
+	if errors.Is(err, services.ErrHostedDomainNotAllowed) || errors.Is(err, services.ErrNotOrganizationMember) {
+		logger.Log.Warn("Denied " + name + " sign-in: " + err.Error())
+		renderError(w, http.StatusForbidden, "Access denied", "Only accounts of our organisation can sign in with "+provider.DisplayName()+". Please sign in with your work account.")
+		return
+	}
+	if err != nil {
+		logger.Log.Error("Failed to get " + name + " user data: " + err.Error())
+		http.Error(w, "Failed to get user data", http.StatusInternalServerError)
+		return
+	}
+	identity.GrantedScopes = services.GrantedScopes(token, state)
+
+	if state.ConnectUserID != "" {
+		h.connect(w, r, provider, state, identity, token)
+		return
+	}
+
+	user, err := h.accountService.CompleteLogin(identity)
+	if errors.Is(err, services.ErrEmailInUse) {
+		logger.Log.Info("Refused " + name + " sign-in for an email that belongs to another account")
+		renderConflict(w, conflictPageData{Provider: provider.DisplayName(), Email: identity.Email})
+		return
+	}
+	if err != nil {
+		logger.Log.Error("Failed to store " + name + " user: " + err.Error())
+		http.Error(w, "Failed to get user data", http.StatusInternalServerError)
+		return
+	}
+	h.storeProviderToken(identity, token)
+
+	if state.IssueTokens && h.tokenService != nil {
+		response, err := h.tokenService.Issue(user, name)
+		if err != nil {
+			logger.Log.Error("Failed to issue tokens for " + name + " user: " + err.Error())
+			writeOAuthError(w, http.StatusInternalServerError, "server_error", "The token could not be issued.")
+			return
+		}
+		writeJSON(w, http.StatusOK, response)
+		return
+	}
+
+	if _, err := h.sessionService.Start(w, r, user, name); err != nil {
+		logger.Log.Error("Failed to start session for " + name + " user: " + err.Error())
+		renderError(w, http.StatusInternalServerError, "Sign-in failed", "Could not start your session. Please try again.")
+		return
+	}
+
+	returnTo := state.ReturnTo
+	if returnTo == "" {
+		returnTo = "/account"
+	}
+	http.Redirect(w, r, returnTo, http.StatusSeeOther)
+}
+
+// connect links the identity from a callback to the user who started
+// connecting it, who must still be logged in
+func (h *OAuthHandler) connect(w http.ResponseWriter, r *http.Request, provider services.Provider, state *models.OAuthState, identity *models.Identity, token *oauth2.Token) {
+	name := provider.Name()
+
+	_, user, err := h.sessionService.Current(w, r)
+	if err != nil || user.ID != state.ConnectUserID {
+		renderError(w, http.StatusUnauthorized, "Not signed in", "Your session has ended. Please sign in and connect "+provider.DisplayName()+" again.")
+		return
+	}
+
+	err = h.accountService.Connect(user, identity)
+	if errors.Is(err, services.ErrIdentityInUse) {
+		logger.Log.Info("Refused to connect a " + name + " login of another account to user " + user.ID)
+		renderConflict(w, conflictPageData{Provider: provider.DisplayName(), Email: identity.Email, Connecting: true})
+		return
+	}
+	if err != nil {
+		logger.Log.Error("Failed to connect " + name + " login: " + err.Error())
+		renderError(w, http.StatusInternalServerError, "Connecting failed", "Could not connect your account. Please try again.")
+		return
+	}
+
+	logger.Log.Info("Connected a " + name + " login to user " + user.ID)
+	h.storeProviderToken(identity, token)
+	http.Redirect(w, r, state.ReturnTo, http.StatusSeeOther)
+}
+
+// storeProviderToken keeps the provider's token in the vault. Logging in
+// does not depend on it, so failures are only logged.
+func (h *OAuthHandler) storeProviderToken(identity *models.Identity, token *oauth2.Token) {
+	if h.vault == nil {
+		return
+	}
+	if err := h.vault.Store(identity, token); err != nil {
+		logger.Log.Warn("Failed to store " + identity.Provider + " token: " + err.Error())
+	}
+}
+
+// conflictPageData is what ConflictPage expects
+type conflictPageData struct {
+	Provider   string
+	Email      string
+	Connecting bool
+}
+
+// renderConflict explains that a login belongs to another account
+func renderConflict(w http.ResponseWriter, data conflictPageData) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusConflict)
+
+	if err := conflictTemplate.Execute(w, data); err != nil {
+		logger.Log.Error("Failed to render conflict page: " + err.Error())
+	}
+}
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"login-with-oauth/internal/logger"
+	"login-with-oauth/internal/services"
+	"net/http"
+	"strings"
+)
+
+// RegistrationHandler serves dynamic client registration
+type RegistrationHandler struct {
+	registrationService *services.RegistrationService
+}
+
+func NewRegistrationHandler(registrationService *services.RegistrationService) *RegistrationHandler {
+	return &RegistrationHandler{
+		registrationService: registrationService,
+	}
+}
+
+// RegisterRoutes mounts /register
+func (h *RegistrationHandler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("POST /register", h.Register)
+	mux.HandleFunc("GET /register/{id}", h.Read)
+	mux.HandleFunc("PUT /register/{id}", h.Update)
+	mux.HandleFunc("DELETE /register/{id}", h.Delete)
+}
+
+// Register creates a client (RFC 7591 section 3). The request must carry an
+// initial access token as its bearer token.
+func (h *RegistrationHandler) Register(w http.ResponseWriter, r *http.Request) {
+	metadata, ok := decodeClientMetadata(w, r)
+	if !ok {
+		return
+	}
+
+	response, err := h.registrationService.Register(bearerToken(r), metadata)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, response)
+}
+
+// Read returns a client's registration (RFC 7592 section 2.1)
+func (h *RegistrationHandler) Read(w http.ResponseWriter, r *http.Request) {
+	response, err := h.registrationService.Read(r.PathValue("id"), bearerToken(r))
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// Update replaces a client's metadata (RFC 7592 section 2.2)
+func (h *RegistrationHandler) Update(w http.ResponseWriter, r *http.Request) {
+	metadata, ok := decodeClientMetadata(w, r)
+	if !ok {
+		return
+	}
+
+	response, err := h.registrationService.Update(r.PathValue("id"), bearerToken(r), metadata)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// Delete removes a client (RFC 7592 section 2.3)
+func (h *RegistrationHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	if err := h.registrationService.Delete(r.PathValue("id"), bearerToken(r)); err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *RegistrationHandler) writeError(w http.ResponseWriter, err error) {
+	var validationErr *services.ClientValidationError
+	switch {
+	case errors.As(err, &validationErr):
+		writeOAuthError(w, http.StatusBadRequest, validationErr.Code, validationErr.Message)
+	case errors.Is(err, services.ErrInvalidInitialAccessToken), errors.Is(err, services.ErrInvalidRegistrationToken):
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_token", "The access token is missing or invalid.")
+	default:
+		logger.Log.Error("Failed to handle client registration: " + err.Error())
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "The request could not be completed.")
+	}
+}
+
+// bearerToken returns the bearer token of the Authorization header, if any
+func bearerToken(r *http.Request) string {
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return token
+}
+
+// decodeClientMetadata reads RFC 7591 client metadata from the request body
+func decodeClientMetadata(w http.ResponseWriter, r *http.Request) (services.ClientMetadata, bool) {
+	var metadata services.ClientMetadata
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&metadata); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_client_metadata", "The request body is not valid client metadata.")
+		return metadata, false
+	}
+	return metadata, true
+}
@@ -0,0 +1,249 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"html/template"
+	"login-with-oauth/internal/helpers/pages"
+	"login-with-oauth/internal/logger"
+	"login-with-oauth/internal/models"
+	"login-with-oauth/internal/services"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var (
+	accountTemplate      = template.Must(template.New("account").Parse(pages.AccountPage))
+	applicationsTemplate = template.Must(template.New("applications").Parse(pages.ApplicationsPage))
+)
+
+type contextKey int
+
+const (
+	userContextKey contextKey = iota
+	sessionContextKey
+)
+
+// UserFromContext returns the user loaded by RequireSession
+func UserFromContext(ctx context.Context) (*models.User, bool) {
+	user, ok := ctx.Value(userContextKey).(*models.User)
+	return user, ok
+}
+
+// SessionFromContext returns the session loaded by RequireSession
+func SessionFromContext(ctx context.Context) (*models.Session, bool) {
+	session, ok := ctx.Value(sessionContextKey).(*models.Session)
+	return session, ok
+}
+
+// SessionHandler serves the pages of logged-in users
+type SessionHandler struct {
+	sessionService *services.SessionService
+	accountService *services.AccountService
+	registry       *services.Registry
+	// consentService is nil unless we are an OpenID provider
+	consentService *services.ConsentService
+}
+
+func NewSessionHandler(sessionService *services.SessionService, accountService *services.AccountService, registry *services.Registry, consentService *services.ConsentService) *SessionHandler {
+	return &SessionHandler{
+		sessionService: sessionService,
+		accountService: accountService,
+		registry:       registry,
+		consentService: consentService,
+	}
+}
+
+// RegisterRoutes mounts /account, its unlink action and /logout, and the
+// applications pages when there is a consent service
+func (h *SessionHandler) RegisterRoutes(mux *http.ServeMux) {
+	mux.Handle("GET /account", h.RequireSession(http.HandlerFunc(h.Account)))
+	mux.Handle("POST /account/identities/{id}/unlink", h.RequireSession(http.HandlerFunc(h.UnlinkIdentity)))
+	mux.HandleFunc("POST /logout", h.Logout)
+
+	if h.consentService != nil {
+		mux.Handle("GET /account/applications", h.RequireSession(http.HandlerFunc(h.Applications)))
+		mux.Handle("POST /account/applications/{id}/revoke", h.RequireSession(http.HandlerFunc(h.RevokeApplication)))
+	}
+}
+
+// RequireSession only lets requests with a valid session through, with the
+// session and its user in the request context. Browsers without one are
+// sent to the login page and brought back after logging in.
+func (h *SessionHandler) RequireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session, user, err := h.sessionService.Current(w, r)
+		if err != nil {
+			if !errors.Is(err, services.ErrNoSession) && !errors.Is(err, services.ErrSessionExpired) {
+				logger.Log.Error("Failed to load session: " + err.Error())
+				renderError(w, http.StatusInternalServerError, "Something went wrong", "Could not load your session. Please try again.")
+				return
+			}
+
+			if r.Method != http.MethodGet {
+				renderError(w, http.StatusUnauthorized, "Not signed in", "Please sign in to continue.")
+				return
+			}
+			http.Redirect(w, r, "/?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), userContextKey, user)
+		ctx = context.WithValue(ctx, sessionContextKey, session)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Account shows who is logged in and the logins they can sign in with
+func (h *SessionHandler) Account(w http.ResponseWriter, r *http.Request) {
+	user, _ := UserFromContext(r.Context())
+	session, _ := SessionFromContext(r.Context())
+
+	identities, err := h.accountService.Identities(user.ID)
+	if err != nil {
+		logger.Log.Error("Failed to list identities: " + err.Error())
+		renderError(w, http.StatusInternalServerError, "Something went wrong", "Could not load your account. Please try again.")
+		return
+	}
+
+	// Show provider display names, and offer the providers not connected yet
+	linked := make(map[string]bool)
+	methods := make([]loginMethod, 0, len(identities))
+	for _, identity := range identities {
+		linked[identity.Provider] = true
+		method := loginMethod{Identity: identity, DisplayName: identity.Provider}
+		if provider, ok := h.registry.Get(identity.Provider); ok {
+			method.DisplayName = provider.DisplayName()
+		}
+		methods = append(methods, method)
+	}
+	var connect []services.Provider
+	for _, provider := range h.registry.Providers() {
+		if !linked[provider.Name()] {
+			connect = append(connect, provider)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+
+	data := struct {
+		User         *models.User
+		Session      *models.Session
+		Identities   []loginMethod
+		Connect      []services.Provider
+		Applications bool
+	}{user, session, methods, connect, h.consentService != nil}
+
+	if err := accountTemplate.Execute(w, data); err != nil {
+		logger.Log.Error("Failed to render account page: " + err.Error())
+	}
+}
+
+// loginMethod is an identity as AccountPage shows it
+type loginMethod struct {
+	models.Identity
+	DisplayName string
+}
+
+// UnlinkIdentity removes one of the user's logins, unless it is the last one
+func (h *SessionHandler) UnlinkIdentity(w http.ResponseWriter, r *http.Request) {
+	user, _ := UserFromContext(r.Context())
+	session, _ := SessionFromContext(r.Context())
+	if !validCSRFToken(r, session) {
+		renderError(w, http.StatusForbidden, "Request not allowed", "This form has expired. Please go back and try again.")
+		return
+	}
+
+	err := h.accountService.Disconnect(user.ID, r.PathValue("id"))
+	if errors.Is(err, services.ErrLastLoginMethod) {
+		renderError(w, http.StatusConflict, "Cannot remove this login", "This is the only way you can sign in. Connect another account before removing it.")
+		return
+	}
+	if err != nil && !errors.Is(err, services.ErrIdentityNotFound) {
+		logger.Log.Error("Failed to unlink identity: " + err.Error())
+		renderError(w, http.StatusInternalServerError, "Something went wrong", "Could not remove the login. Please try again.")
+		return
+	}
+
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
+
+// Applications lists the clients the user granted access to
+func (h *SessionHandler) Applications(w http.ResponseWriter, r *http.Request) {
+	user, _ := UserFromContext(r.Context())
+	session, _ := SessionFromContext(r.Context())
+
+	applications, err := h.consentService.ListApplications(user.ID)
+	if err != nil {
+		logger.Log.Error("Failed to list applications: " + err.Error())
+		renderError(w, http.StatusInternalServerError, "Something went wrong", "Could not load your applications. Please try again.")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+
+	data := struct {
+		Applications []services.Application
+		CSRFToken    string
+	}{applications, session.CSRFToken}
+
+	if err := applicationsTemplate.Execute(w, data); err != nil {
+		logger.Log.Error("Failed to render applications page: " + err.Error())
+	}
+}
+
+// RevokeApplication removes a client's access to the user's account
+func (h *SessionHandler) RevokeApplication(w http.ResponseWriter, r *http.Request) {
+	user, _ := UserFromContext(r.Context())
+	session, _ := SessionFromContext(r.Context())
+	if !validCSRFToken(r, session) {
+		renderError(w, http.StatusForbidden, "Request not allowed", "This form has expired. Please go back and try again.")
+		return
+	}
+
+	err := h.consentService.Revoke(user.ID, r.PathValue("id"))
+	if err != nil && !errors.Is(err, services.ErrConsentNotFound) {
+		logger.Log.Error("Failed to revoke application: " + err.Error())
+		renderError(w, http.StatusInternalServerError, "Something went wrong", "Could not remove the application's access. Please try again.")
+		return
+	}
+
+	http.Redirect(w, r, "/account/applications", http.StatusSeeOther)
+}
+
+// Logout destroys the session. The form must carry the session's CSRF token
+// so that other sites cannot log users out.
+func (h *SessionHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	session, _, err := h.sessionService.Current(w, r)
+	if err == nil && !validCSRFToken(r, session) {
+		renderError(w, http.StatusForbidden, "Request not allowed", "This form has expired. Please go back and try again.")
+		return
+	}
+
+	if err := h.sessionService.End(w, r); err != nil {
+		logger.Log.Error("Failed to end session: " + err.Error())
+	}
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// validCSRFToken checks the csrf_token form field against the session
+func validCSRFToken(r *http.Request, session *models.Session) bool {
+	token := r.PostFormValue("csrf_token")
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(session.CSRFToken)) == 1
+}
+
+// localPath returns next if it is a path on this site, so that the return
+// address of a login cannot send users elsewhere
+func localPath(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.ContainsAny(next, "\\\r\n") {
+		return ""
+	}
+	return next
+}
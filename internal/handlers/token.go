@@ -0,0 +1,340 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"login-with-oauth/internal/logger"
+	"login-with-oauth/internal/models"
+	"login-with-oauth/internal/services"
+	"net/http"
+	"net/url"
+)
+
+// TokenHandler serves the token endpoint for our own clients
+type TokenHandler struct {
+	tokenService        *services.TokenService
+	authorizationServer *services.AuthorizationServer
+	deviceService       *services.DeviceService
+}
+
+func NewTokenHandler(tokenService *services.TokenService, authorizationServer *services.AuthorizationServer, deviceService *services.DeviceService) *TokenHandler {
+	return &TokenHandler{
+		tokenService:        tokenService,
+		authorizationServer: authorizationServer,
+		deviceService:       deviceService,
+	}
+}
+
+// RegisterRoutes mounts /token, /introspect and /revoke, and /device/code
+// when there is a device service
+func (h *TokenHandler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("POST /token", h.Token)
+	mux.HandleFunc("POST /introspect", h.Introspect)
+	mux.HandleFunc("POST /revoke", h.Revoke)
+
+	if h.deviceService != nil {
+		mux.HandleFunc("POST /device/code", h.DeviceAuthorization)
+	}
+}
+
+// Token handles authorization_code grants (RFC 6749 section 4.1.3),
+// refresh_token grants (RFC 6749 section 6), client_credentials grants
+// (RFC 6749 section 4.4) and device_code grants (RFC 8628 section 3.4)
+func (h *TokenHandler) Token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "The request body could not be parsed.")
+		return
+	}
+
+	client, ok := h.authenticateClient(w, r)
+	if !ok {
+		return
+	}
+
+	grantType := r.PostForm.Get("grant_type")
+	if client != nil && grantType != "" && !services.AllowsGrantType(client, grantType) {
+		writeOAuthError(w, http.StatusBadRequest, "unauthorized_client", "The client may not use this grant type.")
+		return
+	}
+
+	switch grantType {
+	case "authorization_code":
+		if client == nil {
+			writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "client_id is required.")
+			return
+		}
+		h.authorizationCode(w, r, client)
+	case "refresh_token":
+		clientID := ""
+		if client != nil {
+			clientID = client.ID
+		}
+		h.refreshToken(w, r, clientID)
+	case "client_credentials":
+		if client == nil || client.Public {
+			writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "The client_credentials grant requires client credentials.")
+			return
+		}
+		h.clientCredentials(w, r, client)
+	case services.DeviceCodeGrantType:
+		if client == nil || h.deviceService == nil {
+			writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "client_id is required.")
+			return
+		}
+		h.deviceCode(w, r, client)
+	case "":
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "grant_type is required.")
+	default:
+		writeOAuthError(w, http.StatusBadRequest, "unsupported_grant_type", "This grant type is not supported.")
+	}
+}
+
+// DeviceAuthorization starts the device flow of a client (RFC 8628 section
+// 3.1). The device then shows the user code and polls /token.
+func (h *TokenHandler) DeviceAuthorization(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "The request body could not be parsed.")
+		return
+	}
+
+	client, ok := h.authenticateClient(w, r)
+	if !ok {
+		return
+	}
+	if client == nil {
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "client_id is required.")
+		return
+	}
+	if !services.AllowsGrantType(client, services.DeviceCodeGrantType) {
+		writeOAuthError(w, http.StatusBadRequest, "unauthorized_client", "The client may not use the device flow.")
+		return
+	}
+
+	response, err := h.deviceService.Start(client, r.PostForm.Get("scope"))
+	if errors.Is(err, services.ErrInvalidScope) {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_scope", "The client may not request this scope.")
+		return
+	}
+	if err != nil {
+		logger.Log.Error("Failed to start device authorization: " + err.Error())
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "The device authorization could not be started.")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// Introspect describes a token to a confidential client, such as an API
+// gateway (RFC 7662)
+func (h *TokenHandler) Introspect(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "The request body could not be parsed.")
+		return
+	}
+
+	client, ok := h.authenticateClient(w, r)
+	if !ok {
+		return
+	}
+	if client == nil || client.Public {
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "Introspection requires client credentials.")
+		return
+	}
+
+	token := r.PostForm.Get("token")
+	if token == "" {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "token is required.")
+		return
+	}
+
+	response, err := h.tokenService.Introspect(token, r.PostForm.Get("token_type_hint"))
+	if err != nil {
+		logger.Log.Error("Failed to introspect token: " + err.Error())
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "The token could not be checked.")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// Revoke revokes a token the client holds, for example when its user logs
+// out (RFC 7009). Unknown tokens are not an error.
+func (h *TokenHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "The request body could not be parsed.")
+		return
+	}
+
+	client, ok := h.authenticateClient(w, r)
+	if !ok {
+		return
+	}
+	if client == nil {
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "client_id is required.")
+		return
+	}
+
+	token := r.PostForm.Get("token")
+	if token == "" {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "token is required.")
+		return
+	}
+
+	err := h.tokenService.Revoke(token, client.ID)
+	if errors.Is(err, services.ErrTokenNotOwned) {
+		writeOAuthError(w, http.StatusBadRequest, "unauthorized_client", "The token was issued to another client.")
+		return
+	}
+	if err != nil {
+		logger.Log.Error("Failed to revoke token: " + err.Error())
+		writeOAuthError(w, http.StatusServiceUnavailable, "server_error", "The token could not be revoked.")
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+// authenticateClient checks client_secret_basic or client_secret_post
+// credentials, or the bare client_id of a public client. Requests without
+// a client_id come from our own login and yield a nil client.
+func (h *TokenHandler) authenticateClient(w http.ResponseWriter, r *http.Request) (*models.Client, bool) {
+	id, secret, basic := r.BasicAuth()
+	if basic {
+		// client_secret_basic form-encodes the credentials (RFC 6749 section 2.3.1)
+		var errID, errSecret error
+		id, errID = url.QueryUnescape(id)
+		secret, errSecret = url.QueryUnescape(secret)
+		if errID != nil || errSecret != nil {
+			writeOAuthError(w, http.StatusBadRequest, "invalid_request", "The Authorization header is malformed.")
+			return nil, false
+		}
+	} else {
+		id = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+	}
+
+	if id == "" || h.authorizationServer == nil {
+		return nil, true
+	}
+
+	client, err := services.AuthenticateClient(h.authorizationServer.Clients(), id, secret)
+	if errors.Is(err, services.ErrInvalidClient) {
+		if basic {
+			w.Header().Set("WWW-Authenticate", `Basic realm="token"`)
+		}
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "Client authentication failed.")
+		return nil, false
+	}
+	if err != nil {
+		logger.Log.Error("Failed to authenticate client: " + err.Error())
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "The client could not be authenticated.")
+		return nil, false
+	}
+
+	return client, true
+}
+
+func (h *TokenHandler) authorizationCode(w http.ResponseWriter, r *http.Request, client *models.Client) {
+	code := r.PostForm.Get("code")
+	if code == "" {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "code is required.")
+		return
+	}
+
+	response, err := h.authorizationServer.ExchangeCode(client, code, r.PostForm.Get("redirect_uri"), r.PostForm.Get("code_verifier"))
+	if errors.Is(err, services.ErrInvalidGrant) {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "The authorization code is invalid, expired or was already used.")
+		return
+	}
+	if err != nil {
+		logger.Log.Error("Failed to exchange authorization code: " + err.Error())
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "The token could not be issued.")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (h *TokenHandler) refreshToken(w http.ResponseWriter, r *http.Request, clientID string) {
+	refreshToken := r.PostForm.Get("refresh_token")
+	if refreshToken == "" {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "refresh_token is required.")
+		return
+	}
+
+	response, err := h.tokenService.Refresh(refreshToken, clientID)
+	if errors.Is(err, services.ErrInvalidGrant) || errors.Is(err, services.ErrRefreshTokenReused) {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "The refresh token is invalid, expired or revoked.")
+		return
+	}
+	if err != nil {
+		logger.Log.Error("Failed to refresh token: " + err.Error())
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "The token could not be issued.")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (h *TokenHandler) clientCredentials(w http.ResponseWriter, r *http.Request, client *models.Client) {
+	response, err := h.tokenService.IssueClientCredentials(client, r.PostForm.Get("scope"))
+	if errors.Is(err, services.ErrInvalidScope) {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_scope", "The client may not request this scope.")
+		return
+	}
+	if err != nil {
+		logger.Log.Error("Failed to issue client credentials token: " + err.Error())
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "The token could not be issued.")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (h *TokenHandler) deviceCode(w http.ResponseWriter, r *http.Request, client *models.Client) {
+	deviceCode := r.PostForm.Get("device_code")
+	if deviceCode == "" {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "device_code is required.")
+		return
+	}
+
+	response, err := h.deviceService.Poll(client, deviceCode)
+	switch {
+	case errors.Is(err, services.ErrAuthorizationPending):
+		writeOAuthError(w, http.StatusBadRequest, "authorization_pending", "The user has not answered yet.")
+	case errors.Is(err, services.ErrSlowDown):
+		writeOAuthError(w, http.StatusBadRequest, "slow_down", "Polling too often, wait 5 more seconds between requests.")
+	case errors.Is(err, services.ErrExpiredToken):
+		writeOAuthError(w, http.StatusBadRequest, "expired_token", "The device code has expired.")
+	case errors.Is(err, services.ErrAccessDenied):
+		writeOAuthError(w, http.StatusBadRequest, "access_denied", "The user denied the request.")
+	case errors.Is(err, services.ErrInvalidGrant):
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "The device code is invalid or was already used.")
+	case err != nil:
+		logger.Log.Error("Failed to poll device code: " + err.Error())
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "The token could not be issued.")
+	default:
+		writeJSON(w, http.StatusOK, response)
+	}
+}
+
+// writeJSON writes v as an uncacheable JSON response
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Log.Error("Failed to write JSON response: " + err.Error())
+	}
+}
+
+// writeOAuthError writes an RFC 6749 section 5.2 error response
+func writeOAuthError(w http.ResponseWriter, status int, code, description string) {
+	writeJSON(w, status, struct {
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description,omitempty"`
+	}{code, description})
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"login-with-oauth/internal/logger"
+	"login-with-oauth/internal/services"
+	"net/http"
+	"strconv"
+)
+
+// UserHandler serves the admin API for users
+type UserHandler struct {
+	sessionService *services.SessionService
+	tokenService   *services.TokenService
+}
+
+func NewUserHandler(sessionService *services.SessionService, tokenService *services.TokenService) *UserHandler {
+	return &UserHandler{
+		sessionService: sessionService,
+		tokenService:   tokenService,
+	}
+}
+
+// RegisterRoutes mounts /admin/users
+func (h *UserHandler) RegisterRoutes(mux *http.ServeMux) {
+	mux.Handle("DELETE /admin/users/{id}/sessions", requireAdmin(h.tokenService, http.HandlerFunc(h.RevokeSessions)))
+}
+
+// RevokeSessions logs a user out everywhere. With the cookie session store
+// only this instance learns about it.
+func (h *UserHandler) RevokeSessions(w http.ResponseWriter, r *http.Request) {
+	userID := r.PathValue("id")
+
+	revoked, err := h.sessionService.RevokeUser(userID)
+	if err != nil {
+		logger.Log.Error("Failed to revoke sessions: " + err.Error())
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "The sessions could not be revoked.")
+		return
+	}
+
+	logger.Log.Info("Revoked " + strconv.FormatInt(revoked, 10) + " sessions of user " + userID)
+	writeJSON(w, http.StatusOK, map[string]int64{"revoked": revoked})
+}
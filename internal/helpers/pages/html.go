@@ -1,7 +1,8 @@
 package pages
 
 /*
-IndexPage renders the html content for the index page.
+IndexPage is the html/template for the index page. It expects Providers,
+each with a Name and a DisplayName, and the Next path to return to.
 */
 const IndexPage = `
 <!DOCTYPE html>
@@ -15,11 +16,249 @@ const IndexPage = `
     <h1>Welcome</h1>
     <p>Please sign in to continue</p>
     
+    {{range .Providers}}
     <div>
-        <a href="/login-gl">Login with Google</a>
+        <a href="/login/{{.Name}}{{with $.Next}}?next={{.}}{{end}}">Login with {{.DisplayName}}</a>
     </div>
+    {{end}}
+</body>
+</html>`
+
+/*
+ErrorPage is the html/template shown when a request cannot be completed.
+It expects a Title and a Message.
+*/
+const ErrorPage = `
+<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>{{.Title}}</title>
+</head>
+<body>
+    <h1>{{.Title}}</h1>
+    <p>{{.Message}}</p>
+
+    <div>
+        <a href="/">Back to sign in</a>
+    </div>
+</body>
+</html>`
+
+/*
+AccountPage is the html/template shown to logged-in users. It expects the
+User and their Session, the Identities they can sign in with (each with an
+ID, Provider, DisplayName, Email, LinkedAt and ReconsentRequired), the
+providers they can Connect (each with a Name and DisplayName), and
+Applications when they can review the applications they granted access to.
+*/
+const AccountPage = `
+<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Your account</title>
+</head>
+<body>
+    <h1>Welcome, {{.User.Username}}</h1>
+    <p>Logged in as {{.User.Email}} with {{.Session.Provider}}</p>
+
+    <h2>Ways to sign in</h2>
+    {{range .Identities}}
+    <div>
+        <p>{{.DisplayName}}{{if .Email}} ({{.Email}}){{end}}, connected on {{.LinkedAt.Format "2 January 2006"}}</p>
+        {{if .ReconsentRequired}}
+        <form method="POST" action="/connect/{{.Provider}}">
+            <input type="hidden" name="csrf_token" value="{{$.Session.CSRFToken}}">
+            <input type="hidden" name="login_hint" value="{{.Email}}">
+            <input type="hidden" name="reconsent" value="1">
+            <p>{{.DisplayName}} no longer lets us act for you.</p>
+            <button type="submit">Reconnect {{.DisplayName}}</button>
+        </form>
+        {{end}}
+        {{if gt (len $.Identities) 1}}
+        <form method="POST" action="/account/identities/{{.ID}}/unlink">
+            <input type="hidden" name="csrf_token" value="{{$.Session.CSRFToken}}">
+            <button type="submit">Remove</button>
+        </form>
+        {{end}}
+    </div>
+    {{end}}
+    {{range .Connect}}
+    <form method="POST" action="/connect/{{.Name}}">
+        <input type="hidden" name="csrf_token" value="{{$.Session.CSRFToken}}">
+        <button type="submit">Connect {{.DisplayName}}</button>
+    </form>
+    {{end}}
+
+    {{if .Applications}}
+    <div>
+        <a href="/account/applications">Applications with access to your account</a>
+    </div>
+    {{end}}
+
+    <form method="POST" action="/logout">
+        <input type="hidden" name="csrf_token" value="{{.Session.CSRFToken}}">
+        <button type="submit">Logout</button>
+    </form>
+</body>
+</html>`
+
+/*
+ConflictPage is the html/template shown when a login belongs to another
+account. It expects the Provider's display name and the login's Email, and
+Connecting when a logged-in user tried to connect it.
+*/
+const ConflictPage = `
+<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Account already exists</title>
+</head>
+<body>
+    {{if .Connecting}}
+    <h1>This {{.Provider}} account is already in use</h1>
+    <p>The {{.Provider}} account{{if .Email}} for {{.Email}}{{end}} is connected to another account here. To connect it to this account, sign in with it, remove it from that account and try again.</p>
+
+    <div>
+        <a href="/account">Back to your account</a>
+    </div>
+    {{else}}
+    <h1>An account with this email already exists</h1>
+    <p>{{.Email}} belongs to an account that signs in another way. Sign in to that account the way you usually do, then connect {{.Provider}} from your account page.</p>
+
+    <div>
+        <a href="/">Back to sign in</a>
+    </div>
+    {{end}}
+</body>
+</html>`
+
+/*
+ConsentPage is the html/template asking a user to approve a client. It
+expects the User, the Client, the Scopes it asks for (each with a Description), the
+authorization request Params to post back and the session's CSRFToken.
+*/
+const ConsentPage = `
+<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Allow {{.Client.Name}}?</title>
+</head>
+<body>
+    <h1>{{.Client.Name}} wants to access your account</h1>
+    <p>Signed in as {{.User.Email}}. If you allow it, {{.Client.Name}} will be able to:</p>
+
+    <ul>
+    {{range .Scopes}}
+        <li>{{.Description}}</li>
+    {{end}}
+    </ul>
+
+    <form method="POST" action="/authorize">
+        {{range $name, $values := .Params}}{{range $values}}
+        <input type="hidden" name="{{$name}}" value="{{.}}">
+        {{end}}{{end}}
+        <input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+        <button type="submit" name="decision" value="allow">Allow</button>
+        <button type="submit" name="decision" value="deny">Deny</button>
+    </form>
+</body>
+</html>`
+
+/*
+ApplicationsPage is the html/template listing the applications a user
+granted access to. It expects Applications, each with a Client, Scopes and
+GrantedAt, and the session's CSRFToken.
+*/
+const ApplicationsPage = `
+<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Applications</title>
+</head>
+<body>
+    <h1>Applications with access to your account</h1>
+
+    {{range .Applications}}
+    <div>
+        <h2>{{.Client.Name}}</h2>
+        <p>Allowed on {{.GrantedAt.Format "2 January 2006"}} to:</p>
+        <ul>
+        {{range .Scopes}}
+            <li>{{.Description}}</li>
+        {{end}}
+        </ul>
+        <form method="POST" action="/account/applications/{{.Client.ID}}/revoke">
+            <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+            <button type="submit">Remove access</button>
+        </form>
+    </div>
+    {{else}}
+    <p>No applications have access to your account.</p>
+    {{end}}
+
+    <div>
+        <a href="/account">Back to your account</a>
+    </div>
+</body>
+</html>`
+
+/*
+DevicePage is the html/template where users connect a device. Without a
+Client it asks for the UserCode shown on the device, explaining any Error;
+with one it asks the User to approve the Client's Scopes and posts the
+decision with the session's CSRFToken. A Message ends the flow.
+*/
+const DevicePage = `
+<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Connect a device</title>
+</head>
+<body>
+    {{if .Message}}
+    <h1>Connect a device</h1>
+    <p>{{.Message}}</p>
+    {{else if .Client}}
+    <h1>{{.Client.Name}} wants to access your account</h1>
+    <p>Signed in as {{.User.Email}}. Only continue if the device shows the code <strong>{{.UserCode}}</strong>. If you allow it, {{.Client.Name}} will be able to:</p>
+
+    <ul>
+    {{range .Scopes}}
+        <li>{{.Description}}</li>
+    {{end}}
+    </ul>
+
+    <form method="POST" action="/device">
+        <input type="hidden" name="user_code" value="{{.UserCode}}">
+        <input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+        <button type="submit" name="decision" value="allow">Allow</button>
+        <button type="submit" name="decision" value="deny">Deny</button>
+    </form>
+    {{else}}
+    <h1>Connect a device</h1>
+    <p>Enter the code shown on your device.</p>
+    {{if .Error}}<p>{{.Error}}</p>{{end}}
+
+    <form method="GET" action="/device">
+        <input type="text" name="user_code" value="{{.UserCode}}" autocomplete="off" autocapitalize="characters" required>
+        <button type="submit">Continue</button>
+    </form>
+    {{end}}
+
     <div>
-        <a href="/login-gh">Login with GitHub</a>
+        <a href="/account">Back to your account</a>
     </div>
 </body>
 </html>`
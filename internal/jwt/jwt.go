@@ -0,0 +1,236 @@
+// Package jwt signs and verifies compact JSON Web Tokens using only the
+// standard library. It supports the asymmetric JWS algorithms RS*, PS*, ES*
+// and EdDSA; "none" and HMAC algorithms are deliberately rejected.
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+var (
+	ErrMalformed            = errors.New("jwt: malformed token")
+	ErrUnsupportedAlgorithm = errors.New("jwt: unsupported algorithm")
+	ErrInvalidSignature     = errors.New("jwt: invalid signature")
+	ErrKeyMismatch          = errors.New("jwt: key does not match algorithm")
+	ErrKeyNotFound          = errors.New("jwt: no matching key")
+)
+
+// Header is the JOSE header of a compact JWS
+type Header struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ,omitempty"`
+	KeyID     string `json:"kid,omitempty"`
+}
+
+// Token is a parsed JWT whose signature has not been checked yet
+type Token struct {
+	Header       Header
+	payload      []byte
+	signingInput string
+	signature    []byte
+}
+
+// Parse splits and decodes a compact JWS without verifying it
+func Parse(raw string) (*Token, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformed
+	}
+
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	var header Header
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, ErrMalformed
+	}
+
+	return &Token{
+		Header:       header,
+		payload:      payload,
+		signingInput: parts[0] + "." + parts[1],
+		signature:    signature,
+	}, nil
+}
+
+// Claims decodes the token payload into v
+func (t *Token) Claims(v any) error {
+	if err := json.Unmarshal(t.payload, v); err != nil {
+		return fmt.Errorf("jwt: failed to decode claims: %v", err)
+	}
+	return nil
+}
+
+// Verify checks the token signature against key using the header algorithm
+func (t *Token) Verify(key crypto.PublicKey) error {
+	alg, ok := algorithms[t.Header.Algorithm]
+	if !ok {
+		return ErrUnsupportedAlgorithm
+	}
+
+	h := alg.hash.New()
+	h.Write([]byte(t.signingInput))
+	digest := h.Sum(nil)
+
+	switch alg.family {
+	case familyRSA, familyRSAPSS:
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return ErrKeyMismatch
+		}
+		var err error
+		if alg.family == familyRSA {
+			err = rsa.VerifyPKCS1v15(pub, alg.hash, digest, t.signature)
+		} else {
+			err = rsa.VerifyPSS(pub, alg.hash, digest, t.signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		}
+		if err != nil {
+			return ErrInvalidSignature
+		}
+	case familyECDSA:
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || pub.Curve.Params().Name != alg.curve {
+			return ErrKeyMismatch
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(t.signature) != 2*size {
+			return ErrInvalidSignature
+		}
+		r := new(big.Int).SetBytes(t.signature[:size])
+		s := new(big.Int).SetBytes(t.signature[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return ErrInvalidSignature
+		}
+	case familyEdDSA:
+		pub, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return ErrKeyMismatch
+		}
+		if !ed25519.Verify(pub, []byte(t.signingInput), t.signature) {
+			return ErrInvalidSignature
+		}
+	}
+
+	return nil
+}
+
+// Sign serializes claims and signs them with key, which must match algorithm
+func Sign(algorithm, keyID string, key crypto.Signer, claims any) (string, error) {
+	alg, ok := algorithms[algorithm]
+	if !ok {
+		return "", ErrUnsupportedAlgorithm
+	}
+
+	headerJSON, err := json.Marshal(Header{Algorithm: algorithm, Type: "JWT", KeyID: keyID})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("jwt: failed to encode claims: %v", err)
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(headerJSON) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	var signature []byte
+	switch alg.family {
+	case familyEdDSA:
+		if _, ok := key.(ed25519.PrivateKey); !ok {
+			return "", ErrKeyMismatch
+		}
+		signature, err = key.Sign(rand.Reader, []byte(signingInput), crypto.Hash(0))
+	default:
+		h := alg.hash.New()
+		h.Write([]byte(signingInput))
+		digest := h.Sum(nil)
+
+		switch alg.family {
+		case familyRSA:
+			priv, ok := key.(*rsa.PrivateKey)
+			if !ok {
+				return "", ErrKeyMismatch
+			}
+			signature, err = rsa.SignPKCS1v15(rand.Reader, priv, alg.hash, digest)
+		case familyRSAPSS:
+			priv, ok := key.(*rsa.PrivateKey)
+			if !ok {
+				return "", ErrKeyMismatch
+			}
+			signature, err = rsa.SignPSS(rand.Reader, priv, alg.hash, digest, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		case familyECDSA:
+			priv, ok := key.(*ecdsa.PrivateKey)
+			if !ok || priv.Curve.Params().Name != alg.curve {
+				return "", ErrKeyMismatch
+			}
+			var r, s *big.Int
+			r, s, err = ecdsa.Sign(rand.Reader, priv, digest)
+			if err == nil {
+				size := (priv.Curve.Params().BitSize + 7) / 8
+				signature = make([]byte, 2*size)
+				r.FillBytes(signature[:size])
+				s.FillBytes(signature[size:])
+			}
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("jwt: failed to sign: %v", err)
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+type family int
+
+const (
+	familyRSA family = iota
+	familyRSAPSS
+	familyECDSA
+	familyEdDSA
+)
+
+type algorithm struct {
+	family family
+	hash   crypto.Hash
+	curve  string
+}
+
+var algorithms = map[string]algorithm{
+	"RS256": {family: familyRSA, hash: crypto.SHA256},
+	"RS384": {family: familyRSA, hash: crypto.SHA384},
+	"RS512": {family: familyRSA, hash: crypto.SHA512},
+	"PS256": {family: familyRSAPSS, hash: crypto.SHA256},
+	"PS384": {family: familyRSAPSS, hash: crypto.SHA384},
+	"PS512": {family: familyRSAPSS, hash: crypto.SHA512},
+	"ES256": {family: familyECDSA, hash: crypto.SHA256, curve: "P-256"},
+	"ES384": {family: familyECDSA, hash: crypto.SHA384, curve: "P-384"},
+	"ES512": {family: familyECDSA, hash: crypto.SHA512, curve: "P-521"},
+	"EdDSA": {family: familyEdDSA, hash: crypto.SHA512},
+}
+
+// Supported reports whether algorithm can be used with Sign and Verify
+func Supported(algorithm string) bool {
+	_, ok := algorithms[algorithm]
+	return ok
+}
@@ -0,0 +1,174 @@
+package jwt
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func generateKeys(t *testing.T) map[string]crypto.Signer {
+	t.Helper()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	return map[string]crypto.Signer{
+		"RS256": rsaKey,
+		"PS256": rsaKey,
+		"ES256": p256,
+		"ES384": p384,
+		"EdDSA": edKey,
+	}
+}
+
+func TestSignAndVerify(t *testing.T) {
+	for alg, key := range generateKeys(t) {
+		t.Run(alg, func(t *testing.T) {
+			raw, err := Sign(alg, "kid-1", key, Claims{Subject: "user-1", Audience: Audience{"client"}})
+			require.NoError(t, err)
+
+			token, err := Parse(raw)
+			require.NoError(t, err)
+			assert.Equal(t, alg, token.Header.Algorithm)
+			assert.Equal(t, "kid-1", token.Header.KeyID)
+
+			// The public key must survive a round trip through a JWK
+			jwk, err := NewJWK(key.Public(), "kid-1", alg)
+			require.NoError(t, err)
+			pub, err := jwk.PublicKey()
+			require.NoError(t, err)
+			assert.NoError(t, token.Verify(pub))
+
+			var claims Claims
+			require.NoError(t, token.Claims(&claims))
+			assert.Equal(t, "user-1", claims.Subject)
+			assert.True(t, claims.Audience.Contains("client"))
+
+			// Flipping the payload must break the signature
+			parts := strings.Split(raw, ".")
+			forged, _ := Sign(alg, "kid-1", key, Claims{Subject: "user-2"})
+			tampered, err := Parse(parts[0] + "." + strings.Split(forged, ".")[1] + "." + parts[2])
+			require.NoError(t, err)
+			assert.ErrorIs(t, tampered.Verify(pub), ErrInvalidSignature)
+		})
+	}
+}
+
+func TestVerifyRejectsWrongKeyType(t *testing.T) {
+	keys := generateKeys(t)
+
+	raw, err := Sign("RS256", "", keys["RS256"], Claims{})
+	require.NoError(t, err)
+	token, err := Parse(raw)
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, token.Verify(keys["ES256"].Public()), ErrKeyMismatch)
+}
+
+func TestParseRejectsNone(t *testing.T) {
+	token, err := Parse("eyJhbGciOiJub25lIn0.eyJzdWIiOiJ4In0.")
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, token.Verify(nil), ErrUnsupportedAlgorithm)
+}
+
+func TestAudience(t *testing.T) {
+	var single, many Audience
+	require.NoError(t, json.Unmarshal([]byte(`"a"`), &single))
+	require.NoError(t, json.Unmarshal([]byte(`["a","b"]`), &many))
+
+	assert.Equal(t, Audience{"a"}, single)
+	assert.Equal(t, Audience{"a", "b"}, many)
+
+	encoded, _ := json.Marshal(single)
+	assert.Equal(t, `"a"`, string(encoded))
+}
+
+func TestRemoteKeySet(t *testing.T) {
+	keys := generateKeys(t)
+	current := keys["RS256"]
+	fetches := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		jwk, _ := NewJWK(current.Public(), "kid-"+string(rune('0'+fetches)), "")
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		json.NewEncoder(w).Encode(KeySet{Keys: []JWK{jwk}})
+	}))
+	defer server.Close()
+
+	keySet := NewRemoteKeySet(server.URL, server.Client())
+
+	t.Run("CachesKeys", func(t *testing.T) {
+		raw, _ := Sign("RS256", "kid-1", current, Claims{})
+		token, _ := Parse(raw)
+
+		assert.NoError(t, keySet.Verify(context.Background(), token))
+		assert.NoError(t, keySet.Verify(context.Background(), token))
+		assert.Equal(t, 1, fetches)
+	})
+
+	t.Run("UnknownKeyIDWithinRefreshInterval", func(t *testing.T) {
+		raw, _ := Sign("RS256", "kid-2", current, Claims{})
+		token, _ := Parse(raw)
+
+		assert.ErrorIs(t, keySet.Verify(context.Background(), token), ErrKeyNotFound)
+		assert.Equal(t, 1, fetches)
+	})
+}
+
+func TestParsePrivateKey(t *testing.T) {
+	for name, key := range generateKeys(t) {
+		t.Run(name, func(t *testing.T) {
+			der, err := x509.MarshalPKCS8PrivateKey(key)
+			require.NoError(t, err)
+
+			parsed, err := ParsePrivateKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+			require.NoError(t, err)
+
+			algorithm, err := DefaultAlgorithm(parsed.Public())
+			assert.NoError(t, err)
+			if name != "PS256" {
+				assert.Equal(t, name, algorithm)
+			}
+		})
+	}
+
+	_, err := ParsePrivateKey([]byte("not a key"))
+	assert.Error(t, err)
+}
+
+func TestThumbprint(t *testing.T) {
+	// Example from RFC 7638 section 3.1
+	jwk := JWK{
+		KeyType: "RSA",
+		N:       "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+		E:       "AQAB",
+		KeyID:   "ignored",
+	}
+
+	thumbprint, err := jwk.Thumbprint()
+
+	assert.NoError(t, err)
+	assert.Equal(t, "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs", thumbprint)
+}
@@ -0,0 +1,98 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+// ParsePrivateKey reads a PEM encoded PKCS #8, PKCS #1 or SEC 1 private key
+func ParsePrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("jwt: no PEM block found")
+	}
+
+	var key any
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("jwt: unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("jwt: failed to parse private key: %v", err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("jwt: unsupported key type %T", key)
+	}
+	if _, err := DefaultAlgorithm(signer.Public()); err != nil {
+		return nil, err
+	}
+
+	return signer, nil
+}
+
+// DefaultAlgorithm picks the algorithm usually paired with a key: RS256 for
+// RSA, ES256/384/512 for the NIST curves and EdDSA for Ed25519
+func DefaultAlgorithm(key crypto.PublicKey) (string, error) {
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		return "RS256", nil
+	case *ecdsa.PublicKey:
+		switch pub.Curve.Params().Name {
+		case "P-256":
+			return "ES256", nil
+		case "P-384":
+			return "ES384", nil
+		case "P-521":
+			return "ES512", nil
+		}
+		return "", fmt.Errorf("jwt: unsupported curve %s", pub.Curve.Params().Name)
+	case ed25519.PublicKey:
+		return "EdDSA", nil
+	default:
+		return "", fmt.Errorf("jwt: unsupported key type %T", key)
+	}
+}
+
+// Thumbprint is the RFC 7638 SHA-256 thumbprint of the key, a stable value
+// to use as its kid
+func (k JWK) Thumbprint() (string, error) {
+	// The members must be in lexicographic order with no whitespace, which
+	// is what encoding/json produces for a map
+	members := map[string]string{"kty": k.KeyType}
+	switch k.KeyType {
+	case "RSA":
+		members["e"], members["n"] = k.E, k.N
+	case "EC":
+		members["crv"], members["x"], members["y"] = k.Curve, k.X, k.Y
+	case "OKP":
+		members["crv"], members["x"] = k.Curve, k.X
+	default:
+		return "", fmt.Errorf("jwt: unsupported key type %q", k.KeyType)
+	}
+
+	canonical, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(canonical)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
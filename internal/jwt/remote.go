@@ -0,0 +1,135 @@
+package jwt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultKeySetTTL applies when the JWKS response has no max-age
+	defaultKeySetTTL = time.Hour
+	// minRefreshInterval limits refetches triggered by unknown key IDs
+	minRefreshInterval = time.Minute
+)
+
+// RemoteKeySet verifies tokens against a JWKS document fetched over HTTP.
+// Keys are cached for the response's max-age and refetched early when a
+// token names a key ID that is not in the cache.
+type RemoteKeySet struct {
+	url    string
+	client *http.Client
+	now    func() time.Time
+
+	mu        sync.Mutex
+	keys      KeySet
+	expiry    time.Time
+	lastFetch time.Time
+}
+
+// NewRemoteKeySet creates a key set for the JWKS at url. A nil client
+// means http.DefaultClient.
+func NewRemoteKeySet(url string, client *http.Client) *RemoteKeySet {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &RemoteKeySet{url: url, client: client, now: time.Now}
+}
+
+// Verify checks the token signature with the cached keys, refreshing them
+// once if no cached key matches
+func (s *RemoteKeySet) Verify(ctx context.Context, token *Token) error {
+	keys, err := s.cachedKeys(ctx, false)
+	if err != nil {
+		return err
+	}
+
+	err = keys.VerifyWith(token)
+	if !errors.Is(err, ErrKeyNotFound) {
+		return err
+	}
+
+	keys, err = s.cachedKeys(ctx, true)
+	if err != nil {
+		return err
+	}
+	return keys.VerifyWith(token)
+}
+
+func (s *RemoteKeySet) cachedKeys(ctx context.Context, refresh bool) (KeySet, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	stale := now.After(s.expiry)
+	if refresh && now.Sub(s.lastFetch) >= minRefreshInterval {
+		stale = true
+	}
+	if !stale {
+		return s.keys, nil
+	}
+
+	keys, ttl, err := s.fetch(ctx)
+	if err != nil {
+		// Keep serving the previous keys if the JWKS endpoint is briefly down
+		if len(s.keys.Keys) > 0 {
+			return s.keys, nil
+		}
+		return KeySet{}, err
+	}
+
+	s.keys = keys
+	s.lastFetch = now
+	s.expiry = now.Add(ttl)
+
+	return keys, nil
+}
+
+func (s *RemoteKeySet) fetch(ctx context.Context) (KeySet, time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", s.url, nil)
+	if err != nil {
+		return KeySet{}, 0, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return KeySet{}, 0, fmt.Errorf("failed to fetch JWKS: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return KeySet{}, 0, fmt.Errorf("failed to read JWKS: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return KeySet{}, 0, fmt.Errorf("JWKS request failed with status: %d", resp.StatusCode)
+	}
+
+	var keys KeySet
+	if err := json.Unmarshal(body, &keys); err != nil {
+		return KeySet{}, 0, fmt.Errorf("failed to decode JWKS: %v", err)
+	}
+
+	return keys, maxAge(resp.Header.Get("Cache-Control")), nil
+}
+
+func maxAge(cacheControl string) time.Duration {
+	for _, directive := range strings.Split(cacheControl, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(directive), "=")
+		if !ok || !strings.EqualFold(name, "max-age") {
+			continue
+		}
+		if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+			return time.Duration(seconds) * time.Second
+		}
+	}
+	return defaultKeySetTTL
+}
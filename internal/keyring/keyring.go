@@ -0,0 +1,136 @@
+// Package keyring encrypts small values with AES-256-GCM under a set of
+// named keys, so that keys can be rotated without losing what was sealed
+// under the previous ones.
+package keyring
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// KeySize is the length of every key in bytes
+const KeySize = 32
+
+var (
+	ErrNoKeys     = errors.New("keyring has no keys")
+	ErrUnknownKey = errors.New("sealed with an unknown key")
+	ErrMalformed  = errors.New("sealed value is malformed")
+	ErrDecrypt    = errors.New("sealed value could not be decrypted")
+)
+
+// Key is a named AES-256 key
+type Key struct {
+	ID     string
+	Secret []byte
+}
+
+// Keyring seals with its primary key and opens with any of its keys
+type Keyring struct {
+	primary string
+	aeads   map[string]cipher.AEAD
+}
+
+// New creates a Keyring. The first key is the primary key; the others are
+// only used to open values sealed before a rotation.
+func New(keys ...Key) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, ErrNoKeys
+	}
+
+	ring := &Keyring{primary: keys[0].ID, aeads: make(map[string]cipher.AEAD)}
+	for _, key := range keys {
+		if key.ID == "" || strings.Contains(key.ID, ".") {
+			return nil, fmt.Errorf("invalid key id %q", key.ID)
+		}
+		if len(key.Secret) != KeySize {
+			return nil, fmt.Errorf("key %s must be %d bytes, got %d", key.ID, KeySize, len(key.Secret))
+		}
+		if _, exists := ring.aeads[key.ID]; exists {
+			return nil, fmt.Errorf("duplicate key id %q", key.ID)
+		}
+
+		block, err := aes.NewCipher(key.Secret)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %v", key.ID, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %v", key.ID, err)
+		}
+		ring.aeads[key.ID] = aead
+	}
+
+	return ring, nil
+}
+
+// ParseKeys reads keys written as "<id>:<base64 secret>", primary first
+func ParseKeys(specs []string) ([]Key, error) {
+	keys := make([]Key, 0, len(specs))
+	for _, spec := range specs {
+		id, encoded, ok := strings.Cut(spec, ":")
+		if !ok {
+			return nil, fmt.Errorf("key %q is not in the form <id>:<base64 secret>", id)
+		}
+
+		secret, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			secret, err = base64.RawURLEncoding.DecodeString(encoded)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("key %s is not valid base64: %v", id, err)
+		}
+
+		keys = append(keys, Key{ID: id, Secret: secret})
+	}
+	return keys, nil
+}
+
+// PrimaryID is the ID of the key new values are sealed with
+func (k *Keyring) PrimaryID() string {
+	return k.primary
+}
+
+// Seal encrypts plaintext with the primary key. additionalData is
+// authenticated but not encrypted; Open must be given the same value.
+func (k *Keyring) Seal(plaintext, additionalData []byte) (string, error) {
+	aead := k.aeads[k.primary]
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %v", err)
+	}
+
+	sealed := aead.Seal(nonce, nonce, plaintext, additionalData)
+	return k.primary + "." + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a value returned by Seal. It also returns the ID of the key
+// that was used, so callers can reseal values not under the primary key.
+func (k *Keyring) Open(value string, additionalData []byte) ([]byte, string, error) {
+	id, encoded, ok := strings.Cut(value, ".")
+	if !ok {
+		return nil, "", ErrMalformed
+	}
+
+	aead, ok := k.aeads[id]
+	if !ok {
+		return nil, id, ErrUnknownKey
+	}
+
+	sealed, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return nil, id, ErrMalformed
+	}
+
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], additionalData)
+	if err != nil {
+		return nil, id, ErrDecrypt
+	}
+
+	return plaintext, id, nil
+}
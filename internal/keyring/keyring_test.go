@@ -0,0 +1,92 @@
+package keyring
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testKey(id string, fill byte) Key {
+	return Key{ID: id, Secret: bytes.Repeat([]byte{fill}, KeySize)}
+}
+
+func TestKeyring(t *testing.T) {
+	ring, err := New(testKey("k1", 1))
+	require.NoError(t, err)
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		sealed, err := ring.Seal([]byte("secret"), []byte("session"))
+		require.NoError(t, err)
+
+		plaintext, id, err := ring.Open(sealed, []byte("session"))
+
+		assert.NoError(t, err)
+		assert.Equal(t, "k1", id)
+		assert.Equal(t, []byte("secret"), plaintext)
+	})
+
+	t.Run("WrongAdditionalData", func(t *testing.T) {
+		sealed, err := ring.Seal([]byte("secret"), []byte("session"))
+		require.NoError(t, err)
+
+		_, _, err = ring.Open(sealed, []byte("other"))
+
+		assert.ErrorIs(t, err, ErrDecrypt)
+	})
+
+	t.Run("Tampered", func(t *testing.T) {
+		sealed, err := ring.Seal([]byte("secret"), nil)
+		require.NoError(t, err)
+		tampered := []byte(sealed)
+		tampered[len(tampered)/2] ^= 1
+
+		_, _, err = ring.Open(string(tampered), nil)
+
+		assert.Error(t, err)
+	})
+
+	t.Run("Rotation", func(t *testing.T) {
+		old, err := ring.Seal([]byte("before rotation"), nil)
+		require.NoError(t, err)
+
+		rotated, err := New(testKey("k2", 2), testKey("k1", 1))
+		require.NoError(t, err)
+
+		plaintext, id, err := rotated.Open(old, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, "k1", id)
+		assert.Equal(t, []byte("before rotation"), plaintext)
+
+		sealed, err := rotated.Seal([]byte("after rotation"), nil)
+		require.NoError(t, err)
+		_, _, err = ring.Open(sealed, nil)
+		assert.ErrorIs(t, err, ErrUnknownKey)
+	})
+}
+
+func TestNew(t *testing.T) {
+	_, err := New()
+	assert.ErrorIs(t, err, ErrNoKeys)
+
+	_, err = New(Key{ID: "short", Secret: []byte("too short")})
+	assert.Error(t, err)
+
+	_, err = New(testKey("k1", 1), testKey("k1", 2))
+	assert.Error(t, err)
+}
+
+func TestParseKeys(t *testing.T) {
+	secret := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, KeySize))
+
+	keys, err := ParseKeys([]string{"2024-06:" + secret})
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	assert.Equal(t, "2024-06", keys[0].ID)
+	assert.Len(t, keys[0].Secret, KeySize)
+
+	_, err = ParseKeys([]string{"no-separator"})
+	assert.Error(t, err)
+}
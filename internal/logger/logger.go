@@ -7,7 +7,8 @@ import (
 )
 
 var (
-	Log *zap.Logger
+	// Log discards everything until InitializeZapCustomLogger is called
+	Log = zap.NewNop()
 )
 
 func InitializeZapCustomLogger() {
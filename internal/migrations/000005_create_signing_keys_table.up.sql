@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS signing_keys (
+    id VARCHAR(255) PRIMARY KEY,
+    algorithm VARCHAR(16) NOT NULL,
+    encrypted_key TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    retired_at TIMESTAMP WITH TIME ZONE
+);
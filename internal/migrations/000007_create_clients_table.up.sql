@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS clients (
+    id VARCHAR(255) PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    secret_hash VARCHAR(64) NOT NULL DEFAULT '',
+    previous_secret_hash VARCHAR(64) NOT NULL DEFAULT '',
+    previous_secret_expires_at TIMESTAMP WITH TIME ZONE,
+    redirect_uris TEXT NOT NULL,
+    scopes TEXT NOT NULL,
+    grant_types TEXT NOT NULL,
+    public BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
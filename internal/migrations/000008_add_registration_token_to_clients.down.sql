@@ -0,0 +1 @@
+ALTER TABLE clients DROP COLUMN IF EXISTS registration_token_hash;
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS registration_token_hash VARCHAR(64) NOT NULL DEFAULT '';
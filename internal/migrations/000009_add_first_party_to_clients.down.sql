@@ -0,0 +1 @@
+ALTER TABLE clients DROP COLUMN IF EXISTS first_party;
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS first_party BOOLEAN NOT NULL DEFAULT FALSE;
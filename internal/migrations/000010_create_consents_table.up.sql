@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS consents (
+    user_id VARCHAR(255) NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    client_id VARCHAR(255) NOT NULL REFERENCES clients (id) ON DELETE CASCADE,
+    scope TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    PRIMARY KEY (user_id, client_id)
+);
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS device_codes (
+    id VARCHAR(64) PRIMARY KEY,
+    user_code VARCHAR(16) NOT NULL UNIQUE,
+    client_id VARCHAR(255) NOT NULL,
+    scope TEXT NOT NULL DEFAULT '',
+    status VARCHAR(16) NOT NULL DEFAULT 'pending',
+    user_id VARCHAR(255) REFERENCES users (id) ON DELETE CASCADE,
+    provider VARCHAR(255) NOT NULL DEFAULT '',
+    auth_time TIMESTAMP WITH TIME ZONE,
+    poll_interval INTEGER NOT NULL,
+    last_polled_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_device_codes_expires_at ON device_codes (expires_at);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_users_email;
+ALTER TABLE users ADD CONSTRAINT users_email_key UNIQUE (email);
+DROP TABLE IF EXISTS identities;
\ No newline at end of file
@@ -0,0 +1,27 @@
+CREATE TABLE IF NOT EXISTS identities (
+    id VARCHAR(36) PRIMARY KEY,
+    user_id VARCHAR(255) NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    provider VARCHAR(255) NOT NULL,
+    provider_subject VARCHAR(255) NOT NULL,
+    email VARCHAR(255) NOT NULL DEFAULT '',
+    email_verified BOOLEAN NOT NULL DEFAULT FALSE,
+    raw_profile JSONB,
+    linked_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    UNIQUE (provider, provider_subject)
+);
+
+CREATE INDEX IF NOT EXISTS idx_identities_user_id ON identities (user_id);
+
+-- Users used to be keyed by their provider's ID. Link them to the providers
+-- we have seen them log in with.
+INSERT INTO identities (id, user_id, provider, provider_subject, email, email_verified, linked_at, updated_at)
+SELECT gen_random_uuid()::text, users.id, logins.provider, users.id, users.email, users.email_verified, users.created_at, NOW()
+FROM users
+JOIN (SELECT user_id, provider FROM sessions UNION SELECT user_id, provider FROM refresh_tokens) logins ON logins.user_id = users.id
+ON CONFLICT (provider, provider_subject) DO NOTHING;
+
+-- An email no longer identifies a user: the same address may be unverified
+-- on one account and verified on another
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_email_key;
+CREATE INDEX IF NOT EXISTS idx_users_email ON users (email);
@@ -0,0 +1,2 @@
+ALTER TABLE users DROP COLUMN IF EXISTS login_count;
+ALTER TABLE users DROP COLUMN IF EXISTS last_login_at;
\ No newline at end of file
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS provider_tokens;
\ No newline at end of file
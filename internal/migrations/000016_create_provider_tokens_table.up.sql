@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS provider_tokens (
+    identity_id VARCHAR(36) PRIMARY KEY REFERENCES identities (id) ON DELETE CASCADE,
+    data_key TEXT NOT NULL,
+    token TEXT NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
@@ -0,0 +1 @@
+ALTER TABLE identities DROP COLUMN IF EXISTS reconsent_required;
\ No newline at end of file
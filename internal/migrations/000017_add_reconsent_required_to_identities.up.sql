@@ -0,0 +1 @@
+ALTER TABLE identities ADD COLUMN IF NOT EXISTS reconsent_required BOOLEAN NOT NULL DEFAULT FALSE;
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE identities DROP COLUMN IF EXISTS granted_scopes;
\ No newline at end of file
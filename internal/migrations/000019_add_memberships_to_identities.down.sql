@@ -0,0 +1 @@
+ALTER TABLE identities DROP COLUMN IF EXISTS memberships;
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE identities ADD COLUMN IF NOT EXISTS memberships TEXT NOT NULL DEFAULT '';
\ No newline at end of file
@@ -0,0 +1,2 @@
+DELETE FROM access_tokens WHERE user_id IS NULL;
+ALTER TABLE access_tokens ALTER COLUMN user_id SET NOT NULL;
\ No newline at end of file
@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// AccessToken is an opaque access token we issued. Only the hash of the
+// token is kept as the ID. FamilyID links it to the refresh token family it
+// was issued with, if any, so that revoking the family revokes it too.
+// UserID is empty for tokens of a client acting on its own behalf.
+type AccessToken struct {
+	ID        string     `json:"id"`
+	FamilyID  string     `json:"family_id,omitempty"`
+	UserID    string     `json:"user_id"`
+	ClientID  string     `json:"client_id"`
+	Provider  string     `json:"provider"`
+	Scope     string     `json:"scope"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// AuthorizationCode is a code issued by /authorize, waiting to be exchanged
+// at /token. Only the hash of the code is kept as the ID. RedirectURI is
+// empty if /authorize was not sent one.
+type AuthorizationCode struct {
+	ID            string    `json:"id"`
+	ClientID      string    `json:"client_id"`
+	UserID        string    `json:"user_id"`
+	Provider      string    `json:"provider"`
+	RedirectURI   string    `json:"redirect_uri"`
+	Scope         string    `json:"scope"`
+	Nonce         string    `json:"nonce,omitempty"`
+	CodeChallenge string    `json:"code_challenge,omitempty"`
+	AuthTime      time.Time `json:"auth_time"`
+	CreatedAt     time.Time `json:"created_at"`
+	ExpiresAt     time.Time `json:"expires_at"`
+}
@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// Client is an application that logs its users in through us
+type Client struct {
+	ID   string `json:"client_id"`
+	Name string `json:"client_name"`
+	// SecretHash is the SHA-256 of the client secret; public clients have none
+	SecretHash string `json:"-"`
+	// PreviousSecretHash is the secret replaced by the last rotation, which
+	// is still accepted until PreviousSecretExpiresAt
+	PreviousSecretHash      string     `json:"-"`
+	PreviousSecretExpiresAt *time.Time `json:"previous_secret_expires_at,omitempty"`
+	RedirectURIs            []string   `json:"redirect_uris"`
+	Scopes                  []string   `json:"scopes"`
+	GrantTypes              []string   `json:"grant_types"`
+	Public                  bool       `json:"public"`
+	// FirstParty clients are our own apps, which users are not asked to
+	// approve
+	FirstParty bool `json:"first_party"`
+	// RegistrationTokenHash is the SHA-256 of the token that lets a client
+	// registered through /register manage its own registration
+	RegistrationTokenHash string    `json:"-"`
+	CreatedAt             time.Time `json:"created_at"`
+	UpdatedAt             time.Time `json:"updated_at"`
+}
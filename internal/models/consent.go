@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// Consent records the scopes a user allowed a client to receive, so that
+// they are not asked again
+type Consent struct {
+	UserID    string    `json:"user_id"`
+	ClientID  string    `json:"client_id"`
+	Scope     string    `json:"scope"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
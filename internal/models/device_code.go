@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// Statuses of a DeviceCode
+const (
+	DeviceCodePending  = "pending"
+	DeviceCodeApproved = "approved"
+	DeviceCodeDenied   = "denied"
+)
+
+// DeviceCode is a device authorization request (RFC 8628) waiting for a
+// user to enter its UserCode. Only the hash of the device code the device
+// polls with is kept as the ID. UserID, Provider and AuthTime are set once
+// the user approves.
+type DeviceCode struct {
+	ID       string     `json:"id"`
+	UserCode string     `json:"user_code"`
+	ClientID string     `json:"client_id"`
+	Scope    string     `json:"scope"`
+	Status   string     `json:"status"`
+	UserID   string     `json:"user_id,omitempty"`
+	Provider string     `json:"provider,omitempty"`
+	AuthTime *time.Time `json:"auth_time,omitempty"`
+	// Interval is the number of seconds the device must wait between polls
+	Interval     int        `json:"interval"`
+	LastPolledAt *time.Time `json:"last_polled_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	ExpiresAt    time.Time  `json:"expires_at"`
+}
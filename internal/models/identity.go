@@ -0,0 +1,31 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Identity is what an upstream provider tells us about a logged-in user.
+// Once stored, it links the provider's account, named by Provider and
+// Subject, to the user with UserID.
+type Identity struct {
+	ID            string          `json:"id,omitempty"`
+	UserID        string          `json:"user_id,omitempty"`
+	Provider      string          `json:"provider"`
+	Subject       string          `json:"subject"`
+	Email         string          `json:"email"`
+	EmailVerified bool            `json:"email_verified"`
+	Username      string          `json:"username"`
+	AvatarURL     string          `json:"avatar_url"`
+	RawProfile    json.RawMessage `json:"raw_profile,omitempty"`
+	LinkedAt      time.Time       `json:"linked_at,omitempty"`
+	// GrantedScopes are all the scopes the user granted us at the provider
+	GrantedScopes []string `json:"granted_scopes,omitempty"`
+	// Memberships are the groups the user belongs to at the provider, like
+	// GitHub organizations ("acme") and teams ("acme/admins"). Nil means the
+	// provider was not asked, so the stored ones are kept.
+	Memberships []string `json:"memberships,omitempty"`
+	// ReconsentRequired is set when the provider stopped accepting the
+	// identity's refresh token, so the user must log in with it again
+	ReconsentRequired bool `json:"reconsent_required,omitempty"`
+}
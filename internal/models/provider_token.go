@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// ProviderToken is the token an upstream provider issued for one of a
+// user's identities, kept so that we can call the provider on their behalf.
+// Token is the sealed oauth2.Token, encrypted with its own data key, and
+// DataKey is that key sealed with the vault's keys.
+type ProviderToken struct {
+	IdentityID string     `json:"identity_id"`
+	UserID     string     `json:"user_id"`
+	Provider   string     `json:"provider"`
+	Subject    string     `json:"subject"`
+	DataKey    string     `json:"-"`
+	Token      string     `json:"-"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	// ReconsentRequired is the identity's flag; the token cannot be refreshed
+	ReconsentRequired bool      `json:"reconsent_required,omitempty"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
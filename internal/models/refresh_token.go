@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// RefreshToken is an opaque refresh token we issued. Only the hash of the
+// token is kept as the ID. Each use replaces the token with a new one in
+// the same family, so a second use of any token reveals that it leaked.
+type RefreshToken struct {
+	ID        string     `json:"id"`
+	FamilyID  string     `json:"family_id"`
+	UserID    string     `json:"user_id"`
+	ClientID  string     `json:"client_id"`
+	Provider  string     `json:"provider"`
+	Scope     string     `json:"scope"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// Session is a logged-in browser. The session cookie holds a random token;
+// only its hash is kept as the ID.
+type Session struct {
+	ID       string `json:"id"`
+	UserID   string `json:"user_id"`
+	Provider string `json:"provider"`
+	// CSRFToken must accompany state-changing form posts made in this session
+	CSRFToken  string    `json:"csrf_token"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
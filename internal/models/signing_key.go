@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// SigningKey is a key we sign tokens with. The private key is stored
+// encrypted; the ID is the kid put in token headers.
+type SigningKey struct {
+	ID           string    `json:"id"`
+	Algorithm    string    `json:"algorithm"`
+	EncryptedKey string    `json:"encrypted_key"`
+	CreatedAt    time.Time `json:"created_at"`
+	// RetiredAt is when the key stopped signing. Retired keys stay published
+	// for the overlap window so tokens signed before the rotation verify.
+	RetiredAt *time.Time `json:"retired_at,omitempty"`
+}
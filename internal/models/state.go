@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+// OAuthState is a pending authorization request, kept between the redirect
+// to the provider and the provider's callback.
+type OAuthState struct {
+	State    string `json:"state"`
+	Provider string `json:"provider"`
+	Binding  string `json:"binding"`
+	// CodeVerifier is the PKCE verifier sent when exchanging the code
+	CodeVerifier string `json:"code_verifier,omitempty"`
+	// Nonce binds an OpenID Connect ID token to this login
+	Nonce string `json:"nonce,omitempty"`
+	// ReturnTo is the local path to send the browser to after login
+	ReturnTo string `json:"return_to,omitempty"`
+	// IssueTokens answers the callback with our own tokens instead of
+	// starting a session
+	IssueTokens bool `json:"issue_tokens,omitempty"`
+	// LoginHint and Prompt are sent to the provider with this login
+	LoginHint string `json:"login_hint,omitempty"`
+	Prompt    string `json:"prompt,omitempty"`
+	// Scopes are what the login asks the provider for. AuthURL records the
+	// provider's scopes here unless it is set beforehand to ask for more.
+	Scopes []string `json:"scopes,omitempty"`
+	// ConnectUserID is set when a logged-in user connects another login to
+	// their account rather than logging in
+	ConnectUserID string    `json:"connect_user_id,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	ExpiresAt     time.Time `json:"expires_at"`
+}
@@ -1,10 +1,20 @@
 package models
 
+import "time"
+
 type User struct {
-	ID        string `json:"id"`
-	Username  string `json:"username"`
-	Email     string `json:"email"`
-	AvatarURL string `json:"avatar_url"`
-	CreatedAt string `json:"created_at"`
-	UpdatedAt string `json:"updated_at"`
+	ID       string `json:"id"`
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	// EmailVerified is whether the provider verified Email
+	EmailVerified bool      `json:"email_verified"`
+	AvatarURL     string    `json:"avatar_url"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	// LastLoginAt is nil for users who have not logged in since it was recorded
+	LastLoginAt *time.Time `json:"last_login_at,omitempty"`
+	LoginCount  int        `json:"login_count"`
+	// Memberships are those of all the user's identities, prefixed with
+	// their provider, like "github:acme/admins"
+	Memberships []string `json:"memberships,omitempty"`
 }
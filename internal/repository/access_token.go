@@ -0,0 +1,133 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"login-with-oauth/internal/logger"
+	"login-with-oauth/internal/models"
+	"time"
+)
+
+// AccessTokenRepository is the interface for the opaque access token repository
+type AccessTokenRepository interface {
+	CreateAccessToken(token models.AccessToken) error
+	GetAccessToken(id string) (*models.AccessToken, error)
+	RevokeAccessToken(id string, revokedAt time.Time) error
+	RevokeAccessTokenFamily(familyID string, revokedAt time.Time) (int64, error)
+	RevokeClientAccessTokens(userID, clientID string, revokedAt time.Time) (int64, error)
+	DeleteExpiredAccessTokens(before time.Time) (int64, error)
+}
+
+// AccessTokenRepositoryImpl is the implementation of the AccessTokenRepository interface
+type AccessTokenRepositoryImpl struct {
+	db *sql.DB
+}
+
+// NewAccessTokenRepository creates a new instance of the AccessTokenRepository
+func NewAccessTokenRepository(db *sql.DB) AccessTokenRepository {
+	return &AccessTokenRepositoryImpl{db: db}
+}
+
+// CreateAccessToken stores a new access token
+func (r *AccessTokenRepositoryImpl) CreateAccessToken(token models.AccessToken) error {
+	query := `
+		INSERT INTO access_tokens (id, family_id, user_id, client_id, provider, scope, created_at, expires_at)
+		VALUES ($1, $2, NULLIF($3, ''), $4, $5, $6, $7, $8)`
+
+	_, err := r.db.Exec(query,
+		token.ID,
+		token.FamilyID,
+		token.UserID,
+		token.ClientID,
+		token.Provider,
+		token.Scope,
+		token.CreatedAt,
+		token.ExpiresAt,
+	)
+	if err != nil {
+		logger.Log.Error("Failed to create access token: " + err.Error())
+		return fmt.Errorf("failed to create access token: %v", err)
+	}
+
+	return nil
+}
+
+// GetAccessToken retrieves an access token by its ID
+func (r *AccessTokenRepositoryImpl) GetAccessToken(id string) (*models.AccessToken, error) {
+	query := `
+		SELECT id, family_id, COALESCE(user_id, ''), client_id, provider, scope, created_at, expires_at, revoked_at
+		FROM access_tokens WHERE id = $1`
+
+	var token models.AccessToken
+	var revokedAt sql.NullTime
+	err := r.db.QueryRow(query, id).Scan(
+		&token.ID,
+		&token.FamilyID,
+		&token.UserID,
+		&token.ClientID,
+		&token.Provider,
+		&token.Scope,
+		&token.CreatedAt,
+		&token.ExpiresAt,
+		&revokedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		logger.Log.Error("Failed to get access token: " + err.Error())
+		return nil, fmt.Errorf("failed to get access token: %v", err)
+	}
+
+	if revokedAt.Valid {
+		token.RevokedAt = &revokedAt.Time
+	}
+
+	return &token, nil
+}
+
+// RevokeAccessToken revokes a single access token
+func (r *AccessTokenRepositoryImpl) RevokeAccessToken(id string, revokedAt time.Time) error {
+	query := "UPDATE access_tokens SET revoked_at = $2 WHERE id = $1 AND revoked_at IS NULL"
+
+	if _, err := r.db.Exec(query, id, revokedAt); err != nil {
+		return fmt.Errorf("failed to revoke access token: %v", err)
+	}
+
+	return nil
+}
+
+// RevokeAccessTokenFamily revokes the access tokens issued with a refresh token family
+func (r *AccessTokenRepositoryImpl) RevokeAccessTokenFamily(familyID string, revokedAt time.Time) (int64, error) {
+	query := "UPDATE access_tokens SET revoked_at = $2 WHERE family_id = $1 AND revoked_at IS NULL"
+
+	result, err := r.db.Exec(query, familyID, revokedAt)
+	if err != nil {
+		return 0, fmt.Errorf("failed to revoke access token family: %v", err)
+	}
+
+	return result.RowsAffected()
+}
+
+// RevokeClientAccessTokens revokes every access token a client holds for a user
+func (r *AccessTokenRepositoryImpl) RevokeClientAccessTokens(userID, clientID string, revokedAt time.Time) (int64, error) {
+	query := "UPDATE access_tokens SET revoked_at = $3 WHERE user_id = $1 AND client_id = $2 AND revoked_at IS NULL"
+
+	result, err := r.db.Exec(query, userID, clientID, revokedAt)
+	if err != nil {
+		return 0, fmt.Errorf("failed to revoke client access tokens: %v", err)
+	}
+
+	return result.RowsAffected()
+}
+
+// DeleteExpiredAccessTokens removes tokens that expired before the given time
+func (r *AccessTokenRepositoryImpl) DeleteExpiredAccessTokens(before time.Time) (int64, error) {
+	result, err := r.db.Exec("DELETE FROM access_tokens WHERE expires_at < $1", before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired access tokens: %v", err)
+	}
+
+	return result.RowsAffected()
+}
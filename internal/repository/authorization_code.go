@@ -0,0 +1,95 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"login-with-oauth/internal/logger"
+	"login-with-oauth/internal/models"
+	"time"
+)
+
+// AuthorizationCodeRepository is the interface for the authorization code repository
+type AuthorizationCodeRepository interface {
+	SaveAuthorizationCode(code models.AuthorizationCode) error
+	TakeAuthorizationCode(id string) (*models.AuthorizationCode, error)
+	DeleteExpiredAuthorizationCodes(before time.Time) (int64, error)
+}
+
+// AuthorizationCodeRepositoryImpl is the implementation of the AuthorizationCodeRepository interface
+type AuthorizationCodeRepositoryImpl struct {
+	db *sql.DB
+}
+
+// NewAuthorizationCodeRepository creates a new instance of the AuthorizationCodeRepository
+func NewAuthorizationCodeRepository(db *sql.DB) AuthorizationCodeRepository {
+	return &AuthorizationCodeRepositoryImpl{db: db}
+}
+
+// SaveAuthorizationCode stores a new authorization code
+func (r *AuthorizationCodeRepositoryImpl) SaveAuthorizationCode(code models.AuthorizationCode) error {
+	query := `
+		INSERT INTO authorization_codes (id, client_id, user_id, provider, redirect_uri, scope, nonce, code_challenge, auth_time, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`
+
+	_, err := r.db.Exec(query,
+		code.ID,
+		code.ClientID,
+		code.UserID,
+		code.Provider,
+		code.RedirectURI,
+		code.Scope,
+		code.Nonce,
+		code.CodeChallenge,
+		code.AuthTime,
+		code.CreatedAt,
+		code.ExpiresAt,
+	)
+	if err != nil {
+		logger.Log.Error("Failed to save authorization code: " + err.Error())
+		return fmt.Errorf("failed to save authorization code: %v", err)
+	}
+
+	return nil
+}
+
+// TakeAuthorizationCode deletes a code and returns it, so that each code can be used once
+func (r *AuthorizationCodeRepositoryImpl) TakeAuthorizationCode(id string) (*models.AuthorizationCode, error) {
+	query := `
+		DELETE FROM authorization_codes WHERE id = $1
+		RETURNING id, client_id, user_id, provider, redirect_uri, scope, nonce, code_challenge, auth_time, created_at, expires_at`
+
+	var code models.AuthorizationCode
+	err := r.db.QueryRow(query, id).Scan(
+		&code.ID,
+		&code.ClientID,
+		&code.UserID,
+		&code.Provider,
+		&code.RedirectURI,
+		&code.Scope,
+		&code.Nonce,
+		&code.CodeChallenge,
+		&code.AuthTime,
+		&code.CreatedAt,
+		&code.ExpiresAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		logger.Log.Error("Failed to take authorization code: " + err.Error())
+		return nil, fmt.Errorf("failed to take authorization code: %v", err)
+	}
+
+	return &code, nil
+}
+
+// DeleteExpiredAuthorizationCodes removes codes that expired before the given time
+func (r *AuthorizationCodeRepositoryImpl) DeleteExpiredAuthorizationCodes(before time.Time) (int64, error) {
+	result, err := r.db.Exec("DELETE FROM authorization_codes WHERE expires_at < $1", before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired authorization codes: %v", err)
+	}
+
+	return result.RowsAffected()
+}
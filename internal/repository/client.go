@@ -0,0 +1,185 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"login-with-oauth/internal/logger"
+	"login-with-oauth/internal/models"
+	"strings"
+)
+
+// ClientRepository is the interface for the OAuth client repository
+type ClientRepository interface {
+	CreateClient(client models.Client) error
+	GetClient(id string) (*models.Client, error)
+	ListClients() ([]models.Client, error)
+	UpdateClient(client models.Client) error
+	DeleteClient(id string) error
+}
+
+// ClientRepositoryImpl is the implementation of the ClientRepository interface
+type ClientRepositoryImpl struct {
+	db *sql.DB
+}
+
+// NewClientRepository creates a new instance of the ClientRepository
+func NewClientRepository(db *sql.DB) ClientRepository {
+	return &ClientRepositoryImpl{db: db}
+}
+
+// Redirect URIs, scopes and grant types cannot contain spaces, so each list
+// is stored as a space-separated column
+const clientColumns = "id, name, secret_hash, previous_secret_hash, previous_secret_expires_at, redirect_uris, scopes, grant_types, public, first_party, registration_token_hash, created_at, updated_at"
+
+// CreateClient stores a new client
+func (r *ClientRepositoryImpl) CreateClient(client models.Client) error {
+	query := `
+		INSERT INTO clients (` + clientColumns + `)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)`
+
+	_, err := r.db.Exec(query,
+		client.ID,
+		client.Name,
+		client.SecretHash,
+		client.PreviousSecretHash,
+		client.PreviousSecretExpiresAt,
+		strings.Join(client.RedirectURIs, " "),
+		strings.Join(client.Scopes, " "),
+		strings.Join(client.GrantTypes, " "),
+		client.Public,
+		client.FirstParty,
+		client.RegistrationTokenHash,
+		client.CreatedAt,
+		client.UpdatedAt,
+	)
+	if err != nil {
+		logger.Log.Error("Failed to create client: " + err.Error())
+		return fmt.Errorf("failed to create client: %v", err)
+	}
+
+	return nil
+}
+
+// GetClient finds a client by ID
+func (r *ClientRepositoryImpl) GetClient(id string) (*models.Client, error) {
+	client, err := scanClient(r.db.QueryRow("SELECT "+clientColumns+" FROM clients WHERE id = $1", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		logger.Log.Error("Failed to get client: " + err.Error())
+		return nil, fmt.Errorf("failed to get client: %v", err)
+	}
+
+	return client, nil
+}
+
+// ListClients returns every client, oldest first
+func (r *ClientRepositoryImpl) ListClients() ([]models.Client, error) {
+	rows, err := r.db.Query("SELECT " + clientColumns + " FROM clients ORDER BY created_at")
+	if err != nil {
+		logger.Log.Error("Failed to list clients: " + err.Error())
+		return nil, fmt.Errorf("failed to list clients: %v", err)
+	}
+	defer rows.Close()
+
+	var clients []models.Client
+	for rows.Next() {
+		client, err := scanClient(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan client: %v", err)
+		}
+		clients = append(clients, *client)
+	}
+
+	return clients, rows.Err()
+}
+
+// UpdateClient replaces every field of an existing client
+func (r *ClientRepositoryImpl) UpdateClient(client models.Client) error {
+	query := `
+		UPDATE clients
+		SET name = $2, secret_hash = $3, previous_secret_hash = $4, previous_secret_expires_at = $5,
+			redirect_uris = $6, scopes = $7, grant_types = $8, public = $9, first_party = $10,
+			registration_token_hash = $11, updated_at = $12
+		WHERE id = $1`
+
+	result, err := r.db.Exec(query,
+		client.ID,
+		client.Name,
+		client.SecretHash,
+		client.PreviousSecretHash,
+		client.PreviousSecretExpiresAt,
+		strings.Join(client.RedirectURIs, " "),
+		strings.Join(client.Scopes, " "),
+		strings.Join(client.GrantTypes, " "),
+		client.Public,
+		client.FirstParty,
+		client.RegistrationTokenHash,
+		client.UpdatedAt,
+	)
+	if err != nil {
+		logger.Log.Error("Failed to update client: " + err.Error())
+		return fmt.Errorf("failed to update client: %v", err)
+	}
+
+	return requireAffected(result)
+}
+
+// DeleteClient removes a client
+func (r *ClientRepositoryImpl) DeleteClient(id string) error {
+	result, err := r.db.Exec("DELETE FROM clients WHERE id = $1", id)
+	if err != nil {
+		logger.Log.Error("Failed to delete client: " + err.Error())
+		return fmt.Errorf("failed to delete client: %v", err)
+	}
+
+	return requireAffected(result)
+}
+
+// scanClient reads a row selected with clientColumns
+func scanClient(row interface{ Scan(...any) error }) (*models.Client, error) {
+	var client models.Client
+	var previousExpiresAt sql.NullTime
+	var redirectURIs, scopes, grantTypes string
+	err := row.Scan(
+		&client.ID,
+		&client.Name,
+		&client.SecretHash,
+		&client.PreviousSecretHash,
+		&previousExpiresAt,
+		&redirectURIs,
+		&scopes,
+		&grantTypes,
+		&client.Public,
+		&client.FirstParty,
+		&client.RegistrationTokenHash,
+		&client.CreatedAt,
+		&client.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if previousExpiresAt.Valid {
+		client.PreviousSecretExpiresAt = &previousExpiresAt.Time
+	}
+	client.RedirectURIs = strings.Fields(redirectURIs)
+	client.Scopes = strings.Fields(scopes)
+	client.GrantTypes = strings.Fields(grantTypes)
+
+	return &client, nil
+}
+
+// requireAffected turns an update or delete that matched no row into ErrNotFound
+func requireAffected(result sql.Result) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to read affected rows: %v", err)
+	}
+	if affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"login-with-oauth/internal/logger"
+	"login-with-oauth/internal/models"
+)
+
+// ConsentRepository is the interface for the consent repository
+type ConsentRepository interface {
+	SaveConsent(consent models.Consent) error
+	GetConsent(userID, clientID string) (*models.Consent, error)
+	ListUserConsents(userID string) ([]models.Consent, error)
+	DeleteConsent(userID, clientID string) error
+}
+
+// ConsentRepositoryImpl is the implementation of the ConsentRepository interface
+type ConsentRepositoryImpl struct {
+	db *sql.DB
+}
+
+// NewConsentRepository creates a new instance of the ConsentRepository
+func NewConsentRepository(db *sql.DB) ConsentRepository {
+	return &ConsentRepositoryImpl{db: db}
+}
+
+// SaveConsent stores a consent, replacing the scope of an earlier one
+func (r *ConsentRepositoryImpl) SaveConsent(consent models.Consent) error {
+	query := `
+		INSERT INTO consents (user_id, client_id, scope, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id, client_id) DO UPDATE SET scope = EXCLUDED.scope, updated_at = EXCLUDED.updated_at`
+
+	_, err := r.db.Exec(query, consent.UserID, consent.ClientID, consent.Scope, consent.CreatedAt, consent.UpdatedAt)
+	if err != nil {
+		logger.Log.Error("Failed to save consent: " + err.Error())
+		return fmt.Errorf("failed to save consent: %v", err)
+	}
+
+	return nil
+}
+
+// GetConsent finds what a user granted a client
+func (r *ConsentRepositoryImpl) GetConsent(userID, clientID string) (*models.Consent, error) {
+	query := "SELECT user_id, client_id, scope, created_at, updated_at FROM consents WHERE user_id = $1 AND client_id = $2"
+
+	var consent models.Consent
+	err := r.db.QueryRow(query, userID, clientID).Scan(
+		&consent.UserID,
+		&consent.ClientID,
+		&consent.Scope,
+		&consent.CreatedAt,
+		&consent.UpdatedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		logger.Log.Error("Failed to get consent: " + err.Error())
+		return nil, fmt.Errorf("failed to get consent: %v", err)
+	}
+
+	return &consent, nil
+}
+
+// ListUserConsents returns every consent of a user, most recent first
+func (r *ConsentRepositoryImpl) ListUserConsents(userID string) ([]models.Consent, error) {
+	query := "SELECT user_id, client_id, scope, created_at, updated_at FROM consents WHERE user_id = $1 ORDER BY updated_at DESC"
+
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		logger.Log.Error("Failed to list consents: " + err.Error())
+		return nil, fmt.Errorf("failed to list consents: %v", err)
+	}
+	defer rows.Close()
+
+	var consents []models.Consent
+	for rows.Next() {
+		var consent models.Consent
+		if err := rows.Scan(&consent.UserID, &consent.ClientID, &consent.Scope, &consent.CreatedAt, &consent.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan consent: %v", err)
+		}
+		consents = append(consents, consent)
+	}
+
+	return consents, rows.Err()
+}
+
+// DeleteConsent removes what a user granted a client
+func (r *ConsentRepositoryImpl) DeleteConsent(userID, clientID string) error {
+	result, err := r.db.Exec("DELETE FROM consents WHERE user_id = $1 AND client_id = $2", userID, clientID)
+	if err != nil {
+		logger.Log.Error("Failed to delete consent: " + err.Error())
+		return fmt.Errorf("failed to delete consent: %v", err)
+	}
+
+	return requireAffected(result)
+}
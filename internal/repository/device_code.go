@@ -0,0 +1,142 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"login-with-oauth/internal/logger"
+	"login-with-oauth/internal/models"
+	"time"
+)
+
+// DeviceCodeRepository is the interface for the device code repository
+type DeviceCodeRepository interface {
+	CreateDeviceCode(code models.DeviceCode) error
+	GetDeviceCode(id string) (*models.DeviceCode, error)
+	GetDeviceCodeByUserCode(userCode string) (*models.DeviceCode, error)
+	DecideDeviceCode(id, status, userID, provider string, authTime time.Time) error
+	UpdateDeviceCodePoll(id string, polledAt time.Time, interval int) error
+	DeleteDeviceCode(id string) error
+	DeleteExpiredDeviceCodes(before time.Time) (int64, error)
+}
+
+// DeviceCodeRepositoryImpl is the implementation of the DeviceCodeRepository interface
+type DeviceCodeRepositoryImpl struct {
+	db *sql.DB
+}
+
+// NewDeviceCodeRepository creates a new instance of the DeviceCodeRepository
+func NewDeviceCodeRepository(db *sql.DB) DeviceCodeRepository {
+	return &DeviceCodeRepositoryImpl{db: db}
+}
+
+const deviceCodeColumns = `id, user_code, client_id, scope, status, COALESCE(user_id, ''), provider, auth_time, poll_interval, last_polled_at, created_at, expires_at`
+
+// CreateDeviceCode stores a new pending device code
+func (r *DeviceCodeRepositoryImpl) CreateDeviceCode(code models.DeviceCode) error {
+	query := `
+		INSERT INTO device_codes (id, user_code, client_id, scope, status, poll_interval, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+
+	_, err := r.db.Exec(query,
+		code.ID,
+		code.UserCode,
+		code.ClientID,
+		code.Scope,
+		code.Status,
+		code.Interval,
+		code.CreatedAt,
+		code.ExpiresAt,
+	)
+	if err != nil {
+		logger.Log.Error("Failed to create device code: " + err.Error())
+		return fmt.Errorf("failed to create device code: %v", err)
+	}
+
+	return nil
+}
+
+// GetDeviceCode retrieves a device code by the hash of its device code
+func (r *DeviceCodeRepositoryImpl) GetDeviceCode(id string) (*models.DeviceCode, error) {
+	return r.getDeviceCode("SELECT "+deviceCodeColumns+" FROM device_codes WHERE id = $1", id)
+}
+
+// GetDeviceCodeByUserCode retrieves a device code by the code users type in
+func (r *DeviceCodeRepositoryImpl) GetDeviceCodeByUserCode(userCode string) (*models.DeviceCode, error) {
+	return r.getDeviceCode("SELECT "+deviceCodeColumns+" FROM device_codes WHERE user_code = $1", userCode)
+}
+
+func (r *DeviceCodeRepositoryImpl) getDeviceCode(query, arg string) (*models.DeviceCode, error) {
+	var code models.DeviceCode
+	err := r.db.QueryRow(query, arg).Scan(
+		&code.ID,
+		&code.UserCode,
+		&code.ClientID,
+		&code.Scope,
+		&code.Status,
+		&code.UserID,
+		&code.Provider,
+		&code.AuthTime,
+		&code.Interval,
+		&code.LastPolledAt,
+		&code.CreatedAt,
+		&code.ExpiresAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		logger.Log.Error("Failed to get device code: " + err.Error())
+		return nil, fmt.Errorf("failed to get device code: %v", err)
+	}
+
+	return &code, nil
+}
+
+// DecideDeviceCode records the user's answer to a pending device code. It
+// returns ErrNotFound if the code is gone or was already decided.
+func (r *DeviceCodeRepositoryImpl) DecideDeviceCode(id, status, userID, provider string, authTime time.Time) error {
+	query := `
+		UPDATE device_codes SET status = $2, user_id = $3, provider = $4, auth_time = $5
+		WHERE id = $1 AND status = 'pending'`
+
+	result, err := r.db.Exec(query, id, status, userID, provider, authTime)
+	if err != nil {
+		logger.Log.Error("Failed to decide device code: " + err.Error())
+		return fmt.Errorf("failed to decide device code: %v", err)
+	}
+
+	return requireAffected(result)
+}
+
+// UpdateDeviceCodePoll records when the device last polled and the interval
+// it must keep from now on
+func (r *DeviceCodeRepositoryImpl) UpdateDeviceCodePoll(id string, polledAt time.Time, interval int) error {
+	result, err := r.db.Exec("UPDATE device_codes SET last_polled_at = $2, poll_interval = $3 WHERE id = $1", id, polledAt, interval)
+	if err != nil {
+		return fmt.Errorf("failed to update device code poll: %v", err)
+	}
+
+	return requireAffected(result)
+}
+
+// DeleteDeviceCode removes a device code. It returns ErrNotFound if the code
+// is already gone, so that only one poll can redeem an approved code.
+func (r *DeviceCodeRepositoryImpl) DeleteDeviceCode(id string) error {
+	result, err := r.db.Exec("DELETE FROM device_codes WHERE id = $1", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete device code: %v", err)
+	}
+
+	return requireAffected(result)
+}
+
+// DeleteExpiredDeviceCodes removes codes that expired before the given time
+func (r *DeviceCodeRepositoryImpl) DeleteExpiredDeviceCodes(before time.Time) (int64, error) {
+	result, err := r.db.Exec("DELETE FROM device_codes WHERE expires_at < $1", before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired device codes: %v", err)
+	}
+
+	return result.RowsAffected()
+}
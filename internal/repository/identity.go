@@ -0,0 +1,224 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"login-with-oauth/internal/logger"
+	"login-with-oauth/internal/models"
+	"slices"
+	"strings"
+)
+
+var (
+	// ErrIdentityTaken is returned when linking an identity that belongs to
+	// another user
+	ErrIdentityTaken = errors.New("identity is linked to another user")
+	// ErrEmailTaken is returned when a new identity's email belongs to a user
+	// it may not be merged with automatically
+	ErrEmailTaken = errors.New("email belongs to another user")
+	// ErrLastIdentity is returned when unlinking would leave a user without
+	// a way to log in
+	ErrLastIdentity = errors.New("identity is the user's last login method")
+)
+
+// IdentityRepository is the interface for the identity repository
+type IdentityRepository interface {
+	LinkIdentity(identity models.Identity) error
+	ListUserIdentities(userID string) ([]models.Identity, error)
+	UnlinkIdentity(userID, id string) error
+}
+
+// IdentityRepositoryImpl is the implementation of the IdentityRepository interface
+type IdentityRepositoryImpl struct {
+	db *sql.DB
+}
+
+// NewIdentityRepository creates a new instance of the IdentityRepository
+func NewIdentityRepository(db *sql.DB) IdentityRepository {
+	return &IdentityRepositoryImpl{db: db}
+}
+
+// LinkIdentity links an identity to identity.UserID. Linking it again to the
+// same user refreshes it; linking one that belongs to another user fails
+// with ErrIdentityTaken.
+func (r *IdentityRepositoryImpl) LinkIdentity(identity models.Identity) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var userID, grantedScopes string
+	err = tx.QueryRow("SELECT user_id, granted_scopes FROM identities WHERE provider = $1 AND provider_subject = $2 FOR UPDATE",
+		identity.Provider, identity.Subject).Scan(&userID, &grantedScopes)
+	switch {
+	case err == nil && userID != identity.UserID:
+		return ErrIdentityTaken
+	case err == nil:
+		err = refreshIdentity(tx, identity, grantedScopes)
+	case errors.Is(err, sql.ErrNoRows):
+		err = linkIdentity(tx, identity)
+	default:
+		return fmt.Errorf("failed to look up identity: %v", err)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit identity: %v", err)
+	}
+	return nil
+}
+
+// ListUserIdentities returns the identities a user can log in with, oldest first
+func (r *IdentityRepositoryImpl) ListUserIdentities(userID string) ([]models.Identity, error) {
+	query := `
+		SELECT id, user_id, provider, provider_subject, email, email_verified, granted_scopes, memberships, linked_at, reconsent_required
+		FROM identities WHERE user_id = $1 ORDER BY linked_at`
+
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		logger.Log.Error("Failed to list identities: " + err.Error())
+		return nil, fmt.Errorf("failed to list identities: %v", err)
+	}
+	defer rows.Close()
+
+	var identities []models.Identity
+	for rows.Next() {
+		var identity models.Identity
+		var grantedScopes, membershipList string
+		if err := rows.Scan(
+			&identity.ID,
+			&identity.UserID,
+			&identity.Provider,
+			&identity.Subject,
+			&identity.Email,
+			&identity.EmailVerified,
+			&grantedScopes,
+			&membershipList,
+			&identity.LinkedAt,
+			&identity.ReconsentRequired,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan identity: %v", err)
+		}
+		identity.GrantedScopes = strings.Fields(grantedScopes)
+		identity.Memberships = strings.Fields(membershipList)
+		identities = append(identities, identity)
+	}
+
+	return identities, rows.Err()
+}
+
+// UnlinkIdentity removes one of a user's identities. The last one cannot be
+// removed and gets ErrLastIdentity.
+func (r *IdentityRepositoryImpl) UnlinkIdentity(userID, id string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// Lock the user's identities so that two unlinks cannot each leave the
+	// other one as the last
+	rows, err := tx.Query("SELECT id FROM identities WHERE user_id = $1 FOR UPDATE", userID)
+	if err != nil {
+		return fmt.Errorf("failed to lock identities: %v", err)
+	}
+	found, count := false, 0
+	for rows.Next() {
+		var linked string
+		if err := rows.Scan(&linked); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan identity: %v", err)
+		}
+		found = found || linked == id
+		count++
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to lock identities: %v", err)
+	}
+
+	if !found {
+		return ErrNotFound
+	}
+	if count == 1 {
+		return ErrLastIdentity
+	}
+
+	if _, err := tx.Exec("DELETE FROM identities WHERE id = $1 AND user_id = $2", id, userID); err != nil {
+		logger.Log.Error("Failed to unlink identity: " + err.Error())
+		return fmt.Errorf("failed to unlink identity: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit unlink: %v", err)
+	}
+	return nil
+}
+
+// refreshIdentity stores what the provider sent about a linked identity.
+// Scopes granted before are kept, since a login may ask for fewer than an
+// earlier request for more did. Memberships are replaced, unless the
+// provider was not asked for them.
+func refreshIdentity(q queryer, identity models.Identity, grantedScopes string) error {
+	query := `
+		UPDATE identities SET email = $3, email_verified = $4, raw_profile = $5, granted_scopes = $6,
+			memberships = COALESCE($8, memberships), updated_at = $7
+		WHERE provider = $1 AND provider_subject = $2`
+
+	scopes := strings.Fields(grantedScopes)
+	for _, scope := range identity.GrantedScopes {
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	_, err := q.Exec(query, identity.Provider, identity.Subject, identity.Email, identity.EmailVerified, rawProfile(identity), strings.Join(scopes, " "), identity.LinkedAt, memberships(identity))
+	if err != nil {
+		return fmt.Errorf("failed to update identity: %v", err)
+	}
+	return nil
+}
+
+func linkIdentity(q queryer, identity models.Identity) error {
+	query := `
+		INSERT INTO identities (id, user_id, provider, provider_subject, email, email_verified, raw_profile, granted_scopes, memberships, linked_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, COALESCE($9, ''), $10, $10)`
+
+	_, err := q.Exec(query,
+		identity.ID,
+		identity.UserID,
+		identity.Provider,
+		identity.Subject,
+		identity.Email,
+		identity.EmailVerified,
+		rawProfile(identity),
+		strings.Join(identity.GrantedScopes, " "),
+		memberships(identity),
+		identity.LinkedAt,
+	)
+	if err != nil {
+		logger.Log.Error("Failed to link identity: " + err.Error())
+		return fmt.Errorf("failed to link identity: %v", err)
+	}
+	return nil
+}
+
+// memberships is the memberships column of identity, NULL when unknown
+func memberships(identity models.Identity) any {
+	if identity.Memberships == nil {
+		return nil
+	}
+	return strings.Join(identity.Memberships, " ")
+}
+
+// rawProfile is the raw_profile column of identity, NULL when unknown
+func rawProfile(identity models.Identity) any {
+	if len(identity.RawProfile) == 0 {
+		return nil
+	}
+	return []byte(identity.RawProfile)
+}
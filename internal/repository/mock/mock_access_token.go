@@ -0,0 +1,124 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: internal/repository/access_token.go
+
+// Package mock is a generated GoMock package.
+package mock
+
+import (
+	models "login-with-oauth/internal/models"
+	reflect "reflect"
+	time "time"
+
+	gomock "github.com/golang/mock/gomock"
+)
+
+// MockAccessTokenRepository is a mock of AccessTokenRepository interface.
+type MockAccessTokenRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockAccessTokenRepositoryMockRecorder
+}
+
+// MockAccessTokenRepositoryMockRecorder is the mock recorder for MockAccessTokenRepository.
+type MockAccessTokenRepositoryMockRecorder struct {
+	mock *MockAccessTokenRepository
+}
+
+// NewMockAccessTokenRepository creates a new mock instance.
+func NewMockAccessTokenRepository(ctrl *gomock.Controller) *MockAccessTokenRepository {
+	mock := &MockAccessTokenRepository{ctrl: ctrl}
+	mock.recorder = &MockAccessTokenRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockAccessTokenRepository) EXPECT() *MockAccessTokenRepositoryMockRecorder {
+	return m.recorder
+}
+
+// CreateAccessToken mocks base method.
+func (m *MockAccessTokenRepository) CreateAccessToken(token models.AccessToken) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateAccessToken", token)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateAccessToken indicates an expected call of CreateAccessToken.
+func (mr *MockAccessTokenRepositoryMockRecorder) CreateAccessToken(token interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateAccessToken", reflect.TypeOf((*MockAccessTokenRepository)(nil).CreateAccessToken), token)
+}
+
+// DeleteExpiredAccessTokens mocks base method.
+func (m *MockAccessTokenRepository) DeleteExpiredAccessTokens(before time.Time) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteExpiredAccessTokens", before)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteExpiredAccessTokens indicates an expected call of DeleteExpiredAccessTokens.
+func (mr *MockAccessTokenRepositoryMockRecorder) DeleteExpiredAccessTokens(before interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteExpiredAccessTokens", reflect.TypeOf((*MockAccessTokenRepository)(nil).DeleteExpiredAccessTokens), before)
+}
+
+// GetAccessToken mocks base method.
+func (m *MockAccessTokenRepository) GetAccessToken(id string) (*models.AccessToken, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetAccessToken", id)
+	ret0, _ := ret[0].(*models.AccessToken)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetAccessToken indicates an expected call of GetAccessToken.
+func (mr *MockAccessTokenRepositoryMockRecorder) GetAccessToken(id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAccessToken", reflect.TypeOf((*MockAccessTokenRepository)(nil).GetAccessToken), id)
+}
+
+// RevokeAccessToken mocks base method.
+func (m *MockAccessTokenRepository) RevokeAccessToken(id string, revokedAt time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeAccessToken", id, revokedAt)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RevokeAccessToken indicates an expected call of RevokeAccessToken.
+func (mr *MockAccessTokenRepositoryMockRecorder) RevokeAccessToken(id, revokedAt interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeAccessToken", reflect.TypeOf((*MockAccessTokenRepository)(nil).RevokeAccessToken), id, revokedAt)
+}
+
+// RevokeAccessTokenFamily mocks base method.
+func (m *MockAccessTokenRepository) RevokeAccessTokenFamily(familyID string, revokedAt time.Time) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeAccessTokenFamily", familyID, revokedAt)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RevokeAccessTokenFamily indicates an expected call of RevokeAccessTokenFamily.
+func (mr *MockAccessTokenRepositoryMockRecorder) RevokeAccessTokenFamily(familyID, revokedAt interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeAccessTokenFamily", reflect.TypeOf((*MockAccessTokenRepository)(nil).RevokeAccessTokenFamily), familyID, revokedAt)
+}
+
+// RevokeClientAccessTokens mocks base method.
+func (m *MockAccessTokenRepository) RevokeClientAccessTokens(userID, clientID string, revokedAt time.Time) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeClientAccessTokens", userID, clientID, revokedAt)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RevokeClientAccessTokens indicates an expected call of RevokeClientAccessTokens.
+func (mr *MockAccessTokenRepositoryMockRecorder) RevokeClientAccessTokens(userID, clientID, revokedAt interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeClientAccessTokens", reflect.TypeOf((*MockAccessTokenRepository)(nil).RevokeClientAccessTokens), userID, clientID, revokedAt)
+}
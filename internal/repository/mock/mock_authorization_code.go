@@ -0,0 +1,80 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: internal/repository/authorization_code.go
+
+// Package mock is a generated GoMock package.
+package mock
+
+import (
+	models "login-with-oauth/internal/models"
+	reflect "reflect"
+	time "time"
+
+	gomock "github.com/golang/mock/gomock"
+)
+
+// MockAuthorizationCodeRepository is a mock of AuthorizationCodeRepository interface.
+type MockAuthorizationCodeRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockAuthorizationCodeRepositoryMockRecorder
+}
+
+// MockAuthorizationCodeRepositoryMockRecorder is the mock recorder for MockAuthorizationCodeRepository.
+type MockAuthorizationCodeRepositoryMockRecorder struct {
+	mock *MockAuthorizationCodeRepository
+}
+
+// NewMockAuthorizationCodeRepository creates a new mock instance.
+func NewMockAuthorizationCodeRepository(ctrl *gomock.Controller) *MockAuthorizationCodeRepository {
+	mock := &MockAuthorizationCodeRepository{ctrl: ctrl}
+	mock.recorder = &MockAuthorizationCodeRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockAuthorizationCodeRepository) EXPECT() *MockAuthorizationCodeRepositoryMockRecorder {
+	return m.recorder
+}
+
+// DeleteExpiredAuthorizationCodes mocks base method.
+func (m *MockAuthorizationCodeRepository) DeleteExpiredAuthorizationCodes(before time.Time) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteExpiredAuthorizationCodes", before)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteExpiredAuthorizationCodes indicates an expected call of DeleteExpiredAuthorizationCodes.
+func (mr *MockAuthorizationCodeRepositoryMockRecorder) DeleteExpiredAuthorizationCodes(before interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteExpiredAuthorizationCodes", reflect.TypeOf((*MockAuthorizationCodeRepository)(nil).DeleteExpiredAuthorizationCodes), before)
+}
+
+// SaveAuthorizationCode mocks base method.
+func (m *MockAuthorizationCodeRepository) SaveAuthorizationCode(code models.AuthorizationCode) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveAuthorizationCode", code)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveAuthorizationCode indicates an expected call of SaveAuthorizationCode.
+func (mr *MockAuthorizationCodeRepositoryMockRecorder) SaveAuthorizationCode(code interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveAuthorizationCode", reflect.TypeOf((*MockAuthorizationCodeRepository)(nil).SaveAuthorizationCode), code)
+}
+
+// TakeAuthorizationCode mocks base method.
+func (m *MockAuthorizationCodeRepository) TakeAuthorizationCode(id string) (*models.AuthorizationCode, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "TakeAuthorizationCode", id)
+	ret0, _ := ret[0].(*models.AuthorizationCode)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// TakeAuthorizationCode indicates an expected call of TakeAuthorizationCode.
+func (mr *MockAuthorizationCodeRepositoryMockRecorder) TakeAuthorizationCode(id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TakeAuthorizationCode", reflect.TypeOf((*MockAuthorizationCodeRepository)(nil).TakeAuthorizationCode), id)
+}
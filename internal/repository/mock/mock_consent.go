@@ -0,0 +1,93 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: internal/repository/consent.go
+
+// Package mock is a generated GoMock package.
+package mock
+
+import (
+	models "login-with-oauth/internal/models"
+	reflect "reflect"
+
+	gomock "github.com/golang/mock/gomock"
+)
+
+// MockConsentRepository is a mock of ConsentRepository interface.
+type MockConsentRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockConsentRepositoryMockRecorder
+}
+
+// MockConsentRepositoryMockRecorder is the mock recorder for MockConsentRepository.
+type MockConsentRepositoryMockRecorder struct {
+	mock *MockConsentRepository
+}
+
+// NewMockConsentRepository creates a new mock instance.
+func NewMockConsentRepository(ctrl *gomock.Controller) *MockConsentRepository {
+	mock := &MockConsentRepository{ctrl: ctrl}
+	mock.recorder = &MockConsentRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockConsentRepository) EXPECT() *MockConsentRepositoryMockRecorder {
+	return m.recorder
+}
+
+// DeleteConsent mocks base method.
+func (m *MockConsentRepository) DeleteConsent(userID, clientID string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteConsent", userID, clientID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteConsent indicates an expected call of DeleteConsent.
+func (mr *MockConsentRepositoryMockRecorder) DeleteConsent(userID, clientID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteConsent", reflect.TypeOf((*MockConsentRepository)(nil).DeleteConsent), userID, clientID)
+}
+
+// GetConsent mocks base method.
+func (m *MockConsentRepository) GetConsent(userID, clientID string) (*models.Consent, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetConsent", userID, clientID)
+	ret0, _ := ret[0].(*models.Consent)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetConsent indicates an expected call of GetConsent.
+func (mr *MockConsentRepositoryMockRecorder) GetConsent(userID, clientID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetConsent", reflect.TypeOf((*MockConsentRepository)(nil).GetConsent), userID, clientID)
+}
+
+// ListUserConsents mocks base method.
+func (m *MockConsentRepository) ListUserConsents(userID string) ([]models.Consent, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListUserConsents", userID)
+	ret0, _ := ret[0].([]models.Consent)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListUserConsents indicates an expected call of ListUserConsents.
+func (mr *MockConsentRepositoryMockRecorder) ListUserConsents(userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListUserConsents", reflect.TypeOf((*MockConsentRepository)(nil).ListUserConsents), userID)
+}
+
+// SaveConsent mocks base method.
+func (m *MockConsentRepository) SaveConsent(consent models.Consent) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveConsent", consent)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveConsent indicates an expected call of SaveConsent.
+func (mr *MockConsentRepositoryMockRecorder) SaveConsent(consent interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveConsent", reflect.TypeOf((*MockConsentRepository)(nil).SaveConsent), consent)
+}
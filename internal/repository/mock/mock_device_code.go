@@ -0,0 +1,137 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: internal/repository/device_code.go
+
+// Package mock is a generated GoMock package.
+package mock
+
+import (
+	models "login-with-oauth/internal/models"
+	reflect "reflect"
+	time "time"
+
+	gomock "github.com/golang/mock/gomock"
+)
+
+// MockDeviceCodeRepository is a mock of DeviceCodeRepository interface.
+type MockDeviceCodeRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockDeviceCodeRepositoryMockRecorder
+}
+
+// MockDeviceCodeRepositoryMockRecorder is the mock recorder for MockDeviceCodeRepository.
+type MockDeviceCodeRepositoryMockRecorder struct {
+	mock *MockDeviceCodeRepository
+}
+
+// NewMockDeviceCodeRepository creates a new mock instance.
+func NewMockDeviceCodeRepository(ctrl *gomock.Controller) *MockDeviceCodeRepository {
+	mock := &MockDeviceCodeRepository{ctrl: ctrl}
+	mock.recorder = &MockDeviceCodeRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockDeviceCodeRepository) EXPECT() *MockDeviceCodeRepositoryMockRecorder {
+	return m.recorder
+}
+
+// CreateDeviceCode mocks base method.
+func (m *MockDeviceCodeRepository) CreateDeviceCode(code models.DeviceCode) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateDeviceCode", code)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateDeviceCode indicates an expected call of CreateDeviceCode.
+func (mr *MockDeviceCodeRepositoryMockRecorder) CreateDeviceCode(code interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateDeviceCode", reflect.TypeOf((*MockDeviceCodeRepository)(nil).CreateDeviceCode), code)
+}
+
+// DecideDeviceCode mocks base method.
+func (m *MockDeviceCodeRepository) DecideDeviceCode(id, status, userID, provider string, authTime time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DecideDeviceCode", id, status, userID, provider, authTime)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DecideDeviceCode indicates an expected call of DecideDeviceCode.
+func (mr *MockDeviceCodeRepositoryMockRecorder) DecideDeviceCode(id, status, userID, provider, authTime interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DecideDeviceCode", reflect.TypeOf((*MockDeviceCodeRepository)(nil).DecideDeviceCode), id, status, userID, provider, authTime)
+}
+
+// DeleteDeviceCode mocks base method.
+func (m *MockDeviceCodeRepository) DeleteDeviceCode(id string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteDeviceCode", id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteDeviceCode indicates an expected call of DeleteDeviceCode.
+func (mr *MockDeviceCodeRepositoryMockRecorder) DeleteDeviceCode(id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteDeviceCode", reflect.TypeOf((*MockDeviceCodeRepository)(nil).DeleteDeviceCode), id)
+}
+
+// DeleteExpiredDeviceCodes mocks base method.
+func (m *MockDeviceCodeRepository) DeleteExpiredDeviceCodes(before time.Time) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteExpiredDeviceCodes", before)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteExpiredDeviceCodes indicates an expected call of DeleteExpiredDeviceCodes.
+func (mr *MockDeviceCodeRepositoryMockRecorder) DeleteExpiredDeviceCodes(before interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteExpiredDeviceCodes", reflect.TypeOf((*MockDeviceCodeRepository)(nil).DeleteExpiredDeviceCodes), before)
+}
+
+// GetDeviceCode mocks base method.
+func (m *MockDeviceCodeRepository) GetDeviceCode(id string) (*models.DeviceCode, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetDeviceCode", id)
+	ret0, _ := ret[0].(*models.DeviceCode)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetDeviceCode indicates an expected call of GetDeviceCode.
+func (mr *MockDeviceCodeRepositoryMockRecorder) GetDeviceCode(id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetDeviceCode", reflect.TypeOf((*MockDeviceCodeRepository)(nil).GetDeviceCode), id)
+}
+
+// GetDeviceCodeByUserCode mocks base method.
+func (m *MockDeviceCodeRepository) GetDeviceCodeByUserCode(userCode string) (*models.DeviceCode, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetDeviceCodeByUserCode", userCode)
+	ret0, _ := ret[0].(*models.DeviceCode)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetDeviceCodeByUserCode indicates an expected call of GetDeviceCodeByUserCode.
+func (mr *MockDeviceCodeRepositoryMockRecorder) GetDeviceCodeByUserCode(userCode interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetDeviceCodeByUserCode", reflect.TypeOf((*MockDeviceCodeRepository)(nil).GetDeviceCodeByUserCode), userCode)
+}
+
+// UpdateDeviceCodePoll mocks base method.
+func (m *MockDeviceCodeRepository) UpdateDeviceCodePoll(id string, polledAt time.Time, interval int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateDeviceCodePoll", id, polledAt, interval)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateDeviceCodePoll indicates an expected call of UpdateDeviceCodePoll.
+func (mr *MockDeviceCodeRepositoryMockRecorder) UpdateDeviceCodePoll(id, polledAt, interval interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateDeviceCodePoll", reflect.TypeOf((*MockDeviceCodeRepository)(nil).UpdateDeviceCodePoll), id, polledAt, interval)
+}
@@ -0,0 +1,107 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: internal/repository/provider_token.go
+
+// Package mock is a generated GoMock package.
+package mock
+
+import (
+	models "login-with-oauth/internal/models"
+	reflect "reflect"
+
+	gomock "github.com/golang/mock/gomock"
+)
+
+// MockProviderTokenRepository is a mock of ProviderTokenRepository interface.
+type MockProviderTokenRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockProviderTokenRepositoryMockRecorder
+}
+
+// MockProviderTokenRepositoryMockRecorder is the mock recorder for MockProviderTokenRepository.
+type MockProviderTokenRepositoryMockRecorder struct {
+	mock *MockProviderTokenRepository
+}
+
+// NewMockProviderTokenRepository creates a new mock instance.
+func NewMockProviderTokenRepository(ctrl *gomock.Controller) *MockProviderTokenRepository {
+	mock := &MockProviderTokenRepository{ctrl: ctrl}
+	mock.recorder = &MockProviderTokenRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockProviderTokenRepository) EXPECT() *MockProviderTokenRepositoryMockRecorder {
+	return m.recorder
+}
+
+// ClearReconsentRequired mocks base method.
+func (m *MockProviderTokenRepository) ClearReconsentRequired(provider, subject string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ClearReconsentRequired", provider, subject)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ClearReconsentRequired indicates an expected call of ClearReconsentRequired.
+func (mr *MockProviderTokenRepositoryMockRecorder) ClearReconsentRequired(provider, subject interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ClearReconsentRequired", reflect.TypeOf((*MockProviderTokenRepository)(nil).ClearReconsentRequired), provider, subject)
+}
+
+// GetIdentityProviderToken mocks base method.
+func (m *MockProviderTokenRepository) GetIdentityProviderToken(provider, subject string) (*models.ProviderToken, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetIdentityProviderToken", provider, subject)
+	ret0, _ := ret[0].(*models.ProviderToken)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetIdentityProviderToken indicates an expected call of GetIdentityProviderToken.
+func (mr *MockProviderTokenRepositoryMockRecorder) GetIdentityProviderToken(provider, subject interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetIdentityProviderToken", reflect.TypeOf((*MockProviderTokenRepository)(nil).GetIdentityProviderToken), provider, subject)
+}
+
+// GetProviderToken mocks base method.
+func (m *MockProviderTokenRepository) GetProviderToken(userID, provider string) (*models.ProviderToken, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetProviderToken", userID, provider)
+	ret0, _ := ret[0].(*models.ProviderToken)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetProviderToken indicates an expected call of GetProviderToken.
+func (mr *MockProviderTokenRepositoryMockRecorder) GetProviderToken(userID, provider interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetProviderToken", reflect.TypeOf((*MockProviderTokenRepository)(nil).GetProviderToken), userID, provider)
+}
+
+// MarkReconsentRequired mocks base method.
+func (m *MockProviderTokenRepository) MarkReconsentRequired(provider, subject string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MarkReconsentRequired", provider, subject)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// MarkReconsentRequired indicates an expected call of MarkReconsentRequired.
+func (mr *MockProviderTokenRepositoryMockRecorder) MarkReconsentRequired(provider, subject interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MarkReconsentRequired", reflect.TypeOf((*MockProviderTokenRepository)(nil).MarkReconsentRequired), provider, subject)
+}
+
+// SaveProviderToken mocks base method.
+func (m *MockProviderTokenRepository) SaveProviderToken(token models.ProviderToken) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveProviderToken", token)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveProviderToken indicates an expected call of SaveProviderToken.
+func (mr *MockProviderTokenRepositoryMockRecorder) SaveProviderToken(token interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveProviderToken", reflect.TypeOf((*MockProviderTokenRepository)(nil).SaveProviderToken), token)
+}
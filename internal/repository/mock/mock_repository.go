@@ -78,3 +78,18 @@ func (mr *MockUserRepositoryMockRecorder) GetUserByID(id interface{}) *gomock.Ca
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserByID", reflect.TypeOf((*MockUserRepository)(nil).GetUserByID), id)
 }
+
+// ResolveIdentity mocks base method.
+func (m *MockUserRepository) ResolveIdentity(identity models.Identity, newUser models.User) (*models.User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ResolveIdentity", identity, newUser)
+	ret0, _ := ret[0].(*models.User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ResolveIdentity indicates an expected call of ResolveIdentity.
+func (mr *MockUserRepositoryMockRecorder) ResolveIdentity(identity, newUser interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResolveIdentity", reflect.TypeOf((*MockUserRepository)(nil).ResolveIdentity), identity, newUser)
+}
@@ -0,0 +1,80 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: internal/repository/state.go
+
+// Package mock is a generated GoMock package.
+package mock
+
+import (
+	models "login-with-oauth/internal/models"
+	reflect "reflect"
+	time "time"
+
+	gomock "github.com/golang/mock/gomock"
+)
+
+// MockStateRepository is a mock of StateRepository interface.
+type MockStateRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockStateRepositoryMockRecorder
+}
+
+// MockStateRepositoryMockRecorder is the mock recorder for MockStateRepository.
+type MockStateRepositoryMockRecorder struct {
+	mock *MockStateRepository
+}
+
+// NewMockStateRepository creates a new mock instance.
+func NewMockStateRepository(ctrl *gomock.Controller) *MockStateRepository {
+	mock := &MockStateRepository{ctrl: ctrl}
+	mock.recorder = &MockStateRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockStateRepository) EXPECT() *MockStateRepositoryMockRecorder {
+	return m.recorder
+}
+
+// DeleteExpiredStates mocks base method.
+func (m *MockStateRepository) DeleteExpiredStates(before time.Time) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteExpiredStates", before)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteExpiredStates indicates an expected call of DeleteExpiredStates.
+func (mr *MockStateRepositoryMockRecorder) DeleteExpiredStates(before interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteExpiredStates", reflect.TypeOf((*MockStateRepository)(nil).DeleteExpiredStates), before)
+}
+
+// SaveState mocks base method.
+func (m *MockStateRepository) SaveState(state models.OAuthState) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SaveState", state)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveState indicates an expected call of SaveState.
+func (mr *MockStateRepositoryMockRecorder) SaveState(state interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveState", reflect.TypeOf((*MockStateRepository)(nil).SaveState), state)
+}
+
+// TakeState mocks base method.
+func (m *MockStateRepository) TakeState(state string) (*models.OAuthState, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "TakeState", state)
+	ret0, _ := ret[0].(*models.OAuthState)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// TakeState indicates an expected call of TakeState.
+func (mr *MockStateRepositoryMockRecorder) TakeState(state interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TakeState", reflect.TypeOf((*MockStateRepository)(nil).TakeState), state)
+}
@@ -0,0 +1,126 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"login-with-oauth/internal/logger"
+	"login-with-oauth/internal/models"
+)
+
+// ProviderTokenRepository is the interface for the provider token repository
+type ProviderTokenRepository interface {
+	SaveProviderToken(token models.ProviderToken) error
+	GetProviderToken(userID, provider string) (*models.ProviderToken, error)
+	GetIdentityProviderToken(provider, subject string) (*models.ProviderToken, error)
+	MarkReconsentRequired(provider, subject string) error
+	ClearReconsentRequired(provider, subject string) error
+}
+
+// ProviderTokenRepositoryImpl is the implementation of the ProviderTokenRepository interface
+type ProviderTokenRepositoryImpl struct {
+	db *sql.DB
+}
+
+// NewProviderTokenRepository creates a new instance of the ProviderTokenRepository
+func NewProviderTokenRepository(db *sql.DB) ProviderTokenRepository {
+	return &ProviderTokenRepositoryImpl{db: db}
+}
+
+// SaveProviderToken stores the token of the identity named by its Provider
+// and Subject, replacing the one stored before
+func (r *ProviderTokenRepositoryImpl) SaveProviderToken(token models.ProviderToken) error {
+	query := `
+		INSERT INTO provider_tokens (identity_id, data_key, token, expires_at, created_at, updated_at)
+		SELECT id, $3, $4, $5, $6, $6 FROM identities
+		WHERE provider = $1 AND provider_subject = $2
+		ON CONFLICT (identity_id) DO UPDATE SET
+			data_key = EXCLUDED.data_key,
+			token = EXCLUDED.token,
+			expires_at = EXCLUDED.expires_at,
+			updated_at = EXCLUDED.updated_at`
+
+	result, err := r.db.Exec(query, token.Provider, token.Subject, token.DataKey, token.Token, token.ExpiresAt, token.UpdatedAt)
+	if err != nil {
+		logger.Log.Error("Failed to save provider token: " + err.Error())
+		return fmt.Errorf("failed to save provider token: %v", err)
+	}
+
+	return requireAffected(result)
+}
+
+// providerTokenColumns are the columns scanProviderToken expects, from
+// provider_tokens t joined with identities i
+const providerTokenColumns = "t.identity_id, i.user_id, i.provider, i.provider_subject, t.data_key, t.token, t.expires_at, i.reconsent_required, t.created_at, t.updated_at"
+
+// GetProviderToken finds the token of a user's identity with a provider.
+// If the user linked several, the most recently stored token wins.
+func (r *ProviderTokenRepositoryImpl) GetProviderToken(userID, provider string) (*models.ProviderToken, error) {
+	query := `
+		SELECT ` + providerTokenColumns + `
+		FROM provider_tokens t JOIN identities i ON i.id = t.identity_id
+		WHERE i.user_id = $1 AND i.provider = $2
+		ORDER BY t.updated_at DESC LIMIT 1`
+
+	return scanProviderToken(r.db.QueryRow(query, userID, provider))
+}
+
+// GetIdentityProviderToken finds the token of the identity named by
+// provider and subject
+func (r *ProviderTokenRepositoryImpl) GetIdentityProviderToken(provider, subject string) (*models.ProviderToken, error) {
+	query := `
+		SELECT ` + providerTokenColumns + `
+		FROM provider_tokens t JOIN identities i ON i.id = t.identity_id
+		WHERE i.provider = $1 AND i.provider_subject = $2`
+
+	return scanProviderToken(r.db.QueryRow(query, provider, subject))
+}
+
+// MarkReconsentRequired flags an identity whose refresh token the provider
+// rejected
+func (r *ProviderTokenRepositoryImpl) MarkReconsentRequired(provider, subject string) error {
+	result, err := r.db.Exec("UPDATE identities SET reconsent_required = TRUE WHERE provider = $1 AND provider_subject = $2", provider, subject)
+	if err != nil {
+		logger.Log.Error("Failed to mark identity for reconsent: " + err.Error())
+		return fmt.Errorf("failed to mark identity for reconsent: %v", err)
+	}
+
+	return requireAffected(result)
+}
+
+// ClearReconsentRequired unflags an identity once the provider issued it a
+// new refresh token
+func (r *ProviderTokenRepositoryImpl) ClearReconsentRequired(provider, subject string) error {
+	result, err := r.db.Exec("UPDATE identities SET reconsent_required = FALSE WHERE provider = $1 AND provider_subject = $2", provider, subject)
+	if err != nil {
+		logger.Log.Error("Failed to clear identity reconsent: " + err.Error())
+		return fmt.Errorf("failed to clear identity reconsent: %v", err)
+	}
+
+	return requireAffected(result)
+}
+
+func scanProviderToken(row *sql.Row) (*models.ProviderToken, error) {
+	var token models.ProviderToken
+	err := row.Scan(
+		&token.IdentityID,
+		&token.UserID,
+		&token.Provider,
+		&token.Subject,
+		&token.DataKey,
+		&token.Token,
+		&token.ExpiresAt,
+		&token.ReconsentRequired,
+		&token.CreatedAt,
+		&token.UpdatedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		logger.Log.Error("Failed to get provider token: " + err.Error())
+		return nil, fmt.Errorf("failed to get provider token: %v", err)
+	}
+
+	return &token, nil
+}
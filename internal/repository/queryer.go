@@ -0,0 +1,10 @@
+package repository
+
+import "database/sql"
+
+// queryer is what *sql.DB and *sql.Tx have in common. It lives apart from
+// the repository interfaces so that mockgen does not mock it.
+type queryer interface {
+	QueryRow(query string, args ...any) *sql.Row
+	Exec(query string, args ...any) (sql.Result, error)
+}
@@ -0,0 +1,145 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"login-with-oauth/internal/logger"
+	"login-with-oauth/internal/models"
+	"time"
+)
+
+// RefreshTokenRepository is the interface for the refresh token repository
+type RefreshTokenRepository interface {
+	CreateRefreshToken(token models.RefreshToken) error
+	GetRefreshToken(id string) (*models.RefreshToken, error)
+	MarkRefreshTokenUsed(id string, usedAt time.Time) (bool, error)
+	RevokeRefreshTokenFamily(familyID string, revokedAt time.Time) (int64, error)
+	RevokeClientRefreshTokens(userID, clientID string, revokedAt time.Time) (int64, error)
+	DeleteExpiredRefreshTokens(before time.Time) (int64, error)
+}
+
+// RefreshTokenRepositoryImpl is the implementation of the RefreshTokenRepository interface
+type RefreshTokenRepositoryImpl struct {
+	db *sql.DB
+}
+
+// NewRefreshTokenRepository creates a new instance of the RefreshTokenRepository
+func NewRefreshTokenRepository(db *sql.DB) RefreshTokenRepository {
+	return &RefreshTokenRepositoryImpl{db: db}
+}
+
+// CreateRefreshToken stores a new refresh token
+func (r *RefreshTokenRepositoryImpl) CreateRefreshToken(token models.RefreshToken) error {
+	query := `
+		INSERT INTO refresh_tokens (id, family_id, user_id, client_id, provider, scope, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+
+	_, err := r.db.Exec(query,
+		token.ID,
+		token.FamilyID,
+		token.UserID,
+		token.ClientID,
+		token.Provider,
+		token.Scope,
+		token.CreatedAt,
+		token.ExpiresAt,
+	)
+	if err != nil {
+		logger.Log.Error("Failed to create refresh token: " + err.Error())
+		return fmt.Errorf("failed to create refresh token: %v", err)
+	}
+
+	return nil
+}
+
+// GetRefreshToken retrieves a refresh token by its ID
+func (r *RefreshTokenRepositoryImpl) GetRefreshToken(id string) (*models.RefreshToken, error) {
+	query := `
+		SELECT id, family_id, user_id, client_id, provider, scope, created_at, expires_at, used_at, revoked_at
+		FROM refresh_tokens WHERE id = $1`
+
+	var token models.RefreshToken
+	var usedAt, revokedAt sql.NullTime
+	err := r.db.QueryRow(query, id).Scan(
+		&token.ID,
+		&token.FamilyID,
+		&token.UserID,
+		&token.ClientID,
+		&token.Provider,
+		&token.Scope,
+		&token.CreatedAt,
+		&token.ExpiresAt,
+		&usedAt,
+		&revokedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		logger.Log.Error("Failed to get refresh token: " + err.Error())
+		return nil, fmt.Errorf("failed to get refresh token: %v", err)
+	}
+
+	if usedAt.Valid {
+		token.UsedAt = &usedAt.Time
+	}
+	if revokedAt.Valid {
+		token.RevokedAt = &revokedAt.Time
+	}
+
+	return &token, nil
+}
+
+// MarkRefreshTokenUsed records the use of a refresh token. It reports false
+// if the token had already been used or revoked, so that of two concurrent
+// uses only one succeeds.
+func (r *RefreshTokenRepositoryImpl) MarkRefreshTokenUsed(id string, usedAt time.Time) (bool, error) {
+	query := "UPDATE refresh_tokens SET used_at = $2 WHERE id = $1 AND used_at IS NULL AND revoked_at IS NULL"
+
+	result, err := r.db.Exec(query, id, usedAt)
+	if err != nil {
+		return false, fmt.Errorf("failed to mark refresh token used: %v", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to mark refresh token used: %v", err)
+	}
+
+	return rows == 1, nil
+}
+
+// RevokeRefreshTokenFamily revokes every token descended from the same login
+func (r *RefreshTokenRepositoryImpl) RevokeRefreshTokenFamily(familyID string, revokedAt time.Time) (int64, error) {
+	query := "UPDATE refresh_tokens SET revoked_at = $2 WHERE family_id = $1 AND revoked_at IS NULL"
+
+	result, err := r.db.Exec(query, familyID, revokedAt)
+	if err != nil {
+		return 0, fmt.Errorf("failed to revoke refresh token family: %v", err)
+	}
+
+	return result.RowsAffected()
+}
+
+// RevokeClientRefreshTokens revokes every token a client holds for a user
+func (r *RefreshTokenRepositoryImpl) RevokeClientRefreshTokens(userID, clientID string, revokedAt time.Time) (int64, error) {
+	query := "UPDATE refresh_tokens SET revoked_at = $3 WHERE user_id = $1 AND client_id = $2 AND revoked_at IS NULL"
+
+	result, err := r.db.Exec(query, userID, clientID, revokedAt)
+	if err != nil {
+		return 0, fmt.Errorf("failed to revoke client refresh tokens: %v", err)
+	}
+
+	return result.RowsAffected()
+}
+
+// DeleteExpiredRefreshTokens removes tokens that expired before the given time
+func (r *RefreshTokenRepositoryImpl) DeleteExpiredRefreshTokens(before time.Time) (int64, error) {
+	result, err := r.db.Exec("DELETE FROM refresh_tokens WHERE expires_at < $1", before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired refresh tokens: %v", err)
+	}
+
+	return result.RowsAffected()
+}
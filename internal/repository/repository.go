@@ -2,9 +2,11 @@ package repository
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"login-with-oauth/internal/logger"
 	"login-with-oauth/internal/models"
+	"strings"
 )
 
 // UserRepository is the interface for the user repository
@@ -12,6 +14,7 @@ type UserRepository interface {
 	CreateUser(user models.User) (*models.User, error)
 	GetUserByID(id string) (*models.User, error)
 	GetUserByEmail(email string) (*models.User, error)
+	ResolveIdentity(identity models.Identity, newUser models.User) (*models.User, error)
 }
 
 // UserRepositoryImpl is the implementation of the UserRepository interface
@@ -26,69 +29,203 @@ func NewUserRepository(db *sql.DB) UserRepository {
 
 // CreateUser creates a new user
 func (r *UserRepositoryImpl) CreateUser(user models.User) (*models.User, error) {
-	fmt.Printf("Repository: Creating user with data: %+v\n", user)
+	return createUser(r.db, user)
+}
 
-	// PostgreSQL upsert syntax using ON CONFLICT
+func createUser(q queryer, user models.User) (*models.User, error) {
 	query := `
-		INSERT INTO users (id, username, email, avatar_url, created_at, updated_at) 
-		VALUES ($1, $2, $3, $4, $5, $6)
-		ON CONFLICT (email) DO UPDATE SET 
-			email = EXCLUDED.email,
-			updated_at = EXCLUDED.updated_at
-		RETURNING *`
+		INSERT INTO users (id, username, email, email_verified, avatar_url, created_at, updated_at, last_login_at, login_count) 
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING ` + userColumns
 
 	// For PostgreSQL, use QueryRow to get the returned row
-	var savedUser models.User
-	err := r.db.QueryRow(query,
+	savedUser, err := scanUser(q.QueryRow(query,
 		user.ID,
 		user.Username,
 		user.Email,
+		user.EmailVerified,
 		user.AvatarURL,
 		user.CreatedAt,
 		user.UpdatedAt,
-	).Scan(
-		&savedUser.ID,
-		&savedUser.Username,
-		&savedUser.Email,
-		&savedUser.AvatarURL,
-		&savedUser.CreatedAt,
-		&savedUser.UpdatedAt,
-	)
-
+		user.LastLoginAt,
+		user.LoginCount,
+	))
 	if err != nil {
 		logger.Log.Error("Failed to execute insert query: " + err.Error())
 		return nil, fmt.Errorf("failed to execute insert query: %v", err)
 	}
 
-	return &savedUser, nil
+	return savedUser, nil
 }
 
-// GetUserByID retrieves a user by their ID
-func (r *UserRepositoryImpl) GetUserByID(id string) (*models.User, error) {
-	// TODO: Implement user retrieval logic
-	query := "SELECT id, username, email, avatar_url, created_at, updated_at FROM users WHERE id = ?"
-	row := r.db.QueryRow(query, id)
+// syncUser records a login of an existing user, refreshing the profile
+// fields the provider sent in profile. The email is only taken from a
+// provider that verified it. created_at is kept, and updated_at only moves
+// to profile.UpdatedAt if something changed.
+func syncUser(q queryer, id string, profile models.User) (*models.User, error) {
+	query := `
+		UPDATE users SET
+			username = COALESCE(NULLIF($2, ''), username),
+			avatar_url = COALESCE(NULLIF($3, ''), avatar_url),
+			email = CASE WHEN $5 AND $4 <> '' THEN $4 ELSE email END,
+			email_verified = CASE WHEN $5 AND $4 <> '' THEN TRUE ELSE email_verified END,
+			updated_at = CASE
+				WHEN (username, COALESCE(avatar_url, '')) IS DISTINCT FROM (COALESCE(NULLIF($2, ''), username), COALESCE(NULLIF($3, ''), avatar_url, ''))
+					OR ($5 AND $4 <> '' AND (email, email_verified) IS DISTINCT FROM ($4, TRUE))
+				THEN $6 ELSE updated_at END,
+			last_login_at = $6,
+			login_count = login_count + 1
+		WHERE id = $1
+		RETURNING ` + userColumns
 
-	var user models.User
-	err := row.Scan(&user.ID, &user.Username, &user.Email, &user.AvatarURL, &user.CreatedAt, &user.UpdatedAt)
+	user, err := scanUser(q.QueryRow(query, id, profile.Username, profile.AvatarURL, profile.Email, profile.EmailVerified, profile.UpdatedAt))
 	if err != nil {
-		return nil, err
+		logger.Log.Error("Failed to sync user: " + err.Error())
+		return nil, fmt.Errorf("failed to sync user: %v", err)
 	}
 
-	return &user, nil
+	return user, nil
+}
+
+// ResolveIdentity returns the user an identity logs in as, in one
+// transaction. A known identity is refreshed with what the provider sent.
+// An unknown one is linked to the user from before identities existed whose
+// ID is the identity's subject and who has the same email, then to a user
+// with the same verified email if the provider verified it too, and
+// otherwise to newUser, which is created. If the email belongs to a user the
+// identity may not be merged with, it fails with ErrEmailTaken. Existing
+// users get their profile synced from newUser and the login counted.
+func (r *UserRepositoryImpl) ResolveIdentity(identity models.Identity, newUser models.User) (*models.User, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var userID, grantedScopes string
+	var user *models.User
+	err = tx.QueryRow("SELECT user_id, granted_scopes FROM identities WHERE provider = $1 AND provider_subject = $2 FOR UPDATE",
+		identity.Provider, identity.Subject).Scan(&userID, &grantedScopes)
+	switch {
+	case err == nil:
+		if err := refreshIdentity(tx, identity, grantedScopes); err != nil {
+			return nil, err
+		}
+		if user, err = syncUser(tx, userID, newUser); err != nil {
+			return nil, err
+		}
+	case errors.Is(err, sql.ErrNoRows):
+		if userID, err = matchIdentity(tx, identity); err != nil {
+			return nil, err
+		}
+		if userID == "" {
+			user, err = createUser(tx, newUser)
+		} else {
+			user, err = syncUser(tx, userID, newUser)
+		}
+		if err != nil {
+			return nil, err
+		}
+		identity.UserID = user.ID
+		if err := linkIdentity(tx, identity); err != nil {
+			return nil, err
+		}
+		// The user was read before the identity was linked
+		for _, membership := range identity.Memberships {
+			user.Memberships = append(user.Memberships, identity.Provider+":"+membership)
+		}
+	default:
+		return nil, fmt.Errorf("failed to look up identity: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit identity: %v", err)
+	}
+	return user, nil
+}
+
+// matchIdentity finds the existing user an unknown identity belongs to, or
+// returns an empty ID if there is none
+func matchIdentity(q queryer, identity models.Identity) (string, error) {
+	var userID string
+	err := q.QueryRow(`
+		SELECT id FROM users
+		WHERE id = $1 AND email = $2 AND NOT EXISTS (SELECT 1 FROM identities WHERE identities.user_id = users.id)`,
+		identity.Subject, identity.Email).Scan(&userID)
+	if err == nil {
+		return userID, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("failed to look up legacy user: %v", err)
+	}
+
+	if identity.Email == "" {
+		return "", nil
+	}
+	var verified bool
+	err = q.QueryRow("SELECT id, email_verified FROM users WHERE email = $1 ORDER BY email_verified DESC, created_at LIMIT 1", identity.Email).Scan(&userID, &verified)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to look up user by email: %v", err)
+	}
+
+	// Only merge if both sides proved they own the address, or anyone could
+	// take over an account by claiming its email elsewhere
+	if !verified || !identity.EmailVerified {
+		return "", ErrEmailTaken
+	}
+	return userID, nil
+}
+
+// GetUserByID retrieves a user by their ID
+func (r *UserRepositoryImpl) GetUserByID(id string) (*models.User, error) {
+	return getUser(r.db, "SELECT "+userColumns+" FROM users WHERE id = $1", id)
 }
 
 // GetUserByEmail retrieves a user by their email
 func (r *UserRepositoryImpl) GetUserByEmail(email string) (*models.User, error) {
-	// TODO: Implement user retrieval logic
-	query := "SELECT id, username, email, avatar_url, created_at, updated_at FROM users WHERE email = ?"
-	row := r.db.QueryRow(query, email)
+	return getUser(r.db, "SELECT "+userColumns+" FROM users WHERE email = $1 ORDER BY email_verified DESC, created_at LIMIT 1", email)
+}
 
-	var user models.User
-	err := row.Scan(&user.ID, &user.Username, &user.Email, &user.AvatarURL, &user.CreatedAt, &user.UpdatedAt)
+// userColumns are the columns scanUser expects. The memberships of the
+// user's identities are collected as "provider:membership".
+const userColumns = `id, username, email, email_verified, COALESCE(avatar_url, ''), created_at, updated_at, last_login_at, login_count,
+	COALESCE((SELECT string_agg(i.provider || ':' || m, ' ')
+		FROM identities i, unnest(string_to_array(NULLIF(i.memberships, ''), ' ')) m
+		WHERE i.user_id = users.id), '')`
+
+func getUser(q queryer, query string, arg string) (*models.User, error) {
+	user, err := scanUser(q.QueryRow(query, arg))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
 
+	return user, nil
+}
+
+func scanUser(row *sql.Row) (*models.User, error) {
+	var user models.User
+	var memberships string
+	err := row.Scan(
+		&user.ID,
+		&user.Username,
+		&user.Email,
+		&user.EmailVerified,
+		&user.AvatarURL,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+		&user.LastLoginAt,
+		&user.LoginCount,
+		&memberships,
+	)
+	if err != nil {
+		return nil, err
+	}
+	user.Memberships = strings.Fields(memberships)
 	return &user, nil
 }
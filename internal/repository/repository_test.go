@@ -23,8 +23,8 @@ func TestCreateUser(t *testing.T) {
 		Username:  "testuser",
 		Email:     "test@example.com",
 		AvatarURL: "https://example.com/avatar.jpg",
-		CreatedAt: time.Now().Format(time.RFC3339),
-		UpdatedAt: time.Now().Format(time.RFC3339),
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
 	}
 
 	// Set expectations
@@ -51,8 +51,8 @@ func TestCreateUser_Error(t *testing.T) {
 		Username:  "testuser",
 		Email:     "test@example.com",
 		AvatarURL: "https://example.com/avatar.jpg",
-		CreatedAt: time.Now().Format(time.RFC3339),
-		UpdatedAt: time.Now().Format(time.RFC3339),
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
 	}
 
 	// Expect error
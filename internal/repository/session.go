@@ -0,0 +1,127 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"login-with-oauth/internal/logger"
+	"login-with-oauth/internal/models"
+	"time"
+)
+
+// SessionRepository is the interface for the session repository
+type SessionRepository interface {
+	CreateSession(session models.Session) error
+	GetSession(id string) (*models.Session, error)
+	TouchSession(id string, lastSeenAt time.Time) error
+	DeleteSession(id string) error
+	DeleteUserSessions(userID string) (int64, error)
+	DeleteExpiredSessions(before time.Time) (int64, error)
+}
+
+// SessionRepositoryImpl is the implementation of the SessionRepository interface
+type SessionRepositoryImpl struct {
+	db *sql.DB
+}
+
+// NewSessionRepository creates a new instance of the SessionRepository
+func NewSessionRepository(db *sql.DB) SessionRepository {
+	return &SessionRepositoryImpl{db: db}
+}
+
+// CreateSession stores a new session
+func (r *SessionRepositoryImpl) CreateSession(session models.Session) error {
+	query := `
+		INSERT INTO sessions (id, user_id, provider, csrf_token, ip, user_agent, created_at, last_seen_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+
+	_, err := r.db.Exec(query,
+		session.ID,
+		session.UserID,
+		session.Provider,
+		session.CSRFToken,
+		session.IP,
+		session.UserAgent,
+		session.CreatedAt,
+		session.LastSeenAt,
+		session.ExpiresAt,
+	)
+	if err != nil {
+		logger.Log.Error("Failed to create session: " + err.Error())
+		return fmt.Errorf("failed to create session: %v", err)
+	}
+
+	return nil
+}
+
+// GetSession retrieves a session by its ID
+func (r *SessionRepositoryImpl) GetSession(id string) (*models.Session, error) {
+	query := `
+		SELECT id, user_id, provider, csrf_token, ip, user_agent, created_at, last_seen_at, expires_at
+		FROM sessions WHERE id = $1`
+
+	var session models.Session
+	err := r.db.QueryRow(query, id).Scan(
+		&session.ID,
+		&session.UserID,
+		&session.Provider,
+		&session.CSRFToken,
+		&session.IP,
+		&session.UserAgent,
+		&session.CreatedAt,
+		&session.LastSeenAt,
+		&session.ExpiresAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		logger.Log.Error("Failed to get session: " + err.Error())
+		return nil, fmt.Errorf("failed to get session: %v", err)
+	}
+
+	return &session, nil
+}
+
+// TouchSession records that the session was used
+func (r *SessionRepositoryImpl) TouchSession(id string, lastSeenAt time.Time) error {
+	result, err := r.db.Exec("UPDATE sessions SET last_seen_at = $2 WHERE id = $1", id, lastSeenAt)
+	if err != nil {
+		return fmt.Errorf("failed to update session: %v", err)
+	}
+
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// DeleteSession removes a session
+func (r *SessionRepositoryImpl) DeleteSession(id string) error {
+	if _, err := r.db.Exec("DELETE FROM sessions WHERE id = $1", id); err != nil {
+		return fmt.Errorf("failed to delete session: %v", err)
+	}
+
+	return nil
+}
+
+// DeleteUserSessions removes every session of a user
+func (r *SessionRepositoryImpl) DeleteUserSessions(userID string) (int64, error) {
+	result, err := r.db.Exec("DELETE FROM sessions WHERE user_id = $1", userID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete user sessions: %v", err)
+	}
+
+	return result.RowsAffected()
+}
+
+// DeleteExpiredSessions removes sessions that expired before the given time
+func (r *SessionRepositoryImpl) DeleteExpiredSessions(before time.Time) (int64, error) {
+	result, err := r.db.Exec("DELETE FROM sessions WHERE expires_at < $1", before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired sessions: %v", err)
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,207 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"login-with-oauth/internal/logger"
+	"login-with-oauth/internal/models"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// SigningKeyRepository is the interface for the signing key repository
+type SigningKeyRepository interface {
+	ListSigningKeys() ([]models.SigningKey, error)
+	CreateSigningKey(key models.SigningKey) error
+	RetireSigningKey(id string, retiredAt time.Time) error
+	DeleteSigningKey(id string) error
+}
+
+// SigningKeyRepositoryImpl is the Postgres implementation of the
+// SigningKeyRepository interface
+type SigningKeyRepositoryImpl struct {
+	db *sql.DB
+}
+
+// NewSigningKeyRepository creates a new instance of the SigningKeyRepository
+func NewSigningKeyRepository(db *sql.DB) SigningKeyRepository {
+	return &SigningKeyRepositoryImpl{db: db}
+}
+
+// ListSigningKeys returns every key, oldest first
+func (r *SigningKeyRepositoryImpl) ListSigningKeys() ([]models.SigningKey, error) {
+	rows, err := r.db.Query("SELECT id, algorithm, encrypted_key, created_at, retired_at FROM signing_keys ORDER BY created_at")
+	if err != nil {
+		logger.Log.Error("Failed to list signing keys: " + err.Error())
+		return nil, fmt.Errorf("failed to list signing keys: %v", err)
+	}
+	defer rows.Close()
+
+	var keys []models.SigningKey
+	for rows.Next() {
+		var key models.SigningKey
+		var retiredAt sql.NullTime
+		if err := rows.Scan(&key.ID, &key.Algorithm, &key.EncryptedKey, &key.CreatedAt, &retiredAt); err != nil {
+			return nil, fmt.Errorf("failed to scan signing key: %v", err)
+		}
+		if retiredAt.Valid {
+			key.RetiredAt = &retiredAt.Time
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+// CreateSigningKey stores a new key
+func (r *SigningKeyRepositoryImpl) CreateSigningKey(key models.SigningKey) error {
+	query := `
+		INSERT INTO signing_keys (id, algorithm, encrypted_key, created_at)
+		VALUES ($1, $2, $3, $4)`
+
+	if _, err := r.db.Exec(query, key.ID, key.Algorithm, key.EncryptedKey, key.CreatedAt); err != nil {
+		logger.Log.Error("Failed to create signing key: " + err.Error())
+		return fmt.Errorf("failed to create signing key: %v", err)
+	}
+
+	return nil
+}
+
+// RetireSigningKey stops a key from being used for signing
+func (r *SigningKeyRepositoryImpl) RetireSigningKey(id string, retiredAt time.Time) error {
+	query := "UPDATE signing_keys SET retired_at = $2 WHERE id = $1 AND retired_at IS NULL"
+
+	if _, err := r.db.Exec(query, id, retiredAt); err != nil {
+		return fmt.Errorf("failed to retire signing key: %v", err)
+	}
+
+	return nil
+}
+
+// DeleteSigningKey removes a key
+func (r *SigningKeyRepositoryImpl) DeleteSigningKey(id string) error {
+	if _, err := r.db.Exec("DELETE FROM signing_keys WHERE id = $1", id); err != nil {
+		return fmt.Errorf("failed to delete signing key: %v", err)
+	}
+
+	return nil
+}
+
+// FileSigningKeyRepository keeps signing keys in a JSON file, for
+// deployments without a database
+type FileSigningKeyRepository struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileSigningKeyRepository creates a SigningKeyRepository storing keys
+// in signing_keys.json inside dir
+func NewFileSigningKeyRepository(dir string) SigningKeyRepository {
+	return &FileSigningKeyRepository{path: filepath.Join(dir, "signing_keys.json")}
+}
+
+// ListSigningKeys returns every key, oldest first
+func (r *FileSigningKeyRepository) ListSigningKeys() ([]models.SigningKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.read()
+}
+
+// CreateSigningKey stores a new key
+func (r *FileSigningKeyRepository) CreateSigningKey(key models.SigningKey) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	keys, err := r.read()
+	if err != nil {
+		return err
+	}
+	if slices.ContainsFunc(keys, func(saved models.SigningKey) bool { return saved.ID == key.ID }) {
+		return fmt.Errorf("signing key %s already exists", key.ID)
+	}
+
+	return r.write(append(keys, key))
+}
+
+// RetireSigningKey stops a key from being used for signing
+func (r *FileSigningKeyRepository) RetireSigningKey(id string, retiredAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	keys, err := r.read()
+	if err != nil {
+		return err
+	}
+	for i := range keys {
+		if keys[i].ID == id && keys[i].RetiredAt == nil {
+			keys[i].RetiredAt = &retiredAt
+		}
+	}
+
+	return r.write(keys)
+}
+
+// DeleteSigningKey removes a key
+func (r *FileSigningKeyRepository) DeleteSigningKey(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	keys, err := r.read()
+	if err != nil {
+		return err
+	}
+
+	return r.write(slices.DeleteFunc(keys, func(key models.SigningKey) bool { return key.ID == id }))
+}
+
+func (r *FileSigningKeyRepository) read() ([]models.SigningKey, error) {
+	data, err := os.ReadFile(r.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing keys: %v", err)
+	}
+
+	var keys []models.SigningKey
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("failed to decode signing keys: %v", err)
+	}
+
+	slices.SortFunc(keys, func(a, b models.SigningKey) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	return keys, nil
+}
+
+// write replaces the file atomically so that a crash cannot leave it half
+// written
+func (r *FileSigningKeyRepository) write(keys []models.SigningKey) error {
+	data, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode signing keys: %v", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(r.path), ".signing_keys-*")
+	if err != nil {
+		return fmt.Errorf("failed to write signing keys: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write signing keys: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write signing keys: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), r.path); err != nil {
+		return fmt.Errorf("failed to write signing keys: %v", err)
+	}
+
+	return nil
+}
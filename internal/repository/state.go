@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"login-with-oauth/internal/logger"
+	"login-with-oauth/internal/models"
+	"time"
+)
+
+// ErrNotFound is returned when a lookup matches no row
+var ErrNotFound = errors.New("record not found")
+
+// StateRepository is the interface for the OAuth state repository
+type StateRepository interface {
+	SaveState(state models.OAuthState) error
+	TakeState(state string) (*models.OAuthState, error)
+	DeleteExpiredStates(before time.Time) (int64, error)
+}
+
+// StateRepositoryImpl is the implementation of the StateRepository interface
+type StateRepositoryImpl struct {
+	db *sql.DB
+}
+
+// NewStateRepository creates a new instance of the StateRepository
+func NewStateRepository(db *sql.DB) StateRepository {
+	return &StateRepositoryImpl{db: db}
+}
+
+// SaveState stores a pending OAuth state
+func (r *StateRepositoryImpl) SaveState(state models.OAuthState) error {
+	payload, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to encode state: %v", err)
+	}
+
+	query := `
+		INSERT INTO oauth_states (state, payload, created_at, expires_at)
+		VALUES ($1, $2, $3, $4)`
+
+	if _, err := r.db.Exec(query, state.State, payload, state.CreatedAt, state.ExpiresAt); err != nil {
+		logger.Log.Error("Failed to save oauth state: " + err.Error())
+		return fmt.Errorf("failed to save oauth state: %v", err)
+	}
+
+	return nil
+}
+
+// TakeState deletes a state and returns it, so that each state can be used once
+func (r *StateRepositoryImpl) TakeState(state string) (*models.OAuthState, error) {
+	query := "DELETE FROM oauth_states WHERE state = $1 RETURNING payload"
+
+	var payload []byte
+	err := r.db.QueryRow(query, state).Scan(&payload)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		logger.Log.Error("Failed to take oauth state: " + err.Error())
+		return nil, fmt.Errorf("failed to take oauth state: %v", err)
+	}
+
+	var saved models.OAuthState
+	if err := json.Unmarshal(payload, &saved); err != nil {
+		return nil, fmt.Errorf("failed to decode state: %v", err)
+	}
+
+	return &saved, nil
+}
+
+// DeleteExpiredStates removes states that expired before the given time
+func (r *StateRepositoryImpl) DeleteExpiredStates(before time.Time) (int64, error) {
+	result, err := r.db.Exec("DELETE FROM oauth_states WHERE expires_at < $1", before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired states: %v", err)
+	}
+
+	return result.RowsAffected()
+}
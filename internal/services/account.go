@@ -0,0 +1,124 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"login-with-oauth/internal/models"
+	"login-with-oauth/internal/repository"
+	"slices"
+	"time"
+)
+
+var (
+	ErrEmailInUse       = errors.New("the email belongs to another account")
+	ErrIdentityInUse    = errors.New("the login is linked to another account")
+	ErrLastLoginMethod  = errors.New("the account has no other way to log in")
+	ErrIdentityNotFound = errors.New("the login is not linked to the account")
+)
+
+// AccountService turns identities returned by providers into local users
+type AccountService struct {
+	userRepository     repository.UserRepository
+	identityRepository repository.IdentityRepository
+}
+
+// NewAccountService creates a new AccountService
+func NewAccountService(userRepository repository.UserRepository, identityRepository repository.IdentityRepository) *AccountService {
+	return &AccountService{
+		userRepository:     userRepository,
+		identityRepository: identityRepository,
+	}
+}
+
+// CompleteLogin returns the user identity is linked to, creating one on
+// first login. An identity whose email belongs to an account it cannot be
+// merged with gets ErrEmailInUse; the user must log in to that account and
+// connect the identity from there.
+func (s *AccountService) CompleteLogin(identity *models.Identity) (*models.User, error) {
+	return resolveUser(s.userRepository, identity)
+}
+
+// Connect links identity to a logged-in user. An identity linked to another
+// user gets ErrIdentityInUse.
+func (s *AccountService) Connect(user *models.User, identity *models.Identity) error {
+	id, err := newUUID()
+	if err != nil {
+		return fmt.Errorf("failed to generate identity ID: %v", err)
+	}
+
+	linked := *identity
+	linked.ID = id
+	linked.UserID = user.ID
+	linked.LinkedAt = time.Now()
+
+	err = s.identityRepository.LinkIdentity(linked)
+	if errors.Is(err, repository.ErrIdentityTaken) {
+		return ErrIdentityInUse
+	}
+	if err != nil {
+		return fmt.Errorf("failed to link %s identity: %v", identity.Provider, err)
+	}
+
+	return nil
+}
+
+// Identities lists the logins of a user, oldest first
+func (s *AccountService) Identities(userID string) ([]models.Identity, error) {
+	return s.identityRepository.ListUserIdentities(userID)
+}
+
+// Disconnect unlinks one of a user's identities. The last one is kept so
+// that the user can still log in, and gets ErrLastLoginMethod.
+func (s *AccountService) Disconnect(userID, identityID string) error {
+	err := s.identityRepository.UnlinkIdentity(userID, identityID)
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		return ErrIdentityNotFound
+	case errors.Is(err, repository.ErrLastIdentity):
+		return ErrLastLoginMethod
+	case err != nil:
+		return fmt.Errorf("failed to unlink identity: %v", err)
+	}
+
+	return nil
+}
+
+// HasScopes reports whether one of the user's identities with provider was
+// granted all of scopes
+func (s *AccountService) HasScopes(userID, provider string, scopes ...string) (bool, error) {
+	missing, _, err := s.MissingScopes(userID, provider, scopes)
+	if err != nil {
+		return false, err
+	}
+	return len(missing) == 0, nil
+}
+
+// MissingScopes finds the user's identity with provider that lacks the
+// fewest of scopes, and returns the scopes it lacks. The identity is nil if
+// the user has none with provider.
+func (s *AccountService) MissingScopes(userID, provider string, scopes []string) ([]string, *models.Identity, error) {
+	identities, err := s.identityRepository.ListUserIdentities(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var best *models.Identity
+	missing := scopes
+	for i, identity := range identities {
+		if identity.Provider != provider {
+			continue
+		}
+
+		var lacking []string
+		for _, scope := range scopes {
+			if !slices.Contains(identity.GrantedScopes, scope) {
+				lacking = append(lacking, scope)
+			}
+		}
+		if best == nil || len(lacking) < len(missing) {
+			best, missing = &identities[i], lacking
+		}
+	}
+
+	return missing, best, nil
+}
@@ -0,0 +1,113 @@
+package services
+
+import (
+	"login-with-oauth/internal/models"
+	"login-with-oauth/internal/repository"
+	"login-with-oauth/internal/repository/mock"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccountService(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	userRepo := mock.NewMockUserRepository(ctrl)
+	identityRepo := mock.NewMockIdentityRepository(ctrl)
+	service := NewAccountService(userRepo, identityRepo)
+
+	user := &models.User{ID: "user-1", Email: "test@example.com"}
+	identity := &models.Identity{Provider: "github", Subject: "12345", Email: "test@example.com"}
+
+	t.Run("CompleteLoginEmailInUse", func(t *testing.T) {
+		userRepo.EXPECT().ResolveIdentity(gomock.Any(), gomock.Any()).Return(nil, repository.ErrEmailTaken)
+
+		_, err := service.CompleteLogin(identity)
+
+		assert.ErrorIs(t, err, ErrEmailInUse)
+	})
+
+	t.Run("Connect", func(t *testing.T) {
+		identityRepo.EXPECT().LinkIdentity(gomock.Any()).DoAndReturn(func(linked models.Identity) error {
+			assert.Equal(t, "user-1", linked.UserID)
+			assert.Equal(t, "github", linked.Provider)
+			assert.Equal(t, "12345", linked.Subject)
+			assert.NotEmpty(t, linked.ID)
+			assert.False(t, linked.LinkedAt.IsZero())
+			return nil
+		})
+
+		require.NoError(t, service.Connect(user, identity))
+	})
+
+	t.Run("ConnectIdentityInUse", func(t *testing.T) {
+		identityRepo.EXPECT().LinkIdentity(gomock.Any()).Return(repository.ErrIdentityTaken)
+
+		err := service.Connect(user, identity)
+
+		assert.ErrorIs(t, err, ErrIdentityInUse)
+	})
+
+	t.Run("Disconnect", func(t *testing.T) {
+		identityRepo.EXPECT().UnlinkIdentity("user-1", "identity-2").Return(nil)
+
+		assert.NoError(t, service.Disconnect("user-1", "identity-2"))
+	})
+
+	t.Run("DisconnectLastLoginMethod", func(t *testing.T) {
+		identityRepo.EXPECT().UnlinkIdentity("user-1", "identity-1").Return(repository.ErrLastIdentity)
+
+		err := service.Disconnect("user-1", "identity-1")
+
+		assert.ErrorIs(t, err, ErrLastLoginMethod)
+	})
+
+	t.Run("DisconnectUnknown", func(t *testing.T) {
+		identityRepo.EXPECT().UnlinkIdentity("user-1", "other").Return(repository.ErrNotFound)
+
+		err := service.Disconnect("user-1", "other")
+
+		assert.ErrorIs(t, err, ErrIdentityNotFound)
+	})
+
+	t.Run("MissingScopes", func(t *testing.T) {
+		identityRepo.EXPECT().ListUserIdentities("user-1").Return([]models.Identity{
+			{ID: "identity-1", Provider: "github", GrantedScopes: []string{"user:email"}},
+			{ID: "identity-2", Provider: "github", GrantedScopes: []string{"user:email", "repo"}},
+			{ID: "identity-3", Provider: "google", GrantedScopes: []string{"openid", "gist"}},
+		}, nil)
+
+		missing, best, err := service.MissingScopes("user-1", "github", []string{"repo", "gist"})
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"gist"}, missing)
+		assert.Equal(t, "identity-2", best.ID)
+	})
+
+	t.Run("MissingScopesNoIdentity", func(t *testing.T) {
+		identityRepo.EXPECT().ListUserIdentities("user-1").Return(nil, nil)
+
+		missing, best, err := service.MissingScopes("user-1", "github", []string{"repo"})
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"repo"}, missing)
+		assert.Nil(t, best)
+	})
+
+	t.Run("HasScopes", func(t *testing.T) {
+		identityRepo.EXPECT().ListUserIdentities("user-1").Return([]models.Identity{
+			{Provider: "github", GrantedScopes: []string{"user:email", "repo"}},
+		}, nil).Times(2)
+
+		ok, err := service.HasScopes("user-1", "github", "repo")
+		require.NoError(t, err)
+		assert.True(t, ok)
+
+		ok, err = service.HasScopes("user-1", "github", "repo", "gist")
+		require.NoError(t, err)
+		assert.False(t, ok)
+	})
+}
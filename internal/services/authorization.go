@@ -0,0 +1,350 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"login-with-oauth/internal/logger"
+	"login-with-oauth/internal/models"
+	"login-with-oauth/internal/repository"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidRedirectURI = errors.New("redirect_uri is not registered for the client")
+	ErrUnknownClient      = errors.New("client_id is missing or unknown")
+)
+
+// supportedScopes are the OpenID Connect scopes a client may be allowed to
+// request. Admins can assign configured API scopes on top.
+var supportedScopes = []string{"openid", "profile", "email", "offline_access"}
+
+// AuthorizationError is an error that is reported back to the client by
+// redirecting to its redirect_uri (RFC 6749 section 4.1.2.1)
+type AuthorizationError struct {
+	Code        string
+	Description string
+}
+
+func (e *AuthorizationError) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+// AuthorizationRequest is a checked request to /authorize
+type AuthorizationRequest struct {
+	Client        *models.Client
+	RedirectURI   string
+	Scope         string
+	State         string
+	Nonce         string
+	CodeChallenge string
+	Prompt        string
+	// RedirectURISent is false when the request left redirect_uri out and
+	// the client's only registered one is used
+	RedirectURISent bool
+}
+
+// HasPrompt reports whether the client sent prompt in the prompt parameter
+func (r *AuthorizationRequest) HasPrompt(prompt string) bool {
+	return slices.Contains(strings.Fields(r.Prompt), prompt)
+}
+
+// AuthorizationServerConfig configures the OpenID provider we run for our
+// own clients
+type AuthorizationServerConfig struct {
+	Issuer  string
+	CodeTTL time.Duration
+	// RegistrationEnabled advertises the /register endpoint
+	RegistrationEnabled bool
+	// APIScopes are advertised along with the OpenID Connect scopes
+	APIScopes []APIScope
+}
+
+// AuthorizationServer implements the authorization code flow with PKCE for
+// registered clients, on top of the users logged in with upstream providers
+type AuthorizationServer struct {
+	clients                     ClientStore
+	authorizationCodeRepository repository.AuthorizationCodeRepository
+	tokenService                *TokenService
+	userRepository              repository.UserRepository
+	config                      AuthorizationServerConfig
+	now                         func() time.Time
+}
+
+// NewAuthorizationServer creates a new AuthorizationServer
+func NewAuthorizationServer(clients ClientStore, authorizationCodeRepository repository.AuthorizationCodeRepository, tokenService *TokenService, userRepository repository.UserRepository, config AuthorizationServerConfig) *AuthorizationServer {
+	return &AuthorizationServer{
+		clients:                     clients,
+		authorizationCodeRepository: authorizationCodeRepository,
+		tokenService:                tokenService,
+		userRepository:              userRepository,
+		config:                      config,
+		now:                         time.Now,
+	}
+}
+
+// Clients returns the store the server authenticates clients against
+func (s *AuthorizationServer) Clients() ClientStore {
+	return s.clients
+}
+
+// ParseAuthorizationRequest checks the parameters of an /authorize request.
+// Errors about the client or redirect_uri must be shown to the user, since
+// redirecting would send them to an unverified location; an
+// *AuthorizationError comes with a request that can be redirected to.
+func (s *AuthorizationServer) ParseAuthorizationRequest(values url.Values) (*AuthorizationRequest, error) {
+	client, err := s.clients.GetClient(values.Get("client_id"))
+	if errors.Is(err, ErrClientNotFound) {
+		return nil, ErrUnknownClient
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	redirectURI := values.Get("redirect_uri")
+	if redirectURI == "" && len(client.RedirectURIs) == 1 {
+		redirectURI = client.RedirectURIs[0]
+	}
+	// Redirect URIs are compared exactly, as OpenID Connect requires
+	if !slices.Contains(client.RedirectURIs, redirectURI) {
+		return nil, ErrInvalidRedirectURI
+	}
+
+	req := &AuthorizationRequest{
+		Client:        client,
+		RedirectURI:   redirectURI,
+		State:         values.Get("state"),
+		Nonce:         values.Get("nonce"),
+		CodeChallenge: values.Get("code_challenge"),
+		Prompt:        values.Get("prompt"),
+	}
+	req.RedirectURISent = values.Get("redirect_uri") != ""
+
+	if values.Get("response_type") != "code" {
+		return req, &AuthorizationError{"unsupported_response_type", "Only the code response type is supported."}
+	}
+	if !AllowsGrantType(client, "authorization_code") {
+		return req, &AuthorizationError{"unauthorized_client", "The client may not use the authorization code flow."}
+	}
+
+	scopes := strings.Fields(values.Get("scope"))
+	for _, scope := range scopes {
+		if !slices.Contains(client.Scopes, scope) {
+			return req, &AuthorizationError{"invalid_scope", "The client may not request the scope " + scope + "."}
+		}
+		if scope == "offline_access" && !AllowsGrantType(client, "refresh_token") {
+			return req, &AuthorizationError{"invalid_scope", "The client may not use refresh tokens."}
+		}
+	}
+	req.Scope = strings.Join(scopes, " ")
+
+	if req.CodeChallenge != "" && values.Get("code_challenge_method") != "S256" {
+		return req, &AuthorizationError{"invalid_request", "Only the S256 code_challenge_method is supported."}
+	}
+	if req.CodeChallenge == "" && client.Public {
+		return req, &AuthorizationError{"invalid_request", "Public clients must use PKCE."}
+	}
+
+	return req, nil
+}
+
+// Authorize issues a code for a user who approved req during session and
+// returns the client redirect that delivers it
+func (s *AuthorizationServer) Authorize(req *AuthorizationRequest, user *models.User, session *models.Session) (string, error) {
+	code, err := randomString(32)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate authorization code: %v", err)
+	}
+
+	// /token only has to repeat redirect_uri if /authorize was sent one
+	var redirectURI string
+	if req.RedirectURISent {
+		redirectURI = req.RedirectURI
+	}
+
+	now := s.now()
+	if err := s.authorizationCodeRepository.SaveAuthorizationCode(models.AuthorizationCode{
+		ID:            sha256Hex(code),
+		ClientID:      req.Client.ID,
+		UserID:        user.ID,
+		Provider:      session.Provider,
+		RedirectURI:   redirectURI,
+		Scope:         req.Scope,
+		Nonce:         req.Nonce,
+		CodeChallenge: req.CodeChallenge,
+		AuthTime:      session.CreatedAt,
+		CreatedAt:     now,
+		ExpiresAt:     now.Add(s.config.CodeTTL),
+	}); err != nil {
+		return "", err
+	}
+
+	return s.RedirectURL(req, url.Values{"code": {code}}), nil
+}
+
+// RedirectURL builds the redirect to the client carrying params, the state
+// of req and our issuer (RFC 9207)
+func (s *AuthorizationServer) RedirectURL(req *AuthorizationRequest, params url.Values) string {
+	if req.State != "" {
+		params.Set("state", req.State)
+	}
+	params.Set("iss", s.config.Issuer)
+
+	separator := "?"
+	if strings.Contains(req.RedirectURI, "?") {
+		separator = "&"
+	}
+	return req.RedirectURI + separator + params.Encode()
+}
+
+// ExchangeCode trades an authorization code for tokens. The code is
+// consumed even when the exchange fails, so it cannot be retried.
+func (s *AuthorizationServer) ExchangeCode(client *models.Client, code, redirectURI, verifier string) (*TokenResponse, error) {
+	saved, err := s.authorizationCodeRepository.TakeAuthorizationCode(sha256Hex(code))
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrInvalidGrant
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if saved.ClientID != client.ID || s.now().After(saved.ExpiresAt) {
+		return nil, ErrInvalidGrant
+	}
+	// A redirect_uri sent to /authorize must be repeated exactly (RFC 6749
+	// section 4.1.3); otherwise one sent anyway must at least be registered
+	if saved.RedirectURI != "" && saved.RedirectURI != redirectURI {
+		return nil, ErrInvalidGrant
+	}
+	if saved.RedirectURI == "" && redirectURI != "" && !slices.Contains(client.RedirectURIs, redirectURI) {
+		return nil, ErrInvalidGrant
+	}
+	if saved.CodeChallenge != "" && !verifyCodeChallenge(saved.CodeChallenge, verifier) {
+		return nil, ErrInvalidGrant
+	}
+	if saved.CodeChallenge == "" && verifier != "" {
+		return nil, ErrInvalidGrant
+	}
+
+	user, err := s.userRepository.GetUserByID(saved.UserID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrInvalidGrant
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return s.tokenService.IssueGrant(user, Grant{
+		ClientID: saved.ClientID,
+		Provider: saved.Provider,
+		Scope:    saved.Scope,
+		Nonce:    saved.Nonce,
+		AuthTime: saved.AuthTime,
+	})
+}
+
+// UserInfo returns the claims about the owner of an access token that its
+// scopes allow
+func (s *AuthorizationServer) UserInfo(accessToken string) (*IDTokenClaims, error) {
+	claims, err := s.tokenService.VerifyAccessToken(accessToken)
+	if err != nil {
+		return nil, err
+	}
+	if claims.ClientID != "" && !hasScope(claims.Scope, "openid") {
+		return nil, ErrInvalidToken
+	}
+
+	user, err := s.userRepository.GetUserByID(claims.Subject)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	info := &IDTokenClaims{}
+	info.Subject = user.ID
+	if hasScope(claims.Scope, "email") {
+		info.Email = user.Email
+	}
+	if hasScope(claims.Scope, "profile") {
+		info.PreferredUsername = user.Username
+		info.Picture = user.AvatarURL
+	}
+
+	return info, nil
+}
+
+// Metadata is our discovery document
+func (s *AuthorizationServer) Metadata() *ProviderMetadata {
+	issuer := strings.TrimSuffix(s.config.Issuer, "/")
+
+	var algorithms []string
+	for _, key := range s.tokenService.signer.KeySet().Keys {
+		if key.Algorithm != "" && !slices.Contains(algorithms, key.Algorithm) {
+			algorithms = append(algorithms, key.Algorithm)
+		}
+	}
+
+	scopes := slices.Clone(supportedScopes)
+	for _, scope := range s.config.APIScopes {
+		scopes = append(scopes, scope.Name)
+	}
+
+	metadata := &ProviderMetadata{
+		Issuer:                            s.config.Issuer,
+		AuthorizationEndpoint:             issuer + "/authorize",
+		TokenEndpoint:                     issuer + "/token",
+		UserInfoEndpoint:                  issuer + "/userinfo",
+		JWKSURI:                           issuer + "/.well-known/jwks.json",
+		IntrospectionEndpoint:             issuer + "/introspect",
+		RevocationEndpoint:                issuer + "/revoke",
+		DeviceAuthorizationEndpoint:       issuer + "/device/code",
+		ScopesSupported:                   scopes,
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               supportedGrantTypes,
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  algorithms,
+		CodeChallengeMethodsSupported:     []string{"S256"},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		ClaimsSupported:                   []string{"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "email", "preferred_username", "picture"},
+	}
+	if s.config.RegistrationEnabled {
+		metadata.RegistrationEndpoint = issuer + "/register"
+	}
+
+	return metadata
+}
+
+// RunSweeper deletes expired authorization codes every interval until ctx is done
+func (s *AuthorizationServer) RunSweeper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.authorizationCodeRepository.DeleteExpiredAuthorizationCodes(s.now()); err != nil {
+				logger.Log.Warn("Failed to sweep expired authorization codes: " + err.Error())
+			}
+		}
+	}
+}
+
+// verifyCodeChallenge checks a PKCE verifier against its S256 challenge
+func verifyCodeChallenge(challenge, verifier string) bool {
+	if verifier == "" {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	computed := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
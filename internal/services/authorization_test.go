@@ -0,0 +1,199 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"login-with-oauth/internal/jwt"
+	"login-with-oauth/internal/models"
+	"login-with-oauth/internal/repository"
+	"login-with-oauth/internal/repository/mock"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthorizationServer(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	codeRepo := mock.NewMockAuthorizationCodeRepository(ctrl)
+	user := &models.User{ID: "123", Username: "testuser", Email: "test@example.com"}
+	session := &models.Session{UserID: "123", Provider: "github", CreatedAt: time.Now().Add(-time.Hour)}
+
+	tokens := newTestTokenService(t, ctrl, TokenConfig{})
+
+	clients := testClientStore{
+		"app": {ID: "app", SecretHash: sha256Hex("s3cret"), RedirectURIs: []string{"https://app.example.com/cb"}, Scopes: supportedScopes, GrantTypes: supportedGrantTypes},
+		"spa": {ID: "spa", Public: true, RedirectURIs: []string{"https://spa.example.com/cb", "http://localhost:3000/cb"}, Scopes: []string{"openid"}, GrantTypes: []string{"authorization_code"}},
+	}
+
+	server := NewAuthorizationServer(clients, codeRepo, tokens.TokenService, tokens.userRepo, AuthorizationServerConfig{
+		Issuer:    "https://auth.example.com",
+		CodeTTL:   time.Minute,
+		APIScopes: []APIScope{{"orders:read", "See your orders"}},
+	})
+
+	// codes records the authorization codes the server saves
+	codes := map[string]models.AuthorizationCode{}
+	codeRepo.EXPECT().SaveAuthorizationCode(gomock.Any()).DoAndReturn(func(code models.AuthorizationCode) error {
+		codes[code.ID] = code
+		return nil
+	}).AnyTimes()
+	codeRepo.EXPECT().TakeAuthorizationCode(gomock.Any()).DoAndReturn(func(id string) (*models.AuthorizationCode, error) {
+		code, ok := codes[id]
+		if !ok {
+			return nil, repository.ErrNotFound
+		}
+		delete(codes, id)
+		return &code, nil
+	}).AnyTimes()
+	tokens.userRepo.EXPECT().GetUserByID("123").Return(user, nil).AnyTimes()
+
+	authorize := func(t *testing.T, values url.Values) string {
+		req, err := server.ParseAuthorizationRequest(values)
+		require.NoError(t, err)
+		redirect, err := server.Authorize(req, user, session)
+		require.NoError(t, err)
+
+		location, err := url.Parse(redirect)
+		require.NoError(t, err)
+		assert.Equal(t, values.Get("state"), location.Query().Get("state"))
+		assert.Equal(t, "https://auth.example.com", location.Query().Get("iss"))
+		return location.Query().Get("code")
+	}
+
+	t.Run("ParseErrors", func(t *testing.T) {
+		tests := []struct {
+			name   string
+			values url.Values
+			code   string
+			err    error
+		}{
+			{"UnknownClient", url.Values{"client_id": {"other"}}, "", ErrUnknownClient},
+			{"UnregisteredRedirect", url.Values{"client_id": {"app"}, "redirect_uri": {"https://evil.example.com/cb"}}, "", ErrInvalidRedirectURI},
+			{"AmbiguousRedirect", url.Values{"client_id": {"spa"}, "response_type": {"code"}}, "", ErrInvalidRedirectURI},
+			{"ResponseType", url.Values{"client_id": {"app"}, "response_type": {"token"}}, "unsupported_response_type", nil},
+			{"Scope", url.Values{"client_id": {"app"}, "response_type": {"code"}, "scope": {"openid admin"}}, "invalid_scope", nil},
+			{"PlainPKCE", url.Values{"client_id": {"app"}, "response_type": {"code"}, "code_challenge": {"abc"}}, "invalid_request", nil},
+			{"ScopeNotAllowed", url.Values{"client_id": {"spa"}, "redirect_uri": {"https://spa.example.com/cb"}, "response_type": {"code"}, "scope": {"openid email"}}, "invalid_scope", nil},
+			{"PublicWithoutPKCE", url.Values{"client_id": {"spa"}, "redirect_uri": {"https://spa.example.com/cb"}, "response_type": {"code"}}, "invalid_request", nil},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				_, err := server.ParseAuthorizationRequest(tt.values)
+
+				if tt.err != nil {
+					assert.ErrorIs(t, err, tt.err)
+					return
+				}
+				var authErr *AuthorizationError
+				require.ErrorAs(t, err, &authErr)
+				assert.Equal(t, tt.code, authErr.Code)
+			})
+		}
+	})
+
+	t.Run("CodeFlow", func(t *testing.T) {
+		code := authorize(t, url.Values{
+			"client_id":     {"app"},
+			"response_type": {"code"},
+			"scope":         {"openid email offline_access"},
+			"state":         {"xyz"},
+			"nonce":         {"n-0S6"},
+		})
+		client, err := AuthenticateClient(clients, "app", "s3cret")
+		require.NoError(t, err)
+
+		response, err := server.ExchangeCode(client, code, "https://app.example.com/cb", "")
+		require.NoError(t, err)
+		assert.NotEmpty(t, response.RefreshToken)
+
+		token, err := jwt.Parse(response.IDToken)
+		require.NoError(t, err)
+		require.NoError(t, tokens.signer.KeySet().VerifyWith(token))
+		var claims IDTokenClaims
+		require.NoError(t, token.Claims(&claims))
+		assert.Equal(t, "123", claims.Subject)
+		assert.True(t, claims.Audience.Contains("app"))
+		assert.Equal(t, "n-0S6", claims.Nonce)
+		assert.Equal(t, session.CreatedAt.Unix(), claims.AuthTime)
+		assert.Equal(t, "test@example.com", claims.Email)
+		assert.Empty(t, claims.PreferredUsername, "profile was not requested")
+
+		info, err := server.UserInfo(response.AccessToken)
+		require.NoError(t, err)
+		assert.Equal(t, "123", info.Subject)
+		assert.Equal(t, "test@example.com", info.Email)
+
+		_, err = server.ExchangeCode(client, code, "https://app.example.com/cb", "")
+		assert.ErrorIs(t, err, ErrInvalidGrant, "codes can be used once")
+	})
+
+	t.Run("RedirectURIAtToken", func(t *testing.T) {
+		client, err := AuthenticateClient(clients, "app", "s3cret")
+		require.NoError(t, err)
+		omitted := url.Values{"client_id": {"app"}, "response_type": {"code"}, "scope": {"openid"}}
+		sent := url.Values{"client_id": {"app"}, "redirect_uri": {"https://app.example.com/cb"}, "response_type": {"code"}, "scope": {"openid"}}
+
+		// Left out at /authorize, it may be left out at /token too
+		_, err = server.ExchangeCode(client, authorize(t, omitted), "", "")
+		assert.NoError(t, err)
+
+		_, err = server.ExchangeCode(client, authorize(t, omitted), "https://evil.example.com/cb", "")
+		assert.ErrorIs(t, err, ErrInvalidGrant)
+
+		// Sent to /authorize, it must be repeated
+		_, err = server.ExchangeCode(client, authorize(t, sent), "", "")
+		assert.ErrorIs(t, err, ErrInvalidGrant)
+
+		_, err = server.ExchangeCode(client, authorize(t, sent), "https://app.example.com/cb", "")
+		assert.NoError(t, err)
+	})
+
+	t.Run("PKCE", func(t *testing.T) {
+		verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+		sum := sha256.Sum256([]byte(verifier))
+		values := url.Values{
+			"client_id":             {"spa"},
+			"redirect_uri":          {"https://spa.example.com/cb"},
+			"response_type":         {"code"},
+			"scope":                 {"openid"},
+			"code_challenge":        {base64.RawURLEncoding.EncodeToString(sum[:])},
+			"code_challenge_method": {"S256"},
+		}
+		client, err := AuthenticateClient(clients, "spa", "")
+		require.NoError(t, err)
+
+		_, err = server.ExchangeCode(client, authorize(t, values), "https://spa.example.com/cb", "wrong")
+		assert.ErrorIs(t, err, ErrInvalidGrant)
+
+		response, err := server.ExchangeCode(client, authorize(t, values), "https://spa.example.com/cb", verifier)
+		require.NoError(t, err)
+		assert.NotEmpty(t, response.IDToken)
+		assert.Empty(t, response.RefreshToken, "offline_access was not requested")
+	})
+
+	t.Run("WrongClient", func(t *testing.T) {
+		code := authorize(t, url.Values{"client_id": {"app"}, "response_type": {"code"}, "scope": {"openid"}})
+		spa, err := clients.GetClient("spa")
+		require.NoError(t, err)
+
+		_, err = server.ExchangeCode(spa, code, "https://app.example.com/cb", "")
+
+		assert.ErrorIs(t, err, ErrInvalidGrant)
+	})
+
+	t.Run("Metadata", func(t *testing.T) {
+		metadata := server.Metadata()
+
+		assert.Equal(t, "https://auth.example.com/authorize", metadata.AuthorizationEndpoint)
+		assert.Equal(t, []string{"ES256"}, metadata.IDTokenSigningAlgValuesSupported)
+		assert.Contains(t, metadata.CodeChallengeMethodsSupported, "S256")
+		assert.Equal(t, []string{"openid", "profile", "email", "offline_access", "orders:read"}, metadata.ScopesSupported)
+	})
+}
@@ -0,0 +1,371 @@
+package services
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"login-with-oauth/internal/models"
+	"login-with-oauth/internal/repository"
+	"net/url"
+	"slices"
+	"time"
+)
+
+var (
+	ErrClientNotFound = errors.New("client is not registered")
+	ErrInvalidClient  = errors.New("client authentication failed")
+)
+
+var (
+	// supportedGrantTypes are the grants a client may be allowed to use
+	supportedGrantTypes = []string{"authorization_code", "refresh_token", "client_credentials", DeviceCodeGrantType}
+	// defaultGrantTypes are the grants of clients that do not choose
+	defaultGrantTypes = []string{"authorization_code", "refresh_token"}
+)
+
+// ClientStore looks up the clients registered with us
+type ClientStore interface {
+	GetClient(id string) (*models.Client, error)
+}
+
+// ClientInput is the part of a client its administrators choose
+type ClientInput struct {
+	Name         string   `json:"client_name"`
+	RedirectURIs []string `json:"redirect_uris"`
+	Scopes       []string `json:"scopes"`
+	GrantTypes   []string `json:"grant_types"`
+	Public       bool     `json:"public"`
+	FirstParty   bool     `json:"first_party"`
+}
+
+// APIScope is a scope of our own API, on top of the OpenID Connect scopes,
+// that admins can assign to clients. Description is shown on the consent
+// page.
+type APIScope struct {
+	Name        string `mapstructure:"name"`
+	Description string `mapstructure:"description"`
+}
+
+// ClientValidationError explains why a ClientInput was rejected. Code is
+// the RFC 7591 error code: invalid_redirect_uri or invalid_client_metadata.
+type ClientValidationError struct {
+	Code    string
+	Message string
+}
+
+func (e *ClientValidationError) Error() string {
+	return e.Message
+}
+
+// ClientService manages the registered clients
+type ClientService struct {
+	clientRepository repository.ClientRepository
+	// secretGracePeriod is how long a rotated secret keeps working, so that
+	// clients can be redeployed with the new one
+	secretGracePeriod time.Duration
+	apiScopes         []APIScope
+	now               func() time.Time
+}
+
+// NewClientService creates a new ClientService
+func NewClientService(clientRepository repository.ClientRepository, secretGracePeriod time.Duration) *ClientService {
+	return &ClientService{
+		clientRepository:  clientRepository,
+		secretGracePeriod: secretGracePeriod,
+		now:               time.Now,
+	}
+}
+
+// SetAPIScopes sets the API scopes admins can assign to clients. Names are
+// RFC 6749 scope tokens that must not clash with the OpenID Connect scopes.
+func (s *ClientService) SetAPIScopes(scopes []APIScope) error {
+	for i, scope := range scopes {
+		if !validScopeToken(scope.Name) {
+			return fmt.Errorf("invalid API scope name %q", scope.Name)
+		}
+		if slices.Contains(supportedScopes, scope.Name) || hasAPIScope(scopes[:i], scope.Name) {
+			return fmt.Errorf("API scope %s is already defined", scope.Name)
+		}
+	}
+	s.apiScopes = scopes
+	return nil
+}
+
+// GetClient implements ClientStore
+func (s *ClientService) GetClient(id string) (*models.Client, error) {
+	client, err := s.clientRepository.GetClient(id)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrClientNotFound
+	}
+	return client, err
+}
+
+// ListClients returns every registered client
+func (s *ClientService) ListClients() ([]models.Client, error) {
+	return s.clientRepository.ListClients()
+}
+
+// CreateClient registers a client. The secret of a confidential client is
+// returned once; only its hash is stored.
+func (s *ClientService) CreateClient(input ClientInput) (*models.Client, string, error) {
+	return s.createClient(input, "")
+}
+
+// RegisterClient creates a client that manages its own registration with
+// the returned registration access token (RFC 7592)
+func (s *ClientService) RegisterClient(input ClientInput) (*models.Client, string, string, error) {
+	registrationToken, err := randomString(32)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("failed to generate registration token: %v", err)
+	}
+
+	client, secret, err := s.createClient(input, sha256Hex(registrationToken))
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	return client, secret, registrationToken, nil
+}
+
+func (s *ClientService) createClient(input ClientInput, registrationTokenHash string) (*models.Client, string, error) {
+	if err := validateClientInput(&input, s.apiScopes); err != nil {
+		return nil, "", err
+	}
+
+	id, err := randomString(16)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to generate client id: %v", err)
+	}
+
+	now := s.now()
+	client := models.Client{ID: id, RegistrationTokenHash: registrationTokenHash, CreatedAt: now}
+	applyClientInput(&client, input, now)
+
+	secret := ""
+	if !client.Public {
+		if secret, err = randomString(32); err != nil {
+			return nil, "", fmt.Errorf("failed to generate client secret: %v", err)
+		}
+		client.SecretHash = sha256Hex(secret)
+	}
+
+	if err := s.clientRepository.CreateClient(client); err != nil {
+		return nil, "", err
+	}
+
+	return &client, secret, nil
+}
+
+// UpdateClient replaces the settings of a client. A public client turned
+// confidential gets a secret, which is returned; otherwise the secret is
+// empty.
+func (s *ClientService) UpdateClient(id string, input ClientInput) (*models.Client, string, error) {
+	if err := validateClientInput(&input, s.apiScopes); err != nil {
+		return nil, "", err
+	}
+
+	client, err := s.GetClient(id)
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := s.now()
+	applyClientInput(client, input, now)
+	if client.Public {
+		client.SecretHash = ""
+		client.PreviousSecretHash = ""
+		client.PreviousSecretExpiresAt = nil
+	}
+
+	secret := ""
+	if !client.Public && client.SecretHash == "" {
+		if secret, err = randomString(32); err != nil {
+			return nil, "", fmt.Errorf("failed to generate client secret: %v", err)
+		}
+		client.SecretHash = sha256Hex(secret)
+	}
+
+	if err := s.clientRepository.UpdateClient(*client); err != nil {
+		return nil, "", err
+	}
+
+	return client, secret, nil
+}
+
+// DeleteClient removes a client
+func (s *ClientService) DeleteClient(id string) error {
+	err := s.clientRepository.DeleteClient(id)
+	if errors.Is(err, repository.ErrNotFound) {
+		return ErrClientNotFound
+	}
+	return err
+}
+
+// RotateSecret issues a new secret for a confidential client. The previous
+// secret keeps working for the grace period.
+func (s *ClientService) RotateSecret(id string) (string, error) {
+	client, err := s.GetClient(id)
+	if err != nil {
+		return "", err
+	}
+	if client.Public {
+		return "", &ClientValidationError{"invalid_client_metadata", "public clients have no secret"}
+	}
+
+	secret, err := randomString(32)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate client secret: %v", err)
+	}
+
+	now := s.now()
+	client.PreviousSecretHash = ""
+	client.PreviousSecretExpiresAt = nil
+	if client.SecretHash != "" && s.secretGracePeriod > 0 {
+		expiresAt := now.Add(s.secretGracePeriod)
+		client.PreviousSecretHash = client.SecretHash
+		client.PreviousSecretExpiresAt = &expiresAt
+	}
+	client.SecretHash = sha256Hex(secret)
+	client.UpdatedAt = now
+
+	if err := s.clientRepository.UpdateClient(*client); err != nil {
+		return "", err
+	}
+
+	return secret, nil
+}
+
+// validateClientInput checks input and fills in the default scopes and
+// grant types. Clients get the OpenID Connect scopes by default; API scopes
+// must be assigned by name.
+func validateClientInput(input *ClientInput, apiScopes []APIScope) error {
+	if input.Name == "" {
+		return &ClientValidationError{"invalid_client_metadata", "client_name is required"}
+	}
+	if len(input.GrantTypes) == 0 {
+		input.GrantTypes = defaultGrantTypes
+	}
+	for _, grantType := range input.GrantTypes {
+		if !slices.Contains(supportedGrantTypes, grantType) {
+			return &ClientValidationError{"invalid_client_metadata", "unknown grant type " + grantType}
+		}
+	}
+	if input.Public && slices.Contains(input.GrantTypes, "client_credentials") {
+		return &ClientValidationError{"invalid_client_metadata", "public clients cannot use the client_credentials grant"}
+	}
+
+	// Machine clients using only client_credentials never redirect users
+	if len(input.RedirectURIs) == 0 && slices.Contains(input.GrantTypes, "authorization_code") {
+		return &ClientValidationError{"invalid_redirect_uri", "at least one redirect URI is required"}
+	}
+	for _, redirectURI := range input.RedirectURIs {
+		if err := validateRedirectURI(redirectURI); err != nil {
+			return err
+		}
+	}
+
+	if len(input.Scopes) == 0 {
+		input.Scopes = supportedScopes
+	}
+	for _, scope := range input.Scopes {
+		if !slices.Contains(supportedScopes, scope) && !hasAPIScope(apiScopes, scope) {
+			return &ClientValidationError{"invalid_client_metadata", "unknown scope " + scope}
+		}
+	}
+
+	return nil
+}
+
+// validateRedirectURI accepts absolute https URIs, plain http only for
+// loopback addresses, and never fragments (RFC 6749 section 3.1.2)
+func validateRedirectURI(redirectURI string) error {
+	u, err := url.Parse(redirectURI)
+	if err != nil || !u.IsAbs() || u.Host == "" {
+		return &ClientValidationError{"invalid_redirect_uri", "redirect URI " + redirectURI + " is not an absolute URL"}
+	}
+	if u.Fragment != "" || u.RawFragment != "" || u.User != nil {
+		return &ClientValidationError{"invalid_redirect_uri", "redirect URI " + redirectURI + " must not have a fragment or credentials"}
+	}
+
+	host := u.Hostname()
+	loopback := host == "localhost" || host == "127.0.0.1" || host == "::1"
+	if u.Scheme != "https" && !(u.Scheme == "http" && loopback) {
+		return &ClientValidationError{"invalid_redirect_uri", "redirect URI " + redirectURI + " must use https"}
+	}
+
+	return nil
+}
+
+// hasAPIScope reports whether scopes has one called name
+func hasAPIScope(scopes []APIScope, name string) bool {
+	return slices.ContainsFunc(scopes, func(scope APIScope) bool { return scope.Name == name })
+}
+
+// isAPIScope reports whether scope, one assigned to a client, is an API
+// scope rather than an OpenID Connect one
+func isAPIScope(scope string) bool {
+	return !slices.Contains(supportedScopes, scope)
+}
+
+// validScopeToken checks a scope name against RFC 6749 section 3.3
+func validScopeToken(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if c < 0x21 || c > 0x7e || c == '"' || c == '\\' {
+			return false
+		}
+	}
+	return true
+}
+
+func applyClientInput(client *models.Client, input ClientInput, now time.Time) {
+	client.Name = input.Name
+	client.RedirectURIs = input.RedirectURIs
+	client.Scopes = input.Scopes
+	client.GrantTypes = input.GrantTypes
+	client.Public = input.Public
+	client.FirstParty = input.FirstParty
+	client.UpdatedAt = now
+}
+
+// AllowsGrantType reports whether client may use grantType
+func AllowsGrantType(client *models.Client, grantType string) bool {
+	return slices.Contains(client.GrantTypes, grantType)
+}
+
+// AuthenticateClient checks a client's credentials. Public clients have no
+// secret and must not send one. A rotated secret is accepted until its
+// grace period ends.
+func AuthenticateClient(clients ClientStore, id, secret string) (*models.Client, error) {
+	client, err := clients.GetClient(id)
+	if errors.Is(err, ErrClientNotFound) {
+		return nil, ErrInvalidClient
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if client.Public {
+		if secret != "" {
+			return nil, ErrInvalidClient
+		}
+		return client, nil
+	}
+
+	if secret == "" {
+		return nil, ErrInvalidClient
+	}
+	hash := []byte(sha256Hex(secret))
+	if subtle.ConstantTimeCompare(hash, []byte(client.SecretHash)) == 1 {
+		return client, nil
+	}
+	if client.PreviousSecretHash != "" && client.PreviousSecretExpiresAt != nil &&
+		time.Now().Before(*client.PreviousSecretExpiresAt) &&
+		subtle.ConstantTimeCompare(hash, []byte(client.PreviousSecretHash)) == 1 {
+		return client, nil
+	}
+
+	return nil, ErrInvalidClient
+}
@@ -0,0 +1,167 @@
+package services
+
+import (
+	"login-with-oauth/internal/models"
+	"login-with-oauth/internal/repository"
+	"login-with-oauth/internal/repository/mock"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientService(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	clientRepo := mock.NewMockClientRepository(ctrl)
+	service := NewClientService(clientRepo, time.Hour)
+	require.NoError(t, service.SetAPIScopes([]APIScope{{"orders:read", "See your orders"}}))
+
+	// stored records the clients the service writes
+	stored := map[string]models.Client{}
+	clientRepo.EXPECT().CreateClient(gomock.Any()).DoAndReturn(func(client models.Client) error {
+		stored[client.ID] = client
+		return nil
+	}).AnyTimes()
+	clientRepo.EXPECT().UpdateClient(gomock.Any()).DoAndReturn(func(client models.Client) error {
+		stored[client.ID] = client
+		return nil
+	}).AnyTimes()
+	clientRepo.EXPECT().GetClient(gomock.Any()).DoAndReturn(func(id string) (*models.Client, error) {
+		client, ok := stored[id]
+		if !ok {
+			return nil, repository.ErrNotFound
+		}
+		return &client, nil
+	}).AnyTimes()
+
+	t.Run("Create", func(t *testing.T) {
+		client, secret, err := service.CreateClient(ClientInput{Name: "App", RedirectURIs: []string{"https://app.example.com/cb"}})
+		require.NoError(t, err)
+
+		assert.NotEmpty(t, client.ID)
+		assert.NotEmpty(t, secret)
+		assert.Equal(t, sha256Hex(secret), stored[client.ID].SecretHash)
+		assert.Equal(t, supportedScopes, client.Scopes)
+		assert.Equal(t, defaultGrantTypes, client.GrantTypes)
+
+		authenticated, err := AuthenticateClient(service, client.ID, secret)
+		require.NoError(t, err)
+		assert.Equal(t, client.ID, authenticated.ID)
+
+		_, err = AuthenticateClient(service, client.ID, "wrong")
+		assert.ErrorIs(t, err, ErrInvalidClient)
+	})
+
+	t.Run("Public", func(t *testing.T) {
+		client, secret, err := service.CreateClient(ClientInput{Name: "SPA", RedirectURIs: []string{"http://localhost:3000/cb"}, Public: true})
+		require.NoError(t, err)
+		assert.Empty(t, secret)
+
+		_, err = AuthenticateClient(service, client.ID, "")
+		assert.NoError(t, err)
+
+		_, err = AuthenticateClient(service, client.ID, "anything")
+		assert.ErrorIs(t, err, ErrInvalidClient)
+
+		_, err = service.RotateSecret(client.ID)
+		var validationErr *ClientValidationError
+		assert.ErrorAs(t, err, &validationErr)
+	})
+
+	t.Run("ClientCredentials", func(t *testing.T) {
+		client, secret, err := service.CreateClient(ClientInput{Name: "Batch job", GrantTypes: []string{"client_credentials"}})
+		require.NoError(t, err)
+
+		assert.NotEmpty(t, secret)
+		assert.Empty(t, client.RedirectURIs)
+		assert.True(t, AllowsGrantType(client, "client_credentials"))
+		assert.False(t, AllowsGrantType(client, "authorization_code"))
+	})
+
+	t.Run("APIScopes", func(t *testing.T) {
+		client, _, err := service.CreateClient(ClientInput{Name: "Batch job", GrantTypes: []string{"client_credentials"}, Scopes: []string{"orders:read"}})
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"orders:read"}, client.Scopes)
+	})
+
+	t.Run("InvalidAPIScopes", func(t *testing.T) {
+		tests := []struct {
+			name   string
+			scopes []APIScope
+		}{
+			{"OpenIDScope", []APIScope{{"email", "Your email"}}},
+			{"Duplicate", []APIScope{{"orders:read", ""}, {"orders:read", ""}}},
+			{"Space", []APIScope{{"orders read", ""}}},
+			{"Empty", []APIScope{{"", "Nothing"}}},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				assert.Error(t, NewClientService(clientRepo, time.Hour).SetAPIScopes(tt.scopes))
+			})
+		}
+	})
+
+	t.Run("Validation", func(t *testing.T) {
+		tests := []struct {
+			name  string
+			input ClientInput
+		}{
+			{"NoName", ClientInput{RedirectURIs: []string{"https://app.example.com/cb"}}},
+			{"NoRedirectURIs", ClientInput{Name: "App"}},
+			{"RelativeRedirectURI", ClientInput{Name: "App", RedirectURIs: []string{"/cb"}}},
+			{"PlainHTTP", ClientInput{Name: "App", RedirectURIs: []string{"http://app.example.com/cb"}}},
+			{"Fragment", ClientInput{Name: "App", RedirectURIs: []string{"https://app.example.com/cb#x"}}},
+			{"UnknownScope", ClientInput{Name: "App", RedirectURIs: []string{"https://app.example.com/cb"}, Scopes: []string{"admin"}}},
+			{"UnknownGrantType", ClientInput{Name: "App", RedirectURIs: []string{"https://app.example.com/cb"}, GrantTypes: []string{"password"}}},
+			{"PublicClientCredentials", ClientInput{Name: "Job", Public: true, GrantTypes: []string{"client_credentials"}}},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				_, _, err := service.CreateClient(tt.input)
+
+				var validationErr *ClientValidationError
+				assert.ErrorAs(t, err, &validationErr)
+			})
+		}
+	})
+
+	t.Run("RotateSecret", func(t *testing.T) {
+		client, oldSecret, err := service.CreateClient(ClientInput{Name: "App", RedirectURIs: []string{"https://app.example.com/cb"}})
+		require.NoError(t, err)
+
+		newSecret, err := service.RotateSecret(client.ID)
+		require.NoError(t, err)
+
+		_, err = AuthenticateClient(service, client.ID, newSecret)
+		assert.NoError(t, err)
+		_, err = AuthenticateClient(service, client.ID, oldSecret)
+		assert.NoError(t, err, "the old secret works during the grace period")
+
+		rotated := stored[client.ID]
+		expired := time.Now().Add(-time.Minute)
+		rotated.PreviousSecretExpiresAt = &expired
+		stored[client.ID] = rotated
+
+		_, err = AuthenticateClient(service, client.ID, oldSecret)
+		assert.ErrorIs(t, err, ErrInvalidClient)
+	})
+
+	t.Run("Unknown", func(t *testing.T) {
+		clientRepo.EXPECT().DeleteClient("missing").Return(repository.ErrNotFound)
+
+		_, err := service.GetClient("missing")
+		assert.ErrorIs(t, err, ErrClientNotFound)
+
+		_, err = AuthenticateClient(service, "missing", "secret")
+		assert.ErrorIs(t, err, ErrInvalidClient)
+
+		assert.ErrorIs(t, service.DeleteClient("missing"), ErrClientNotFound)
+	})
+}
@@ -0,0 +1,177 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"login-with-oauth/internal/models"
+	"login-with-oauth/internal/repository"
+	"slices"
+	"strings"
+	"time"
+)
+
+var ErrConsentNotFound = errors.New("the application has no access")
+
+// scopeDescriptions explain scopes to users on the consent page
+var scopeDescriptions = map[string]string{
+	"openid":         "Sign you in with your account",
+	"profile":        "See your username and picture",
+	"email":          "See your email address",
+	"offline_access": "Keep access while you are not using it",
+}
+
+// ScopeDescription is a scope and what it allows, as shown to users
+type ScopeDescription struct {
+	Name        string
+	Description string
+}
+
+// DescribeScopes explains each scope of a space-separated scope string
+func (s *ConsentService) DescribeScopes(scope string) []ScopeDescription {
+	var descriptions []ScopeDescription
+	for _, name := range strings.Fields(scope) {
+		description, ok := scopeDescriptions[name]
+		if !ok {
+			description = s.apiScopeDescriptions[name]
+		}
+		if description == "" {
+			description = name
+		}
+		descriptions = append(descriptions, ScopeDescription{name, description})
+	}
+	return descriptions
+}
+
+// Application is a client a user granted access to
+type Application struct {
+	Client    *models.Client
+	Scopes    []ScopeDescription
+	GrantedAt time.Time
+}
+
+// ConsentService remembers which scopes users granted to which clients
+type ConsentService struct {
+	consentRepository      repository.ConsentRepository
+	refreshTokenRepository repository.RefreshTokenRepository
+	accessTokenRepository  repository.AccessTokenRepository
+	clients                ClientStore
+	apiScopeDescriptions   map[string]string
+	now                    func() time.Time
+}
+
+// NewConsentService creates a new ConsentService
+func NewConsentService(consentRepository repository.ConsentRepository, refreshTokenRepository repository.RefreshTokenRepository, accessTokenRepository repository.AccessTokenRepository, clients ClientStore) *ConsentService {
+	return &ConsentService{
+		consentRepository:      consentRepository,
+		refreshTokenRepository: refreshTokenRepository,
+		accessTokenRepository:  accessTokenRepository,
+		clients:                clients,
+		now:                    time.Now,
+	}
+}
+
+// SetAPIScopes sets the API scopes described on the consent page
+func (s *ConsentService) SetAPIScopes(scopes []APIScope) {
+	s.apiScopeDescriptions = make(map[string]string, len(scopes))
+	for _, scope := range scopes {
+		s.apiScopeDescriptions[scope.Name] = scope.Description
+	}
+}
+
+// Required reports whether the user must approve req. First-party clients
+// and scopes the user granted before are not asked about, unless the
+// client sent prompt=consent.
+func (s *ConsentService) Required(userID string, req *AuthorizationRequest) (bool, error) {
+	if req.HasPrompt("consent") {
+		return true, nil
+	}
+	if req.Client.FirstParty {
+		return false, nil
+	}
+
+	consent, err := s.consentRepository.GetConsent(userID, req.Client.ID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	granted := strings.Fields(consent.Scope)
+	for _, scope := range strings.Fields(req.Scope) {
+		if !slices.Contains(granted, scope) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Grant remembers that the user approved scope for a client, on top of
+// what they approved before
+func (s *ConsentService) Grant(userID, clientID, scope string) error {
+	now := s.now()
+	consent := models.Consent{UserID: userID, ClientID: clientID, CreatedAt: now, UpdatedAt: now}
+
+	scopes := strings.Fields(scope)
+	existing, err := s.consentRepository.GetConsent(userID, clientID)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		return err
+	}
+	if existing != nil {
+		consent.CreatedAt = existing.CreatedAt
+		for _, granted := range strings.Fields(existing.Scope) {
+			if !slices.Contains(scopes, granted) {
+				scopes = append(scopes, granted)
+			}
+		}
+	}
+	consent.Scope = strings.Join(scopes, " ")
+
+	return s.consentRepository.SaveConsent(consent)
+}
+
+// ListApplications returns the clients the user granted access to
+func (s *ConsentService) ListApplications(userID string) ([]Application, error) {
+	consents, err := s.consentRepository.ListUserConsents(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var applications []Application
+	for _, consent := range consents {
+		client, err := s.clients.GetClient(consent.ClientID)
+		if errors.Is(err, ErrClientNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		applications = append(applications, Application{
+			Client:    client,
+			Scopes:    s.DescribeScopes(consent.Scope),
+			GrantedAt: consent.UpdatedAt,
+		})
+	}
+
+	return applications, nil
+}
+
+// Revoke forgets the user's consent for a client and revokes the refresh
+// and opaque access tokens the client holds, so it has to ask again
+func (s *ConsentService) Revoke(userID, clientID string) error {
+	err := s.consentRepository.DeleteConsent(userID, clientID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return ErrConsentNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.refreshTokenRepository.RevokeClientRefreshTokens(userID, clientID, s.now()); err != nil {
+		return fmt.Errorf("failed to revoke refresh tokens of client %s: %v", clientID, err)
+	}
+	if _, err := s.accessTokenRepository.RevokeClientAccessTokens(userID, clientID, s.now()); err != nil {
+		return fmt.Errorf("failed to revoke access tokens of client %s: %v", clientID, err)
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package services
+
+import (
+	"login-with-oauth/internal/models"
+	"login-with-oauth/internal/repository"
+	"login-with-oauth/internal/repository/mock"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConsentService(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	consentRepo := mock.NewMockConsentRepository(ctrl)
+	refreshRepo := mock.NewMockRefreshTokenRepository(ctrl)
+	accessRepo := mock.NewMockAccessTokenRepository(ctrl)
+	clients := testClientStore{
+		"app":      {ID: "app", Name: "App"},
+		"internal": {ID: "internal", Name: "Internal", FirstParty: true},
+	}
+	service := NewConsentService(consentRepo, refreshRepo, accessRepo, clients)
+	service.SetAPIScopes([]APIScope{{"orders:read", "See your orders"}})
+
+	request := func(clientID, scope, prompt string) *AuthorizationRequest {
+		client, err := clients.GetClient(clientID)
+		require.NoError(t, err)
+		return &AuthorizationRequest{Client: client, Scope: scope, Prompt: prompt}
+	}
+
+	t.Run("Required", func(t *testing.T) {
+		granted := &models.Consent{UserID: "123", ClientID: "app", Scope: "openid email"}
+
+		tests := []struct {
+			name     string
+			req      *AuthorizationRequest
+			lookup   bool
+			consent  *models.Consent
+			expected bool
+		}{
+			{"NoConsent", request("app", "openid", ""), true, nil, true},
+			{"Granted", request("app", "openid", ""), true, granted, false},
+			{"NewScope", request("app", "openid profile", ""), true, granted, true},
+			{"PromptConsent", request("app", "openid", "login consent"), false, nil, true},
+			{"FirstParty", request("internal", "openid", ""), false, nil, false},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				if tt.consent != nil {
+					consentRepo.EXPECT().GetConsent("123", tt.req.Client.ID).Return(tt.consent, nil)
+				} else if tt.lookup {
+					consentRepo.EXPECT().GetConsent("123", tt.req.Client.ID).Return(nil, repository.ErrNotFound)
+				}
+
+				required, err := service.Required("123", tt.req)
+
+				require.NoError(t, err)
+				assert.Equal(t, tt.expected, required)
+			})
+		}
+	})
+
+	t.Run("GrantMergesScopes", func(t *testing.T) {
+		createdAt := time.Now().Add(-time.Hour)
+		consentRepo.EXPECT().GetConsent("123", "app").Return(&models.Consent{Scope: "openid email", CreatedAt: createdAt}, nil)
+		consentRepo.EXPECT().SaveConsent(gomock.Any()).DoAndReturn(func(consent models.Consent) error {
+			assert.Equal(t, "openid profile email", consent.Scope)
+			assert.Equal(t, createdAt, consent.CreatedAt)
+			return nil
+		})
+
+		assert.NoError(t, service.Grant("123", "app", "openid profile"))
+	})
+
+	t.Run("ListApplications", func(t *testing.T) {
+		consentRepo.EXPECT().ListUserConsents("123").Return([]models.Consent{
+			{ClientID: "app", Scope: "openid email"},
+			{ClientID: "deleted", Scope: "openid"},
+		}, nil)
+
+		applications, err := service.ListApplications("123")
+
+		require.NoError(t, err)
+		require.Len(t, applications, 1)
+		assert.Equal(t, "App", applications[0].Client.Name)
+		assert.Equal(t, "See your email address", applications[0].Scopes[1].Description)
+	})
+
+	t.Run("DescribeScopes", func(t *testing.T) {
+		assert.Equal(t, []ScopeDescription{
+			{"email", "See your email address"},
+			{"orders:read", "See your orders"},
+			{"orders:write", "orders:write"},
+		}, service.DescribeScopes("email orders:read orders:write"))
+	})
+
+	t.Run("Revoke", func(t *testing.T) {
+		consentRepo.EXPECT().DeleteConsent("123", "app").Return(nil)
+		refreshRepo.EXPECT().RevokeClientRefreshTokens("123", "app", gomock.Any()).Return(int64(2), nil)
+		accessRepo.EXPECT().RevokeClientAccessTokens("123", "app", gomock.Any()).Return(int64(1), nil)
+
+		assert.NoError(t, service.Revoke("123", "app"))
+	})
+
+	t.Run("RevokeUnknown", func(t *testing.T) {
+		consentRepo.EXPECT().DeleteConsent("123", "other").Return(repository.ErrNotFound)
+
+		assert.ErrorIs(t, service.Revoke("123", "other"), ErrConsentNotFound)
+	})
+}
@@ -0,0 +1,289 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"login-with-oauth/internal/logger"
+	"login-with-oauth/internal/models"
+	"login-with-oauth/internal/repository"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// DeviceCodeGrantType is the grant_type devices poll the token endpoint with
+const DeviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+// userCodeAlphabet has no vowels, so that user codes do not spell words,
+// and no digits, which are easily confused with letters (RFC 8628 section 6.1)
+const userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+
+// slowDownIncrement is added to a device's interval each time it polls too
+// early (RFC 8628 section 3.5)
+const slowDownIncrement = 5
+
+var (
+	ErrAuthorizationPending = errors.New("the user has not answered the device authorization yet")
+	ErrSlowDown             = errors.New("the device is polling too often")
+	ErrExpiredToken         = errors.New("the device code has expired")
+	ErrAccessDenied         = errors.New("the user denied the device authorization")
+	ErrUnknownUserCode      = errors.New("user code is unknown, expired or already used")
+)
+
+// DeviceAuthorizationConfig configures the device authorization grant
+type DeviceAuthorizationConfig struct {
+	// VerificationURI is the page where users enter their code
+	VerificationURI string
+	CodeTTL         time.Duration
+	// Interval is how long devices must wait between polls
+	Interval time.Duration
+}
+
+// DeviceAuthorizationResponse is the response of RFC 8628 section 3.2
+type DeviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int64  `json:"interval"`
+}
+
+// DeviceService implements the device authorization grant (RFC 8628) for
+// clients that cannot open a browser, such as CLIs on servers. Users
+// approve them on another device where they are logged in.
+type DeviceService struct {
+	clients              ClientStore
+	deviceCodeRepository repository.DeviceCodeRepository
+	tokenService         *TokenService
+	userRepository       repository.UserRepository
+	config               DeviceAuthorizationConfig
+	now                  func() time.Time
+}
+
+// NewDeviceService creates a new DeviceService
+func NewDeviceService(clients ClientStore, deviceCodeRepository repository.DeviceCodeRepository, tokenService *TokenService, userRepository repository.UserRepository, config DeviceAuthorizationConfig) *DeviceService {
+	return &DeviceService{
+		clients:              clients,
+		deviceCodeRepository: deviceCodeRepository,
+		tokenService:         tokenService,
+		userRepository:       userRepository,
+		config:               config,
+		now:                  time.Now,
+	}
+}
+
+// Start begins a device authorization for client. It returns
+// ErrInvalidScope if the client may not request scope.
+func (s *DeviceService) Start(client *models.Client, scope string) (*DeviceAuthorizationResponse, error) {
+	scopes := strings.Fields(scope)
+	for _, requested := range scopes {
+		if !slices.Contains(client.Scopes, requested) {
+			return nil, ErrInvalidScope
+		}
+		if requested == "offline_access" && !AllowsGrantType(client, "refresh_token") {
+			return nil, ErrInvalidScope
+		}
+	}
+
+	deviceCode, err := randomString(32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate device code: %v", err)
+	}
+	userCode, err := generateUserCode()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate user code: %v", err)
+	}
+
+	now := s.now()
+	interval := int(s.config.Interval.Seconds())
+	if err := s.deviceCodeRepository.CreateDeviceCode(models.DeviceCode{
+		ID:        sha256Hex(deviceCode),
+		UserCode:  userCode,
+		ClientID:  client.ID,
+		Scope:     strings.Join(scopes, " "),
+		Status:    models.DeviceCodePending,
+		Interval:  interval,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.config.CodeTTL),
+	}); err != nil {
+		return nil, err
+	}
+
+	return &DeviceAuthorizationResponse{
+		DeviceCode:              deviceCode,
+		UserCode:                userCode,
+		VerificationURI:         s.config.VerificationURI,
+		VerificationURIComplete: s.config.VerificationURI + "?user_code=" + url.QueryEscape(userCode),
+		ExpiresIn:               int64(s.config.CodeTTL.Seconds()),
+		Interval:                int64(interval),
+	}, nil
+}
+
+// Lookup finds the pending device authorization a user typed the code of,
+// and the client that asked for it
+func (s *DeviceService) Lookup(userCode string) (*models.DeviceCode, *models.Client, error) {
+	code, err := s.deviceCodeRepository.GetDeviceCodeByUserCode(normalizeUserCode(userCode))
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, nil, ErrUnknownUserCode
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if code.Status != models.DeviceCodePending || !s.now().Before(code.ExpiresAt) {
+		return nil, nil, ErrUnknownUserCode
+	}
+
+	client, err := s.clients.GetClient(code.ClientID)
+	if errors.Is(err, ErrClientNotFound) {
+		return nil, nil, ErrUnknownUserCode
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return code, client, nil
+}
+
+// Approve lets the device of code get tokens for user, who is logged in
+// with session
+func (s *DeviceService) Approve(code *models.DeviceCode, user *models.User, session *models.Session) error {
+	return s.decide(code, models.DeviceCodeApproved, user.ID, session.Provider, session.CreatedAt)
+}
+
+// Deny refuses the device of code, which learns so on its next poll
+func (s *DeviceService) Deny(code *models.DeviceCode, user *models.User) error {
+	return s.decide(code, models.DeviceCodeDenied, user.ID, "", s.now())
+}
+
+func (s *DeviceService) decide(code *models.DeviceCode, status, userID, provider string, authTime time.Time) error {
+	err := s.deviceCodeRepository.DecideDeviceCode(code.ID, status, userID, provider, authTime)
+	if errors.Is(err, repository.ErrNotFound) {
+		return ErrUnknownUserCode
+	}
+	return err
+}
+
+// Poll answers a device polling the token endpoint with deviceCode. Until
+// the user answers it returns ErrAuthorizationPending, or ErrSlowDown if the
+// device did not wait for its interval, which then grows. Approved codes are
+// redeemed once.
+func (s *DeviceService) Poll(client *models.Client, deviceCode string) (*TokenResponse, error) {
+	code, err := s.deviceCodeRepository.GetDeviceCode(sha256Hex(deviceCode))
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrInvalidGrant
+	}
+	if err != nil {
+		return nil, err
+	}
+	if code.ClientID != client.ID {
+		return nil, ErrInvalidGrant
+	}
+
+	now := s.now()
+	if !now.Before(code.ExpiresAt) {
+		return nil, ErrExpiredToken
+	}
+
+	switch code.Status {
+	case models.DeviceCodeDenied:
+		return nil, ErrAccessDenied
+	case models.DeviceCodeApproved:
+		return s.redeem(code)
+	}
+
+	interval := code.Interval
+	tooEarly := code.LastPolledAt != nil && now.Sub(*code.LastPolledAt) < time.Duration(interval)*time.Second
+	if tooEarly {
+		interval += slowDownIncrement
+	}
+	if err := s.deviceCodeRepository.UpdateDeviceCodePoll(code.ID, now, interval); err != nil && !errors.Is(err, repository.ErrNotFound) {
+		return nil, err
+	}
+	if tooEarly {
+		return nil, ErrSlowDown
+	}
+	return nil, ErrAuthorizationPending
+}
+
+// redeem issues the tokens of an approved code. The code is deleted first,
+// so that concurrent polls cannot both get tokens.
+func (s *DeviceService) redeem(code *models.DeviceCode) (*TokenResponse, error) {
+	err := s.deviceCodeRepository.DeleteDeviceCode(code.ID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrInvalidGrant
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.userRepository.GetUserByID(code.UserID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrInvalidGrant
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	grant := Grant{ClientID: code.ClientID, Provider: code.Provider, Scope: code.Scope}
+	if code.AuthTime != nil {
+		grant.AuthTime = *code.AuthTime
+	}
+	return s.tokenService.IssueGrant(user, grant)
+}
+
+// RunSweeper deletes expired device codes every interval until ctx is done
+func (s *DeviceService) RunSweeper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.deviceCodeRepository.DeleteExpiredDeviceCodes(s.now()); err != nil {
+				logger.Log.Warn("Failed to sweep expired device codes: " + err.Error())
+			}
+		}
+	}
+}
+
+// generateUserCode returns a code such as WDJB-MJHT. Its 8 letters out of
+// 20 give about 34 bits of entropy, plenty for a code that expires in
+// minutes and can only be entered by logged-in users.
+func generateUserCode() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	var code strings.Builder
+	for i, v := range b {
+		if i == 4 {
+			code.WriteByte('-')
+		}
+		// 256 is not a multiple of 20, so the first letters are very slightly
+		// more likely; that does not matter for a short-lived code
+		code.WriteByte(userCodeAlphabet[int(v)%len(userCodeAlphabet)])
+	}
+	return code.String(), nil
+}
+
+// normalizeUserCode accepts user codes typed in lower case, without the
+// dash or with spaces
+func normalizeUserCode(userCode string) string {
+	var letters []rune
+	for _, r := range strings.ToUpper(userCode) {
+		if strings.ContainsRune(userCodeAlphabet, r) {
+			letters = append(letters, r)
+		}
+	}
+	if len(letters) != 8 {
+		return string(letters)
+	}
+	return string(letters[:4]) + "-" + string(letters[4:])
+}
@@ -0,0 +1,189 @@
+package services
+
+import (
+	"login-with-oauth/internal/models"
+	"login-with-oauth/internal/repository"
+	"login-with-oauth/internal/repository/mock"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeviceService(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	deviceRepo := mock.NewMockDeviceCodeRepository(ctrl)
+	tokens := newTestTokenService(t, ctrl, TokenConfig{})
+	user := &models.User{ID: "123", Username: "testuser", Email: "test@example.com"}
+	session := &models.Session{UserID: "123", Provider: "github", CreatedAt: time.Now().Add(-time.Hour)}
+	tokens.userRepo.EXPECT().GetUserByID("123").Return(user, nil).AnyTimes()
+
+	cli := &models.Client{ID: "cli", Public: true, Scopes: []string{"openid", "email"}, GrantTypes: []string{DeviceCodeGrantType}}
+	clients := testClientStore{"cli": cli}
+
+	service := NewDeviceService(clients, deviceRepo, tokens.TokenService, tokens.userRepo, DeviceAuthorizationConfig{
+		VerificationURI: "https://auth.example.com/device",
+		CodeTTL:         10 * time.Minute,
+		Interval:        5 * time.Second,
+	})
+	now := time.Now()
+	service.now = func() time.Time { return now }
+
+	// codes stands in for the device_codes table
+	codes := map[string]models.DeviceCode{}
+	deviceRepo.EXPECT().CreateDeviceCode(gomock.Any()).DoAndReturn(func(code models.DeviceCode) error {
+		codes[code.ID] = code
+		return nil
+	}).AnyTimes()
+	deviceRepo.EXPECT().GetDeviceCode(gomock.Any()).DoAndReturn(func(id string) (*models.DeviceCode, error) {
+		code, ok := codes[id]
+		if !ok {
+			return nil, repository.ErrNotFound
+		}
+		return &code, nil
+	}).AnyTimes()
+	deviceRepo.EXPECT().GetDeviceCodeByUserCode(gomock.Any()).DoAndReturn(func(userCode string) (*models.DeviceCode, error) {
+		for _, code := range codes {
+			if code.UserCode == userCode {
+				return &code, nil
+			}
+		}
+		return nil, repository.ErrNotFound
+	}).AnyTimes()
+	deviceRepo.EXPECT().DecideDeviceCode(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(func(id, status, userID, provider string, authTime time.Time) error {
+		code, ok := codes[id]
+		if !ok || code.Status != models.DeviceCodePending {
+			return repository.ErrNotFound
+		}
+		code.Status, code.UserID, code.Provider, code.AuthTime = status, userID, provider, &authTime
+		codes[id] = code
+		return nil
+	}).AnyTimes()
+	deviceRepo.EXPECT().UpdateDeviceCodePoll(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(func(id string, polledAt time.Time, interval int) error {
+		code := codes[id]
+		code.LastPolledAt, code.Interval = &polledAt, interval
+		codes[id] = code
+		return nil
+	}).AnyTimes()
+	deviceRepo.EXPECT().DeleteDeviceCode(gomock.Any()).DoAndReturn(func(id string) error {
+		if _, ok := codes[id]; !ok {
+			return repository.ErrNotFound
+		}
+		delete(codes, id)
+		return nil
+	}).AnyTimes()
+
+	t.Run("Start", func(t *testing.T) {
+		started, err := service.Start(cli, "openid email")
+		require.NoError(t, err)
+
+		assert.Regexp(t, regexp.MustCompile(`^[BCDFGHJKLMNPQRSTVWXZ]{4}-[BCDFGHJKLMNPQRSTVWXZ]{4}$`), started.UserCode)
+		assert.Equal(t, "https://auth.example.com/device", started.VerificationURI)
+		assert.Equal(t, "https://auth.example.com/device?user_code="+started.UserCode, started.VerificationURIComplete)
+		assert.Equal(t, int64(600), started.ExpiresIn)
+		assert.Equal(t, int64(5), started.Interval)
+		require.Contains(t, codes, sha256Hex(started.DeviceCode))
+		assert.Equal(t, models.DeviceCodePending, codes[sha256Hex(started.DeviceCode)].Status)
+	})
+
+	t.Run("StartInvalidScope", func(t *testing.T) {
+		_, err := service.Start(cli, "openid offline_access")
+
+		assert.ErrorIs(t, err, ErrInvalidScope)
+	})
+
+	t.Run("Approve", func(t *testing.T) {
+		started, err := service.Start(cli, "openid email")
+		require.NoError(t, err)
+
+		_, err = service.Poll(cli, started.DeviceCode)
+		assert.ErrorIs(t, err, ErrAuthorizationPending)
+
+		_, err = service.Poll(cli, started.DeviceCode)
+		assert.ErrorIs(t, err, ErrSlowDown)
+		assert.Equal(t, 10, codes[sha256Hex(started.DeviceCode)].Interval)
+
+		// Users may type the code in lower case and without the dash
+		typed := started.UserCode[:4] + " " + started.UserCode[5:]
+		code, client, err := service.Lookup(typed)
+		require.NoError(t, err)
+		assert.Equal(t, "cli", client.ID)
+		require.NoError(t, service.Approve(code, user, session))
+
+		_, _, err = service.Lookup(started.UserCode)
+		assert.ErrorIs(t, err, ErrUnknownUserCode)
+
+		now = now.Add(10 * time.Second)
+		issued, err := service.Poll(cli, started.DeviceCode)
+		require.NoError(t, err)
+		assert.NotEmpty(t, issued.AccessToken)
+		assert.NotEmpty(t, issued.IDToken)
+		assert.Equal(t, "openid email", issued.Scope)
+
+		_, err = service.Poll(cli, started.DeviceCode)
+		assert.ErrorIs(t, err, ErrInvalidGrant)
+	})
+
+	t.Run("Deny", func(t *testing.T) {
+		started, err := service.Start(cli, "openid")
+		require.NoError(t, err)
+		code, _, err := service.Lookup(started.UserCode)
+		require.NoError(t, err)
+
+		require.NoError(t, service.Deny(code, user))
+
+		_, err = service.Poll(cli, started.DeviceCode)
+		assert.ErrorIs(t, err, ErrAccessDenied)
+		assert.ErrorIs(t, service.Approve(code, user, session), ErrUnknownUserCode)
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		started, err := service.Start(cli, "openid")
+		require.NoError(t, err)
+
+		now = now.Add(11 * time.Minute)
+
+		_, err = service.Poll(cli, started.DeviceCode)
+		assert.ErrorIs(t, err, ErrExpiredToken)
+		_, _, err = service.Lookup(started.UserCode)
+		assert.ErrorIs(t, err, ErrUnknownUserCode)
+	})
+
+	t.Run("OtherClient", func(t *testing.T) {
+		started, err := service.Start(cli, "openid")
+		require.NoError(t, err)
+
+		_, err = service.Poll(&models.Client{ID: "other"}, started.DeviceCode)
+
+		assert.ErrorIs(t, err, ErrInvalidGrant)
+	})
+
+	t.Run("Unknown", func(t *testing.T) {
+		_, err := service.Poll(cli, "unknown")
+
+		assert.ErrorIs(t, err, ErrInvalidGrant)
+	})
+}
+
+func TestNormalizeUserCode(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"WDJB-MJHT", "WDJB-MJHT"},
+		{"wdjbmjht", "WDJB-MJHT"},
+		{" wdjb mjht ", "WDJB-MJHT"},
+		{"WDJB", "WDJB"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			assert.Equal(t, tt.want, normalizeUserCode(tt.input))
+		})
+	}
+}
@@ -3,19 +3,33 @@ package services
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"login-with-oauth/internal/models"
 	"login-with-oauth/internal/repository"
 	"net/http"
-	"time"
+	"slices"
+	"strings"
 
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/github"
 )
 
+// ErrNoVerifiedEmail is returned for GitHub accounts without a verified
+// primary email, which we cannot tell apart or trust
+var ErrNoVerifiedEmail = errors.New("GitHub account has no verified primary email")
+
+// ErrNotOrganizationMember is returned for GitHub accounts outside the
+// organizations and teams logins are restricted to
+var ErrNotOrganizationMember = errors.New("GitHub account is not a member of an allowed organization or team")
+
 type GithubService struct {
 	config         *oauth2.Config
+	pkce           PKCEMode
+	options        AuthOptions
+	organizations  []string
+	teams          []string
 	userRepository repository.UserRepository
 }
 
@@ -30,44 +44,93 @@ func NewGitHubService(clientID, clientSecret string, userRepository repository.U
 
 	return &GithubService{
 		config:         config,
+		pkce:           PKCEPreferred,
 		userRepository: userRepository,
 	}
 }
 
-func (s *GithubService) GetAuthURL(state string) string {
-	return s.config.AuthCodeURL(state)
+// SetPKCEMode changes whether logins use PKCE
+func (s *GithubService) SetPKCEMode(mode PKCEMode) {
+	s.pkce = mode
 }
 
-func (s *GithubService) Exchange(ctx context.Context, code string) (*oauth2.Token, error) {
-	return s.config.Exchange(ctx, code)
+// PKCE returns the PKCE mode used for logins
+func (s *GithubService) PKCE() PKCEMode {
+	return s.pkce
 }
 
-func (s *GithubService) GetUserData(token *oauth2.Token) (*models.User, error) {
-	client := s.config.Client(context.Background(), token)
+// SetRequiredMemberships restricts logins to members of any of organizations
+// or teams ("org/team-slug"), which needs the read:org scope. Without any,
+// every GitHub account may log in.
+func (s *GithubService) SetRequiredMemberships(organizations, teams []string) {
+	s.organizations, s.teams = nil, nil
+	for _, organization := range organizations {
+		s.organizations = append(s.organizations, strings.ToLower(organization))
+	}
+	for _, team := range teams {
+		s.teams = append(s.teams, strings.ToLower(team))
+	}
 
-	// Create request
-	req, err := http.NewRequest("GET", "https://api.github.com/user", nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %v", err)
+	if s.restricted() && !slices.Contains(s.config.Scopes, "read:org") {
+		s.config.Scopes = append(slices.Clone(s.config.Scopes), "read:org")
 	}
+}
 
-	// Set headers
-	req.Header.Set("User-Agent", "Oauth")
+func (s *GithubService) restricted() bool {
+	return len(s.organizations) > 0 || len(s.teams) > 0
+}
 
-	// Make request
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to make request: %v", err)
-	}
-	defer resp.Body.Close()
+// Name identifies GitHub in routes and stored states
+func (s *GithubService) Name() string {
+	return "github"
+}
 
-	body, err := io.ReadAll(resp.Body)
+// DisplayName is shown on the login page
+func (s *GithubService) DisplayName() string {
+	return "GitHub"
+}
+
+func (s *GithubService) GetAuthURL(state string, opts ...oauth2.AuthCodeOption) string {
+	return s.config.AuthCodeURL(state, opts...)
+}
+
+// AuthURL implements Provider
+func (s *GithubService) AuthURL(state *models.OAuthState) string {
+	return authCodeURL(s.config, s.pkce, s.options, state)
+}
+
+// Exchange implements Provider
+func (s *GithubService) Exchange(ctx context.Context, code string, state *models.OAuthState) (*oauth2.Token, error) {
+	return exchangeCode(ctx, s.config, s.pkce, nil, code, state)
+}
+
+// TokenSource implements Provider
+func (s *GithubService) TokenSource(ctx context.Context, token *oauth2.Token) oauth2.TokenSource {
+	return refreshingTokenSource(ctx, s.config, nil, token)
+}
+
+// GetUserData fetches the GitHub identity for token and stores it as a user
+func (s *GithubService) GetUserData(token *oauth2.Token) (*models.User, error) {
+	identity, err := s.FetchIdentity(context.Background(), token, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %v", err)
+		return nil, err
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("GitHub API request failed with status: %d, body: %s", resp.StatusCode, string(body))
+	return resolveUser(s.userRepository, identity)
+}
+
+// FetchIdentity implements Provider. The email comes from /user/emails,
+// since /user leaves it empty for users who keep their email private and
+// does not say whether it was verified. Accounts without a verified primary
+// email get ErrNoVerifiedEmail. Organization and team memberships are
+// fetched when logins are restricted to them or read:org was granted, and
+// accounts outside the required ones get ErrNotOrganizationMember.
+func (s *GithubService) FetchIdentity(ctx context.Context, token *oauth2.Token, _ *models.OAuthState) (*models.Identity, error) {
+	client := s.config.Client(ctx, token)
+
+	body, err := githubAPIGet(ctx, client, "https://api.github.com/user")
+	if err != nil {
+		return nil, err
 	}
 
 	var githubUser struct {
@@ -82,14 +145,126 @@ func (s *GithubService) GetUserData(token *oauth2.Token) (*models.User, error) {
 		return nil, fmt.Errorf("failed to decode JSON: %v, body: %s", err, string(body))
 	}
 
-	userData := models.User{
-		ID:        fmt.Sprintf("%d", githubUser.ID),
-		Username:  githubUser.Login,
-		Email:     githubUser.Email,
-		AvatarURL: githubUser.AvatarURL,
-		CreatedAt: time.Now().Format(time.RFC3339),
-		UpdatedAt: time.Now().Format(time.RFC3339),
+	email, err := s.primaryEmail(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+
+	var memberships []string
+	if s.restricted() || slices.Contains(GrantedScopes(token, nil), "read:org") {
+		if memberships, err = s.memberships(ctx, client); err != nil {
+			return nil, err
+		}
+	}
+	if s.restricted() && !slices.ContainsFunc(memberships, func(membership string) bool {
+		return slices.Contains(s.organizations, membership) || slices.Contains(s.teams, membership)
+	}) {
+		return nil, fmt.Errorf("%w: %s", ErrNotOrganizationMember, githubUser.Login)
+	}
+
+	return &models.Identity{
+		Provider:      s.Name(),
+		Subject:       fmt.Sprintf("%d", githubUser.ID),
+		Email:         email,
+		EmailVerified: true,
+		Username:      githubUser.Login,
+		AvatarURL:     githubUser.AvatarURL,
+		RawProfile:    body,
+		Memberships:   memberships,
+	}, nil
+}
+
+// memberships lists the organizations ("org") and teams ("org/team-slug")
+// of the token's user, in lower case. It needs the read:org scope. Only the
+// first 100 of each are fetched, which can only deny members of more.
+func (s *GithubService) memberships(ctx context.Context, client *http.Client) ([]string, error) {
+	body, err := githubAPIGet(ctx, client, "https://api.github.com/user/orgs?per_page=100")
+	if err != nil {
+		return nil, err
+	}
+
+	var organizations []struct {
+		Login string `json:"login"`
+	}
+	if err := json.Unmarshal(body, &organizations); err != nil {
+		return nil, fmt.Errorf("failed to decode organizations JSON: %v, body: %s", err, string(body))
+	}
+
+	body, err = githubAPIGet(ctx, client, "https://api.github.com/user/teams?per_page=100")
+	if err != nil {
+		return nil, err
+	}
+
+	var teams []struct {
+		Slug         string `json:"slug"`
+		Organization struct {
+			Login string `json:"login"`
+		} `json:"organization"`
+	}
+	if err := json.Unmarshal(body, &teams); err != nil {
+		return nil, fmt.Errorf("failed to decode teams JSON: %v, body: %s", err, string(body))
+	}
+
+	memberships := []string{}
+	for _, organization := range organizations {
+		memberships = append(memberships, strings.ToLower(organization.Login))
+	}
+	for _, team := range teams {
+		memberships = append(memberships, strings.ToLower(team.Organization.Login+"/"+team.Slug))
+	}
+	return memberships, nil
+}
+
+// primaryEmail returns the verified primary email of the token's user. It
+// needs the user:email scope.
+func (s *GithubService) primaryEmail(ctx context.Context, client *http.Client) (string, error) {
+	body, err := githubAPIGet(ctx, client, "https://api.github.com/user/emails")
+	if err != nil {
+		return "", err
+	}
+
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := json.Unmarshal(body, &emails); err != nil {
+		return "", fmt.Errorf("failed to decode emails JSON: %v, body: %s", err, string(body))
+	}
+
+	for _, email := range emails {
+		if email.Primary && email.Verified && email.Email != "" {
+			return email.Email, nil
+		}
+	}
+	return "", ErrNoVerifiedEmail
+}
+
+// githubAPIGet fetches a GitHub API resource and returns its body
+func githubAPIGet(ctx context.Context, client *http.Client, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+
+	// GitHub rejects requests without a User-Agent
+	req.Header.Set("User-Agent", "Oauth")
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GitHub API request failed with status: %d, body: %s", resp.StatusCode, string(body))
 	}
 
-	return s.userRepository.CreateUser(userData)
+	return body, nil
 }
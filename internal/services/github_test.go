@@ -12,6 +12,7 @@ import (
 
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"golang.org/x/oauth2"
 )
 
@@ -44,7 +45,7 @@ func TestGithubService(t *testing.T) {
 		code := "test-code"
 
 		// Act
-		token, err := service.Exchange(context.Background(), code)
+		token, err := service.Exchange(context.Background(), code, &models.OAuthState{})
 
 		// Note: This will actually fail because it tries to make a real HTTP request
 		// In a real test, you'd want to mock the OAuth2 config's exchange function
@@ -54,28 +55,23 @@ func TestGithubService(t *testing.T) {
 
 	t.Run("TestGetUserData", func(t *testing.T) {
 		// Create a mock server
-		mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// Return mock response for GitHub API
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusOK)
-			w.Write([]byte(`{
+		mockServer := newGithubAPIServer(`{
 				"id": 12345,
 				"login": "testuser",
 				"name": "Test User",
 				"email": "test@example.com",
 				"avatar_url": "https://example.com/avatar.jpg"
-			}`))
-		}))
+			}`, `[{"email": "test@example.com", "primary": true, "verified": true}]`)
 		defer mockServer.Close()
 
 		// Create the service
 		service := NewGitHubService("test-client-id", "test-client-secret", mockUserRepo)
 
-		// Override the service's config and transport
-		transport := &mockTransport{
-			mockServer: mockServer,
-		}
-		http.DefaultClient.Transport = transport
+		// GetUserData has no context to carry a client, so point the default
+		// client at the mock server for this test only
+		transport := http.DefaultClient.Transport
+		t.Cleanup(func() { http.DefaultClient.Transport = transport })
+		http.DefaultClient.Transport = &mockTransport{mockServer: mockServer}
 
 		// Override the service's config
 		service.config = &oauth2.Config{
@@ -99,14 +95,18 @@ func TestGithubService(t *testing.T) {
 			Username:  "testuser",
 			Email:     "test@example.com",
 			AvatarURL: "https://example.com/avatar.jpg",
-			CreatedAt: time.Now().Format(time.RFC3339),
-			UpdatedAt: time.Now().Format(time.RFC3339),
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
 		}
 
 		// Set up mock repository expectation
 		mockUserRepo.EXPECT().
-			CreateUser(gomock.Any()).
-			Return(expectedUser, nil)
+			ResolveIdentity(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(identity models.Identity, _ models.User) (*models.User, error) {
+				assert.Equal(t, "github", identity.Provider)
+				assert.Equal(t, "12345", identity.Subject)
+				return expectedUser, nil
+			})
 
 		// Act
 		user, err := service.GetUserData(token)
@@ -120,6 +120,39 @@ func TestGithubService(t *testing.T) {
 		assert.Equal(t, expectedUser.AvatarURL, user.AvatarURL)
 	})
 
+	t.Run("TestFetchIdentity_PrivateEmail", func(t *testing.T) {
+		mockServer := newGithubAPIServer(
+			`{"id": 12345, "login": "testuser", "email": null}`,
+			`[{"email": "old@example.com", "primary": false, "verified": true}, {"email": "private@example.com", "primary": true, "verified": true}]`,
+		)
+		defer mockServer.Close()
+		ctx := mockGithubContext(mockServer)
+
+		service := NewGitHubService("test-client-id", "test-client-secret", mockUserRepo)
+
+		identity, err := service.FetchIdentity(ctx, &oauth2.Token{AccessToken: "test-token"}, nil)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "private@example.com", identity.Email)
+		assert.True(t, identity.EmailVerified)
+	})
+
+	t.Run("TestFetchIdentity_NoVerifiedEmail", func(t *testing.T) {
+		mockServer := newGithubAPIServer(
+			`{"id": 12345, "login": "testuser", "email": "unverified@example.com"}`,
+			`[{"email": "unverified@example.com", "primary": true, "verified": false}]`,
+		)
+		defer mockServer.Close()
+		ctx := mockGithubContext(mockServer)
+
+		service := NewGitHubService("test-client-id", "test-client-secret", mockUserRepo)
+
+		identity, err := service.FetchIdentity(ctx, &oauth2.Token{AccessToken: "test-token"}, nil)
+
+		assert.ErrorIs(t, err, ErrNoVerifiedEmail)
+		assert.Nil(t, identity)
+	})
+
 	// t.Run("TestGetUserData_APIError", func(t *testing.T) {
 	// 	// Arrange
 	// 	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -170,6 +203,90 @@ func TestGithubService(t *testing.T) {
 	// })
 }
 
+func TestGithubMemberships(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockServer := newGithubMembershipServer(
+		`[{"login": "Acme"}, {"login": "other-org"}]`,
+		`[{"slug": "admins", "organization": {"login": "Acme"}}]`,
+	)
+	defer mockServer.Close()
+	ctx := mockGithubContext(mockServer)
+
+	service := NewGitHubService("test-client-id", "test-client-secret", mock.NewMockUserRepository(ctrl))
+
+	t.Run("RequestsReadOrg", func(t *testing.T) {
+		service.SetRequiredMemberships([]string{"acme"}, nil)
+
+		assert.Contains(t, service.GetAuthURL("test-state"), "scope=user%3Aemail+user%3Aavatar+read%3Aorg")
+	})
+
+	t.Run("OrganizationMember", func(t *testing.T) {
+		service.SetRequiredMemberships([]string{"acme"}, nil)
+
+		identity, err := service.FetchIdentity(ctx, &oauth2.Token{AccessToken: "test-token"}, nil)
+
+		require.NoError(t, err)
+		assert.Equal(t, []string{"acme", "other-org", "acme/admins"}, identity.Memberships)
+	})
+
+	t.Run("TeamMember", func(t *testing.T) {
+		service.SetRequiredMemberships(nil, []string{"Acme/admins"})
+
+		_, err := service.FetchIdentity(ctx, &oauth2.Token{AccessToken: "test-token"}, nil)
+
+		assert.NoError(t, err)
+	})
+
+	t.Run("NotMember", func(t *testing.T) {
+		service.SetRequiredMemberships([]string{"initech"}, []string{"acme/owners"})
+
+		identity, err := service.FetchIdentity(ctx, &oauth2.Token{AccessToken: "test-token"}, nil)
+
+		assert.ErrorIs(t, err, ErrNotOrganizationMember)
+		assert.Nil(t, identity)
+	})
+
+	t.Run("Unrestricted", func(t *testing.T) {
+		service.SetRequiredMemberships(nil, nil)
+
+		identity, err := service.FetchIdentity(ctx, &oauth2.Token{AccessToken: "test-token"}, nil)
+
+		require.NoError(t, err)
+		// Memberships are not asked for without read:org
+		assert.Nil(t, identity.Memberships)
+
+		token := (&oauth2.Token{AccessToken: "test-token"}).WithExtra(map[string]any{"scope": "read:org,user:email"})
+		identity, err = service.FetchIdentity(ctx, token, nil)
+
+		require.NoError(t, err)
+		assert.Contains(t, identity.Memberships, "acme/admins")
+	})
+}
+
+// newGithubAPIServer serves user for /user and emails for /user/emails
+func newGithubAPIServer(user, emails string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/user":
+			w.Write([]byte(user))
+		case "/user/emails":
+			w.Write([]byte(emails))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+// mockGithubContext carries a client that sends GitHub API requests to
+// mockServer, for oauth2 to use instead of http.DefaultClient
+func mockGithubContext(mockServer *httptest.Server) context.Context {
+	client := &http.Client{Transport: &mockTransport{mockServer: mockServer}}
+	return context.WithValue(context.Background(), oauth2.HTTPClient, client)
+}
+
 // mockTransport implements http.RoundTripper
 type mockTransport struct {
 	mockServer *httptest.Server
@@ -183,3 +300,23 @@ func (t *mockTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 	}
 	return http.DefaultTransport.RoundTrip(req)
 }
+
+// newGithubMembershipServer serves a verified user along with orgs for
+// /user/orgs and teams for /user/teams
+func newGithubMembershipServer(orgs, teams string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/user":
+			w.Write([]byte(`{"id": 12345, "login": "testuser"}`))
+		case "/user/emails":
+			w.Write([]byte(`[{"email": "test@example.com", "primary": true, "verified": true}]`))
+		case "/user/orgs":
+			w.Write([]byte(orgs))
+		case "/user/teams":
+			w.Write([]byte(teams))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
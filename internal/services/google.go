@@ -3,71 +3,211 @@ package services
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"login-with-oauth/internal/jwt"
 	"login-with-oauth/internal/models"
 	"login-with-oauth/internal/repository"
 	"net/http"
-	"time"
+	"slices"
+	"strings"
 
-	"github.com/spf13/viper"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 )
 
+const (
+	googleJWKSURL     = "https://www.googleapis.com/oauth2/v3/certs"
+	googleUserInfoURL = "https://www.googleapis.com/oauth2/v2/userinfo"
+)
+
+// googleIssuers are the iss values Google uses in ID tokens
+var googleIssuers = []string{"https://accounts.google.com", "accounts.google.com"}
+
+// ErrHostedDomainNotAllowed is returned for Google accounts outside the
+// Workspace domains logins are restricted to
+var ErrHostedDomainNotAllowed = errors.New("Google account is not in an allowed hosted domain")
+
 type GoogleService struct {
 	config         *oauth2.Config
+	pkce           PKCEMode
+	options        AuthOptions
+	hostedDomains  []string
+	httpClient     *http.Client
+	verifier       *IDTokenVerifier
 	userRepository repository.UserRepository
 }
 
 func NewGoogleService(clientID, clientSecret string, userRepository repository.UserRepository) *GoogleService {
 	config := &oauth2.Config{
-		ClientID:     viper.GetString("google.clientID"),
-		ClientSecret: viper.GetString("google.clientSecret"),
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
 		RedirectURL:  "http://localhost:8080/callback-gl",
 		Endpoint:     google.Endpoint,
 		Scopes: []string{
+			"openid",
 			"https://www.googleapis.com/auth/userinfo.email",
 			"https://www.googleapis.com/auth/userinfo.profile",
 		},
 	}
 
-	return &GoogleService{
+	service := &GoogleService{
 		config:         config,
+		pkce:           PKCEPreferred,
 		userRepository: userRepository,
 	}
+	service.SetHTTPClient(http.DefaultClient)
+
+	return service
+}
+
+// SetHTTPClient changes the client used to fetch Google's signing keys and
+// the userinfo endpoint
+func (s *GoogleService) SetHTTPClient(client *http.Client) {
+	s.httpClient = client
+	s.verifier = NewIDTokenVerifier(jwt.NewRemoteKeySet(googleJWKSURL, client), s.config.ClientID, googleIssuers...)
+}
+
+// SetPKCEMode changes whether logins use PKCE
+func (s *GoogleService) SetPKCEMode(mode PKCEMode) {
+	s.pkce = mode
+}
+
+// PKCE returns the PKCE mode used for logins
+func (s *GoogleService) PKCE() PKCEMode {
+	return s.pkce
+}
+
+// SetHostedDomains restricts logins to accounts of these Workspace domains.
+// Without any, every Google account may log in.
+func (s *GoogleService) SetHostedDomains(domains ...string) {
+	s.hostedDomains = nil
+	for _, domain := range domains {
+		s.hostedDomains = append(s.hostedDomains, strings.ToLower(domain))
+	}
+}
+
+// Name identifies Google in routes and stored states
+func (s *GoogleService) Name() string {
+	return "google"
+}
+
+// DisplayName is shown on the login page
+func (s *GoogleService) DisplayName() string {
+	return "Google"
 }
 
-func (s *GoogleService) GetAuthURL(state string) string {
-	return s.config.AuthCodeURL(state)
+func (s *GoogleService) GetAuthURL(state string, opts ...oauth2.AuthCodeOption) string {
+	return s.config.AuthCodeURL(state, opts...)
 }
 
-func (s *GoogleService) Exchange(ctx context.Context, code string) (*oauth2.Token, error) {
-	return s.config.Exchange(ctx, code)
+// AuthURL implements Provider
+func (s *GoogleService) AuthURL(state *models.OAuthState) string {
+	opts := []oauth2.AuthCodeOption{NonceOption(state.Nonce)}
+	// hd only preselects an account; FetchIdentity enforces the domain.
+	// With several domains "*" still hides consumer accounts.
+	switch len(s.hostedDomains) {
+	case 0:
+	case 1:
+		opts = append(opts, oauth2.SetAuthURLParam("hd", s.hostedDomains[0]))
+	default:
+		opts = append(opts, oauth2.SetAuthURLParam("hd", "*"))
+	}
+	return authCodeURL(s.config, s.pkce, s.options, state, opts...)
+}
+
+// Exchange implements Provider
+func (s *GoogleService) Exchange(ctx context.Context, code string, state *models.OAuthState) (*oauth2.Token, error) {
+	return exchangeCode(ctx, s.config, s.pkce, s.httpClient, code, state)
 }
 
-func (s *GoogleService) GetUserData(token *oauth2.Token) (*models.User, error) {
-	client := s.config.Client(context.Background(), token)
+// TokenSource implements Provider
+func (s *GoogleService) TokenSource(ctx context.Context, token *oauth2.Token) oauth2.TokenSource {
+	return refreshingTokenSource(ctx, s.config, s.httpClient, token)
+}
 
-	req, err := http.NewRequest("GET", "https://www.googleapis.com/oauth2/v2/userinfo", nil)
+// GetUserData fetches the Google identity for token and stores it as a user
+func (s *GoogleService) GetUserData(ctx context.Context, token *oauth2.Token, nonce string) (*models.User, error) {
+	identity, err := s.FetchIdentity(ctx, token, &models.OAuthState{Nonce: nonce})
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %v", err)
+		return nil, err
+	}
+
+	return resolveUser(s.userRepository, identity)
+}
+
+// FetchIdentity builds the identity from the verified ID token in token,
+// calling the userinfo endpoint only for profile claims the ID token lacks
+func (s *GoogleService) FetchIdentity(ctx context.Context, token *oauth2.Token, state *models.OAuthState) (*models.Identity, error) {
+	rawIDToken, _ := token.Extra("id_token").(string)
+	if rawIDToken == "" {
+		return nil, ErrIDTokenMissing
+	}
+
+	claims, err := s.verifier.Verify(ctx, rawIDToken, state.Nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Email == "" || claims.Name == "" {
+		if err := s.fillFromUserInfo(ctx, token, claims); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.checkHostedDomain(claims); err != nil {
+		return nil, err
+	}
+
+	return identityFromClaims(s.Name(), claims, claims.Name)
+}
+
+// checkHostedDomain rejects accounts outside the allowed hosted domains. The
+// hd claim is only set for Workspace accounts, and the email must be
+// verified and in the same domain, so that the claim cannot be borrowed.
+func (s *GoogleService) checkHostedDomain(claims *IDTokenClaims) error {
+	if len(s.hostedDomains) == 0 {
+		return nil
+	}
+
+	domain := strings.ToLower(claims.HostedDomain)
+	switch {
+	case domain == "":
+		return fmt.Errorf("%w: %s is not a Workspace account", ErrHostedDomainNotAllowed, claims.Email)
+	case !slices.Contains(s.hostedDomains, domain):
+		return fmt.Errorf("%w: %s belongs to %s", ErrHostedDomainNotAllowed, claims.Email, domain)
+	case !bool(claims.EmailVerified):
+		return fmt.Errorf("%w: %s is not verified", ErrHostedDomainNotAllowed, claims.Email)
+	case !strings.HasSuffix(strings.ToLower(claims.Email), "@"+domain):
+		return fmt.Errorf("%w: %s is not an address of %s", ErrHostedDomainNotAllowed, claims.Email, domain)
+	}
+	return nil
+}
+
+// fillFromUserInfo completes missing claims from the userinfo endpoint. The
+// response is only trusted if it describes the ID token's subject.
+func (s *GoogleService) fillFromUserInfo(ctx context.Context, token *oauth2.Token, claims *IDTokenClaims) error {
+	client := s.config.Client(context.WithValue(ctx, oauth2.HTTPClient, s.httpClient), token)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", googleUserInfoURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
 	}
-	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to make request: %v", err)
+		return fmt.Errorf("failed to make request: %v", err)
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %v", err)
+		return fmt.Errorf("failed to read response body: %v", err)
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("Google API request failed with status: %d, body: %s", resp.StatusCode, string(body))
+		return fmt.Errorf("Google API request failed with status: %d, body: %s", resp.StatusCode, string(body))
 	}
 
 	var googleUser struct {
@@ -79,22 +219,23 @@ func (s *GoogleService) GetUserData(token *oauth2.Token) (*models.User, error) {
 	}
 
 	if err := json.Unmarshal(body, &googleUser); err != nil {
-		return nil, fmt.Errorf("failed to decode JSON: %v, body: %s", err, string(body))
+		return fmt.Errorf("failed to decode JSON: %v, body: %s", err, string(body))
 	}
 
-	userData := models.User{
-		ID:        googleUser.ID,
-		Username:  googleUser.Name,
-		Email:     googleUser.Email,
-		AvatarURL: googleUser.Picture,
-		CreatedAt: time.Now().Format(time.RFC3339),
-		UpdatedAt: time.Now().Format(time.RFC3339),
+	if googleUser.ID != claims.Subject {
+		return fmt.Errorf("userinfo subject %q does not match id_token subject %q", googleUser.ID, claims.Subject)
 	}
 
-	savedUser, err := s.userRepository.CreateUser(userData)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create user in repository: %v", err)
+	if claims.Email == "" {
+		claims.Email = googleUser.Email
+		claims.EmailVerified = claimBool(googleUser.Verified)
+	}
+	if claims.Name == "" {
+		claims.Name = googleUser.Name
+	}
+	if claims.Picture == "" {
+		claims.Picture = googleUser.Picture
 	}
 
-	return savedUser, nil
+	return nil
 }
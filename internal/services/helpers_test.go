@@ -0,0 +1,86 @@
+package services
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"login-with-oauth/internal/models"
+	"login-with-oauth/internal/repository/mock"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+// testTokenService is a TokenService signing with a fresh P-256 key over
+// mock repositories. refreshTokens and accessTokens record the tokens it
+// creates; other calls are left for each test to expect.
+type testTokenService struct {
+	*TokenService
+	signer        *StaticSigner
+	refreshRepo   *mock.MockRefreshTokenRepository
+	accessRepo    *mock.MockAccessTokenRepository
+	userRepo      *mock.MockUserRepository
+	refreshTokens map[string]models.RefreshToken
+	accessTokens  map[string]models.AccessToken
+}
+
+// newTestTokenService creates a testTokenService. The issuer and token
+// lifetimes default to https://auth.example.com, 15 minutes and a day.
+func newTestTokenService(t *testing.T, ctrl *gomock.Controller, config TokenConfig) *testTokenService {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	signer, err := NewStaticSigner(key, "")
+	require.NoError(t, err)
+
+	tokens := &testTokenService{
+		signer:        signer,
+		refreshRepo:   mock.NewMockRefreshTokenRepository(ctrl),
+		accessRepo:    mock.NewMockAccessTokenRepository(ctrl),
+		userRepo:      mock.NewMockUserRepository(ctrl),
+		refreshTokens: map[string]models.RefreshToken{},
+		accessTokens:  map[string]models.AccessToken{},
+	}
+	tokens.refreshRepo.EXPECT().CreateRefreshToken(gomock.Any()).DoAndReturn(func(token models.RefreshToken) error {
+		tokens.refreshTokens[token.ID] = token
+		return nil
+	}).AnyTimes()
+	tokens.accessRepo.EXPECT().CreateAccessToken(gomock.Any()).DoAndReturn(func(token models.AccessToken) error {
+		tokens.accessTokens[token.ID] = token
+		return nil
+	}).AnyTimes()
+
+	tokens.TokenService = tokens.withConfig(t, config)
+	return tokens
+}
+
+// withConfig creates another TokenService with the same key and
+// repositories
+func (s *testTokenService) withConfig(t *testing.T, config TokenConfig) *TokenService {
+	if config.Issuer == "" {
+		config.Issuer = "https://auth.example.com"
+	}
+	if config.AccessTokenTTL == 0 {
+		config.AccessTokenTTL = 15 * time.Minute
+	}
+	if config.RefreshTokenTTL == 0 {
+		config.RefreshTokenTTL = 24 * time.Hour
+	}
+
+	service, err := NewTokenService(s.signer, s.refreshRepo, s.accessRepo, s.userRepo, config)
+	require.NoError(t, err)
+	return service
+}
+
+// testClientStore is a ClientStore over a fixed set of clients
+type testClientStore map[string]*models.Client
+
+func (s testClientStore) GetClient(id string) (*models.Client, error) {
+	client, ok := s[id]
+	if !ok {
+		return nil, ErrClientNotFound
+	}
+	copied := *client
+	return &copied, nil
+}
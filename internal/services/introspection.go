@@ -0,0 +1,138 @@
+package services
+
+import (
+	"errors"
+	"login-with-oauth/internal/models"
+	"login-with-oauth/internal/repository"
+	"strings"
+)
+
+var ErrTokenNotOwned = errors.New("token was issued to another client")
+
+// IntrospectionResponse describes a token (RFC 7662 section 2.2). Inactive
+// tokens are described by Active alone.
+type IntrospectionResponse struct {
+	Active    bool     `json:"active"`
+	Scope     string   `json:"scope,omitempty"`
+	ClientID  string   `json:"client_id,omitempty"`
+	Username  string   `json:"username,omitempty"`
+	TokenType string   `json:"token_type,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	Subject   string   `json:"sub,omitempty"`
+	Audience  []string `json:"aud,omitempty"`
+	Issuer    string   `json:"iss,omitempty"`
+	ID        string   `json:"jti,omitempty"`
+}
+
+// Introspect describes an access or refresh token we issued. hint is the
+// token_type_hint of the request and only decides which kind is tried first.
+func (s *TokenService) Introspect(raw, hint string) (*IntrospectionResponse, error) {
+	lookups := []func(string) (*IntrospectionResponse, error){s.introspectAccessToken, s.introspectRefreshToken}
+	if hint == "refresh_token" {
+		lookups[0], lookups[1] = lookups[1], lookups[0]
+	}
+
+	for _, lookup := range lookups {
+		response, err := lookup(raw)
+		if err != nil {
+			return nil, err
+		}
+		if response.Active {
+			return response, nil
+		}
+	}
+
+	return &IntrospectionResponse{Active: false}, nil
+}
+
+func (s *TokenService) introspectAccessToken(raw string) (*IntrospectionResponse, error) {
+	claims, err := s.VerifyAccessToken(raw)
+	if errors.Is(err, ErrInvalidToken) {
+		return &IntrospectionResponse{Active: false}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &IntrospectionResponse{
+		Active:    true,
+		Scope:     claims.Scope,
+		ClientID:  claims.ClientID,
+		Username:  claims.Username,
+		TokenType: "Bearer",
+		ExpiresAt: claims.ExpiresAt,
+		IssuedAt:  claims.IssuedAt,
+		Subject:   claims.Subject,
+		Audience:  claims.Audience,
+		Issuer:    claims.Issuer,
+		ID:        claims.ID,
+	}, nil
+}
+
+func (s *TokenService) introspectRefreshToken(raw string) (*IntrospectionResponse, error) {
+	saved, err := s.activeRefreshToken(raw)
+	if err != nil || saved == nil {
+		return &IntrospectionResponse{Active: false}, err
+	}
+
+	return &IntrospectionResponse{
+		Active:    true,
+		Scope:     saved.Scope,
+		ClientID:  saved.ClientID,
+		TokenType: "refresh_token",
+		ExpiresAt: saved.ExpiresAt.Unix(),
+		IssuedAt:  saved.CreatedAt.Unix(),
+		Subject:   saved.UserID,
+		Issuer:    s.config.Issuer,
+	}, nil
+}
+
+// activeRefreshToken looks up a refresh token that can still be used. It
+// returns nil if there is none.
+func (s *TokenService) activeRefreshToken(raw string) (*models.RefreshToken, error) {
+	saved, err := s.refreshTokenRepository.GetRefreshToken(sha256Hex(raw))
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if saved.RevokedAt != nil || saved.UsedAt != nil || !s.now().Before(saved.ExpiresAt) {
+		return nil, nil
+	}
+	return saved, nil
+}
+
+// Revoke revokes a token issued to clientID (RFC 7009). Revoking a refresh
+// token revokes its whole family along with the opaque access tokens issued
+// with it. Unknown tokens are ignored, and JWT access tokens cannot be
+// revoked; they expire on their own.
+func (s *TokenService) Revoke(raw, clientID string) error {
+	if strings.Contains(raw, ".") {
+		return nil
+	}
+
+	refreshToken, err := s.refreshTokenRepository.GetRefreshToken(sha256Hex(raw))
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		return err
+	}
+	if refreshToken != nil {
+		if refreshToken.ClientID != clientID {
+			return ErrTokenNotOwned
+		}
+		return s.revokeFamily(refreshToken.FamilyID)
+	}
+
+	accessToken, err := s.accessTokenRepository.GetAccessToken(sha256Hex(raw))
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if accessToken.ClientID != clientID {
+		return ErrTokenNotOwned
+	}
+	return s.accessTokenRepository.RevokeAccessToken(accessToken.ID, s.now())
+}
@@ -0,0 +1,168 @@
+package services
+
+import (
+	"login-with-oauth/internal/models"
+	"login-with-oauth/internal/repository"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIntrospection(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	user := &models.User{ID: "123", Username: "testuser", Email: "test@example.com"}
+
+	tokens := newTestTokenService(t, ctrl, TokenConfig{Audience: []string{"api"}})
+	jwtService := tokens.TokenService
+	opaqueService := tokens.withConfig(t, TokenConfig{Audience: []string{"api"}, AccessTokenFormat: "opaque"})
+
+	grant := Grant{ClientID: "app", Provider: "github", Scope: "openid offline_access"}
+
+	t.Run("JWTAccessToken", func(t *testing.T) {
+		issued, err := jwtService.IssueGrant(user, grant)
+		require.NoError(t, err)
+
+		response, err := jwtService.Introspect(issued.AccessToken, "")
+		require.NoError(t, err)
+
+		assert.True(t, response.Active)
+		assert.Equal(t, "openid offline_access", response.Scope)
+		assert.Equal(t, "app", response.ClientID)
+		assert.Equal(t, "123", response.Subject)
+		assert.Equal(t, "Bearer", response.TokenType)
+		assert.Equal(t, []string{"api"}, response.Audience)
+		assert.NotZero(t, response.ExpiresAt)
+	})
+
+	t.Run("OpaqueAccessToken", func(t *testing.T) {
+		issued, err := opaqueService.IssueGrant(user, grant)
+		require.NoError(t, err)
+		assert.NotContains(t, issued.AccessToken, ".")
+		saved := tokens.accessTokens[sha256Hex(issued.AccessToken)]
+		assert.Equal(t, tokens.refreshTokens[sha256Hex(issued.RefreshToken)].FamilyID, saved.FamilyID)
+
+		tokens.accessRepo.EXPECT().GetAccessToken(saved.ID).Return(&saved, nil)
+
+		response, err := opaqueService.Introspect(issued.AccessToken, "access_token")
+		require.NoError(t, err)
+
+		assert.True(t, response.Active)
+		assert.Equal(t, "app", response.ClientID)
+		assert.Equal(t, "123", response.Subject)
+		assert.Equal(t, saved.ExpiresAt.Unix(), response.ExpiresAt)
+	})
+
+	t.Run("OpaqueClientCredentials", func(t *testing.T) {
+		client := &models.Client{ID: "batch", Scopes: []string{"orders:read"}, GrantTypes: []string{"client_credentials"}}
+
+		issued, err := opaqueService.IssueClientCredentials(client, "")
+		require.NoError(t, err)
+		assert.NotContains(t, issued.AccessToken, ".")
+		saved := tokens.accessTokens[sha256Hex(issued.AccessToken)]
+		assert.Empty(t, saved.UserID)
+		assert.Equal(t, "batch", saved.ClientID)
+
+		tokens.accessRepo.EXPECT().GetAccessToken(saved.ID).Return(&saved, nil)
+
+		response, err := opaqueService.Introspect(issued.AccessToken, "access_token")
+		require.NoError(t, err)
+
+		assert.True(t, response.Active)
+		assert.Equal(t, "batch", response.Subject)
+		assert.Equal(t, "batch", response.ClientID)
+		assert.Equal(t, "orders:read", response.Scope)
+	})
+
+	t.Run("RefreshToken", func(t *testing.T) {
+		issued, err := jwtService.IssueGrant(user, grant)
+		require.NoError(t, err)
+		saved := tokens.refreshTokens[sha256Hex(issued.RefreshToken)]
+
+		tokens.refreshRepo.EXPECT().GetRefreshToken(saved.ID).Return(&saved, nil)
+
+		response, err := jwtService.Introspect(issued.RefreshToken, "refresh_token")
+		require.NoError(t, err)
+
+		assert.True(t, response.Active)
+		assert.Equal(t, "refresh_token", response.TokenType)
+		assert.Equal(t, "app", response.ClientID)
+		assert.Equal(t, "123", response.Subject)
+		assert.Equal(t, saved.ExpiresAt.Unix(), response.ExpiresAt)
+	})
+
+	t.Run("UsedRefreshToken", func(t *testing.T) {
+		usedAt := time.Now()
+		saved := models.RefreshToken{ID: sha256Hex("used"), ClientID: "app", UsedAt: &usedAt, ExpiresAt: time.Now().Add(time.Hour)}
+
+		tokens.refreshRepo.EXPECT().GetRefreshToken(saved.ID).Return(&saved, nil)
+		tokens.accessRepo.EXPECT().GetAccessToken(saved.ID).Return(nil, repository.ErrNotFound)
+
+		response, err := jwtService.Introspect("used", "")
+		require.NoError(t, err)
+
+		assert.Equal(t, &IntrospectionResponse{Active: false}, response)
+	})
+
+	t.Run("Unknown", func(t *testing.T) {
+		tokens.refreshRepo.EXPECT().GetRefreshToken(sha256Hex("unknown")).Return(nil, repository.ErrNotFound)
+		tokens.accessRepo.EXPECT().GetAccessToken(sha256Hex("unknown")).Return(nil, repository.ErrNotFound)
+
+		response, err := jwtService.Introspect("unknown", "")
+		require.NoError(t, err)
+
+		assert.False(t, response.Active)
+	})
+
+	t.Run("RevokeRefreshToken", func(t *testing.T) {
+		issued, err := opaqueService.IssueGrant(user, grant)
+		require.NoError(t, err)
+		saved := tokens.refreshTokens[sha256Hex(issued.RefreshToken)]
+
+		tokens.refreshRepo.EXPECT().GetRefreshToken(saved.ID).Return(&saved, nil)
+		tokens.refreshRepo.EXPECT().RevokeRefreshTokenFamily(saved.FamilyID, gomock.Any()).Return(int64(1), nil)
+		tokens.accessRepo.EXPECT().RevokeAccessTokenFamily(saved.FamilyID, gomock.Any()).Return(int64(1), nil)
+
+		assert.NoError(t, opaqueService.Revoke(issued.RefreshToken, "app"))
+	})
+
+	t.Run("RevokeOpaqueAccessToken", func(t *testing.T) {
+		issued, err := opaqueService.IssueGrant(user, grant)
+		require.NoError(t, err)
+		saved := tokens.accessTokens[sha256Hex(issued.AccessToken)]
+
+		tokens.refreshRepo.EXPECT().GetRefreshToken(saved.ID).Return(nil, repository.ErrNotFound)
+		tokens.accessRepo.EXPECT().GetAccessToken(saved.ID).Return(&saved, nil)
+		tokens.accessRepo.EXPECT().RevokeAccessToken(saved.ID, gomock.Any()).Return(nil)
+
+		assert.NoError(t, opaqueService.Revoke(issued.AccessToken, "app"))
+	})
+
+	t.Run("RevokeOtherClientsToken", func(t *testing.T) {
+		issued, err := jwtService.IssueGrant(user, grant)
+		require.NoError(t, err)
+		saved := tokens.refreshTokens[sha256Hex(issued.RefreshToken)]
+
+		tokens.refreshRepo.EXPECT().GetRefreshToken(saved.ID).Return(&saved, nil)
+
+		assert.ErrorIs(t, jwtService.Revoke(issued.RefreshToken, "other"), ErrTokenNotOwned)
+	})
+
+	t.Run("RevokeUnknown", func(t *testing.T) {
+		tokens.refreshRepo.EXPECT().GetRefreshToken(sha256Hex("unknown")).Return(nil, repository.ErrNotFound)
+		tokens.accessRepo.EXPECT().GetAccessToken(sha256Hex("unknown")).Return(nil, repository.ErrNotFound)
+
+		assert.NoError(t, jwtService.Revoke("unknown", "app"))
+	})
+
+	t.Run("RevokeJWT", func(t *testing.T) {
+		issued, err := jwtService.IssueGrant(user, grant)
+		require.NoError(t, err)
+
+		assert.NoError(t, jwtService.Revoke(issued.AccessToken, "app"))
+	})
+}
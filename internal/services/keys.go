@@ -0,0 +1,296 @@
+package services
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"login-with-oauth/internal/jwt"
+	"login-with-oauth/internal/keyring"
+	"login-with-oauth/internal/logger"
+	"login-with-oauth/internal/models"
+	"login-with-oauth/internal/repository"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrNoSigningKey = errors.New("no active signing key")
+
+// KeyManagerConfig controls key generation and rotation
+type KeyManagerConfig struct {
+	// Algorithm is used for new keys
+	Algorithm string
+	// RotationPeriod is how long a key signs before it is replaced
+	RotationPeriod time.Duration
+	// Overlap is how long a replaced key stays published. It must be longer
+	// than the lifetime of the tokens we sign.
+	Overlap time.Duration
+}
+
+// signingKey is a decrypted SigningKey
+type signingKey struct {
+	id        string
+	algorithm string
+	key       crypto.Signer
+	createdAt time.Time
+}
+
+// KeyManager signs tokens with the newest stored key and publishes the
+// public half of every key that may still have signed a valid token. Keys
+// are stored encrypted with a keyring.
+type KeyManager struct {
+	repository repository.SigningKeyRepository
+	keyring    *keyring.Keyring
+	config     KeyManagerConfig
+	mu         sync.RWMutex
+	active     *signingKey
+	published  jwt.KeySet
+	now        func() time.Time
+}
+
+// NewKeyManager loads the stored keys, creating the first key if there are
+// none
+func NewKeyManager(repo repository.SigningKeyRepository, keys *keyring.Keyring, config KeyManagerConfig) (*KeyManager, error) {
+	if !jwt.Supported(config.Algorithm) {
+		return nil, fmt.Errorf("unsupported signing algorithm %q", config.Algorithm)
+	}
+
+	manager := &KeyManager{
+		repository: repo,
+		keyring:    keys,
+		config:     config,
+		now:        time.Now,
+	}
+	if err := manager.Load(); err != nil {
+		return nil, err
+	}
+	if manager.activeKey() == nil {
+		if _, err := manager.Rotate(false); err != nil {
+			return nil, err
+		}
+	}
+
+	return manager, nil
+}
+
+// Sign implements TokenSigner with the active key
+func (m *KeyManager) Sign(claims any) (string, error) {
+	active := m.activeKey()
+	if active == nil {
+		return "", ErrNoSigningKey
+	}
+	return jwt.Sign(active.algorithm, active.id, active.key, claims)
+}
+
+// KeySet implements TokenSigner
+func (m *KeyManager) KeySet() jwt.KeySet {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.published
+}
+
+func (m *KeyManager) activeKey() *signingKey {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.active
+}
+
+// Load reads the keys from storage. Other instances' rotations are picked
+// up the next time it runs.
+func (m *KeyManager) Load() error {
+	stored, err := m.repository.ListSigningKeys()
+	if err != nil {
+		return err
+	}
+
+	now := m.now()
+	var active *signingKey
+	published := jwt.KeySet{Keys: []jwt.JWK{}}
+	for _, key := range stored {
+		if key.RetiredAt != nil && now.After(key.RetiredAt.Add(m.config.Overlap)) {
+			continue
+		}
+
+		decrypted, err := m.decrypt(key)
+		if err != nil {
+			return err
+		}
+
+		jwk, err := jwt.NewJWK(decrypted.key.Public(), key.ID, key.Algorithm)
+		if err != nil {
+			return fmt.Errorf("signing key %s: %v", key.ID, err)
+		}
+		published.Keys = append(published.Keys, jwk)
+
+		// Keys are listed oldest first, so the last unretired key wins
+		if key.RetiredAt == nil {
+			active = decrypted
+		}
+	}
+
+	m.mu.Lock()
+	m.active = active
+	m.published = published
+	m.mu.Unlock()
+
+	return nil
+}
+
+// Rotate creates a new active key and retires the current ones. After an
+// emergency rotation the old keys are deleted straight away, so tokens they
+// signed stop verifying. It returns the new key's ID.
+func (m *KeyManager) Rotate(emergency bool) (string, error) {
+	private, err := generateSigningKey(m.config.Algorithm)
+	if err != nil {
+		return "", err
+	}
+
+	jwk, err := jwt.NewJWK(private.Public(), "", m.config.Algorithm)
+	if err != nil {
+		return "", err
+	}
+	id, err := jwk.Thumbprint()
+	if err != nil {
+		return "", err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode signing key: %v", err)
+	}
+	encrypted, err := m.keyring.Seal(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), signingKeyData(id))
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt signing key: %v", err)
+	}
+
+	previous, err := m.repository.ListSigningKeys()
+	if err != nil {
+		return "", err
+	}
+
+	now := m.now()
+	if err := m.repository.CreateSigningKey(models.SigningKey{
+		ID:           id,
+		Algorithm:    m.config.Algorithm,
+		EncryptedKey: encrypted,
+		CreatedAt:    now,
+	}); err != nil {
+		return "", err
+	}
+
+	for _, key := range previous {
+		if emergency {
+			err = m.repository.DeleteSigningKey(key.ID)
+		} else if key.RetiredAt == nil {
+			err = m.repository.RetireSigningKey(key.ID, now)
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+
+	logger.Log.Info("Rotated signing keys, new key is " + id)
+
+	return id, m.Load()
+}
+
+// Maintain rotates the active key once it is older than the rotation
+// period and deletes keys whose overlap window has passed
+func (m *KeyManager) Maintain() error {
+	if err := m.Load(); err != nil {
+		return err
+	}
+
+	now := m.now()
+	if active := m.activeKey(); active == nil || now.Sub(active.createdAt) >= m.config.RotationPeriod {
+		if _, err := m.Rotate(false); err != nil {
+			return err
+		}
+	}
+
+	stored, err := m.repository.ListSigningKeys()
+	if err != nil {
+		return err
+	}
+	for _, key := range stored {
+		if key.RetiredAt != nil && now.After(key.RetiredAt.Add(m.config.Overlap)) {
+			if err := m.repository.DeleteSigningKey(key.ID); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Run calls Maintain every interval until ctx is done
+func (m *KeyManager) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := m.Maintain(); err != nil {
+				logger.Log.Error("Failed to maintain signing keys: " + err.Error())
+			}
+		}
+	}
+}
+
+func (m *KeyManager) decrypt(key models.SigningKey) (*signingKey, error) {
+	pemData, _, err := m.keyring.Open(key.EncryptedKey, signingKeyData(key.ID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt signing key %s: %v", key.ID, err)
+	}
+
+	private, err := jwt.ParsePrivateKey(pemData)
+	if err != nil {
+		return nil, fmt.Errorf("signing key %s: %v", key.ID, err)
+	}
+
+	return &signingKey{id: key.ID, algorithm: key.Algorithm, key: private, createdAt: key.CreatedAt}, nil
+}
+
+// signingKeyData binds an encrypted key to its kid, so that stored keys
+// cannot be swapped around
+func signingKeyData(id string) []byte {
+	return []byte("signing-key:" + id)
+}
+
+// generateSigningKey creates a private key for algorithm
+func generateSigningKey(algorithm string) (crypto.Signer, error) {
+	var key crypto.Signer
+	var err error
+	switch {
+	case strings.HasPrefix(algorithm, "RS"), strings.HasPrefix(algorithm, "PS"):
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	case algorithm == "ES256":
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case algorithm == "ES384":
+		key, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case algorithm == "ES512":
+		key, err = ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	case algorithm == "EdDSA":
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate signing key: %v", err)
+	}
+
+	return key, nil
+}
@@ -0,0 +1,108 @@
+package services
+
+import (
+	"login-with-oauth/internal/jwt"
+	"login-with-oauth/internal/repository"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyManager(t *testing.T) {
+	newManager := func(t *testing.T) (*KeyManager, string) {
+		dir := t.TempDir()
+		manager, err := NewKeyManager(repository.NewFileSigningKeyRepository(dir), testKeyring(t), KeyManagerConfig{
+			Algorithm:      "ES256",
+			RotationPeriod: 24 * time.Hour,
+			Overlap:        time.Hour,
+		})
+		require.NoError(t, err)
+		return manager, dir
+	}
+
+	sign := func(t *testing.T, manager *KeyManager) *jwt.Token {
+		raw, err := manager.Sign(jwt.Claims{Subject: "123"})
+		require.NoError(t, err)
+		token, err := jwt.Parse(raw)
+		require.NoError(t, err)
+		return token
+	}
+
+	t.Run("CreatesFirstKey", func(t *testing.T) {
+		manager, dir := newManager(t)
+
+		token := sign(t, manager)
+
+		assert.Equal(t, "ES256", token.Header.Algorithm)
+		assert.NotEmpty(t, token.Header.KeyID)
+		assert.NoError(t, manager.KeySet().VerifyWith(token))
+
+		stored, err := os.ReadFile(filepath.Join(dir, "signing_keys.json"))
+		require.NoError(t, err)
+		assert.NotContains(t, string(stored), "PRIVATE KEY", "keys are encrypted at rest")
+	})
+
+	t.Run("Rotate", func(t *testing.T) {
+		manager, _ := newManager(t)
+		before := sign(t, manager)
+
+		id, err := manager.Rotate(false)
+		require.NoError(t, err)
+		after := sign(t, manager)
+
+		assert.Equal(t, id, after.Header.KeyID)
+		assert.NotEqual(t, before.Header.KeyID, after.Header.KeyID)
+		assert.Len(t, manager.KeySet().Keys, 2)
+		assert.NoError(t, manager.KeySet().VerifyWith(before), "the previous key stays published")
+	})
+
+	t.Run("OverlapEnds", func(t *testing.T) {
+		manager, _ := newManager(t)
+		before := sign(t, manager)
+		_, err := manager.Rotate(false)
+		require.NoError(t, err)
+
+		manager.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+		require.NoError(t, manager.Maintain())
+
+		assert.Len(t, manager.KeySet().Keys, 1)
+		assert.ErrorIs(t, manager.KeySet().VerifyWith(before), jwt.ErrKeyNotFound)
+	})
+
+	t.Run("ScheduledRotation", func(t *testing.T) {
+		manager, _ := newManager(t)
+		before := sign(t, manager)
+
+		manager.now = func() time.Time { return time.Now().Add(25 * time.Hour) }
+		require.NoError(t, manager.Maintain())
+
+		assert.NotEqual(t, before.Header.KeyID, sign(t, manager).Header.KeyID)
+	})
+
+	t.Run("Emergency", func(t *testing.T) {
+		manager, _ := newManager(t)
+		before := sign(t, manager)
+
+		_, err := manager.Rotate(true)
+		require.NoError(t, err)
+
+		assert.Len(t, manager.KeySet().Keys, 1)
+		assert.ErrorIs(t, manager.KeySet().VerifyWith(before), jwt.ErrKeyNotFound)
+	})
+
+	t.Run("SharedStorage", func(t *testing.T) {
+		manager, dir := newManager(t)
+		other, err := NewKeyManager(repository.NewFileSigningKeyRepository(dir), testKeyring(t), manager.config)
+		require.NoError(t, err)
+
+		id, err := other.Rotate(false)
+		require.NoError(t, err)
+		require.NoError(t, manager.Load())
+
+		assert.Equal(t, id, sign(t, manager).Header.KeyID)
+	})
+}
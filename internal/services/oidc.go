@@ -0,0 +1,147 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"login-with-oauth/internal/jwt"
+	"login-with-oauth/internal/models"
+	"slices"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const (
+	// idTokenLeeway tolerates clock skew between us and the provider
+	idTokenLeeway = time.Minute
+	// idTokenMaxAge rejects ID tokens issued too long before we see them
+	idTokenMaxAge = 10 * time.Minute
+)
+
+var (
+	ErrIDTokenMissing = errors.New("token response has no id_token")
+	ErrInvalidIDToken = errors.New("invalid id_token")
+)
+
+// IDTokenClaims are the OpenID Connect claims we read from ID tokens and
+// userinfo responses
+type IDTokenClaims struct {
+	jwt.Claims
+	Nonce             string    `json:"nonce,omitempty"`
+	AuthTime          int64     `json:"auth_time,omitempty"`
+	AuthorizedParty   string    `json:"azp,omitempty"`
+	Email             string    `json:"email,omitempty"`
+	EmailVerified     claimBool `json:"email_verified,omitempty"`
+	Name              string    `json:"name,omitempty"`
+	PreferredUsername string    `json:"preferred_username,omitempty"`
+	Picture           string    `json:"picture,omitempty"`
+	HostedDomain      string    `json:"hd,omitempty"`
+}
+
+// claimBool accepts both JSON booleans and the "true"/"false" strings some
+// providers send for boolean claims
+type claimBool bool
+
+func (b *claimBool) UnmarshalJSON(data []byte) error {
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	switch v := value.(type) {
+	case bool:
+		*b = claimBool(v)
+	case string:
+		*b = claimBool(v == "true")
+	}
+	return nil
+}
+
+// IDTokenVerifier validates ID tokens issued to one client by one provider
+type IDTokenVerifier struct {
+	keySet     *jwt.RemoteKeySet
+	clientID   string
+	issuers    []string
+	algorithms []string
+	now        func() time.Time
+}
+
+// NewIDTokenVerifier creates a verifier accepting RS256 ID tokens for clientID
+// from any of the given issuers, checked against keys from keySet
+func NewIDTokenVerifier(keySet *jwt.RemoteKeySet, clientID string, issuers ...string) *IDTokenVerifier {
+	return &IDTokenVerifier{
+		keySet:     keySet,
+		clientID:   clientID,
+		issuers:    issuers,
+		algorithms: []string{"RS256"},
+		now:        time.Now,
+	}
+}
+
+// Verify checks the signature, issuer, audience, lifetime and nonce of
+// rawIDToken and returns its claims
+func (v *IDTokenVerifier) Verify(ctx context.Context, rawIDToken, nonce string) (*IDTokenClaims, error) {
+	token, err := jwt.Parse(rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+	if !slices.Contains(v.algorithms, token.Header.Algorithm) {
+		return nil, fmt.Errorf("%w: unexpected signing algorithm %q", ErrInvalidIDToken, token.Header.Algorithm)
+	}
+	if err := v.keySet.Verify(ctx, token); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+
+	var claims IDTokenClaims
+	if err := token.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+
+	now := v.now()
+	switch {
+	case !slices.Contains(v.issuers, claims.Issuer):
+		return nil, fmt.Errorf("%w: unexpected issuer %q", ErrInvalidIDToken, claims.Issuer)
+	case !claims.Audience.Contains(v.clientID):
+		return nil, fmt.Errorf("%w: token was not issued to this client", ErrInvalidIDToken)
+	case len(claims.Audience) > 1 && claims.AuthorizedParty != v.clientID:
+		return nil, fmt.Errorf("%w: azp does not match this client", ErrInvalidIDToken)
+	case claims.Subject == "":
+		return nil, fmt.Errorf("%w: missing subject", ErrInvalidIDToken)
+	case claims.ExpiresAt == 0 || now.After(time.Unix(claims.ExpiresAt, 0).Add(idTokenLeeway)):
+		return nil, fmt.Errorf("%w: token has expired", ErrInvalidIDToken)
+	case claims.IssuedAt == 0 || time.Unix(claims.IssuedAt, 0).After(now.Add(idTokenLeeway)):
+		return nil, fmt.Errorf("%w: token is issued in the future", ErrInvalidIDToken)
+	case now.Sub(time.Unix(claims.IssuedAt, 0)) > idTokenMaxAge:
+		return nil, fmt.Errorf("%w: token was issued too long ago", ErrInvalidIDToken)
+	case claims.NotBefore != 0 && time.Unix(claims.NotBefore, 0).After(now.Add(idTokenLeeway)):
+		return nil, fmt.Errorf("%w: token is not valid yet", ErrInvalidIDToken)
+	case claims.Nonce != nonce:
+		return nil, fmt.Errorf("%w: nonce does not match", ErrInvalidIDToken)
+	}
+
+	return &claims, nil
+}
+
+// NonceOption adds the OpenID Connect nonce to the authorize redirect
+func NonceOption(nonce string) oauth2.AuthCodeOption {
+	return oauth2.SetAuthURLParam("nonce", nonce)
+}
+
+// identityFromClaims maps verified OpenID Connect claims onto an identity
+func identityFromClaims(provider string, claims *IDTokenClaims, username string) (*models.Identity, error) {
+	raw, err := json.Marshal(claims)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode claims: %v", err)
+	}
+
+	return &models.Identity{
+		Provider:      provider,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: bool(claims.EmailVerified),
+		Username:      username,
+		AvatarURL:     claims.Picture,
+		RawProfile:    raw,
+	}, nil
+}
@@ -0,0 +1,219 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"login-with-oauth/internal/jwt"
+	"login-with-oauth/internal/models"
+	"net/http"
+	"slices"
+
+	"golang.org/x/oauth2"
+)
+
+// OIDCService logs users in with any OpenID Connect provider, using the
+// endpoints from the provider's discovery document
+type OIDCService struct {
+	name        string
+	displayName string
+	config      *oauth2.Config
+	metadata    *ProviderMetadata
+	pkce        PKCEMode
+	options     AuthOptions
+	httpClient  *http.Client
+	verifier    *IDTokenVerifier
+}
+
+// NewOIDCService discovers the provider configured in cfg. A nil client
+// means http.DefaultClient.
+func NewOIDCService(ctx context.Context, cfg ProviderConfig, client *http.Client) (*OIDCService, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	metadata, err := Discover(ctx, client, cfg.Issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	pkce, err := ParsePKCEMode(cfg.PKCE)
+	if err != nil {
+		return nil, err
+	}
+	pkce, err = pkce.Resolve(metadata.CodeChallengeMethodsSupported)
+	if err != nil {
+		return nil, fmt.Errorf("provider %s: %v", cfg.Name, err)
+	}
+
+	scopes := cfg.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"email", "profile"}
+	}
+	if !slices.Contains(scopes, "openid") {
+		scopes = append([]string{"openid"}, scopes...)
+	}
+
+	authStyle := oauth2.AuthStyleInHeader
+	if methods := metadata.TokenEndpointAuthMethodsSupported; len(methods) > 0 &&
+		!slices.Contains(methods, "client_secret_basic") && slices.Contains(methods, "client_secret_post") {
+		authStyle = oauth2.AuthStyleInParams
+	}
+
+	config := &oauth2.Config{
+		ClientID:     cfg.ClientID,
+		ClientSecret: cfg.ClientSecret,
+		RedirectURL:  cfg.RedirectURL,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:   metadata.AuthorizationEndpoint,
+			TokenURL:  metadata.TokenEndpoint,
+			AuthStyle: authStyle,
+		},
+		Scopes: scopes,
+	}
+
+	verifier := NewIDTokenVerifier(jwt.NewRemoteKeySet(metadata.JWKSURI, client), cfg.ClientID, metadata.Issuer)
+	if algorithms := supportedAlgorithms(metadata.IDTokenSigningAlgValuesSupported); len(algorithms) > 0 {
+		verifier.algorithms = algorithms
+	}
+
+	displayName := cfg.DisplayName
+	if displayName == "" {
+		displayName = cfg.Name
+	}
+
+	return &OIDCService{
+		name:        cfg.Name,
+		displayName: displayName,
+		config:      config,
+		metadata:    metadata,
+		pkce:        pkce,
+		options:     cfg.AuthOptions,
+		httpClient:  client,
+		verifier:    verifier,
+	}, nil
+}
+
+// supportedAlgorithms keeps the advertised algorithms we can verify
+func supportedAlgorithms(advertised []string) []string {
+	var algorithms []string
+	for _, alg := range advertised {
+		if jwt.Supported(alg) {
+			algorithms = append(algorithms, alg)
+		}
+	}
+	return algorithms
+}
+
+// Name returns the configured provider name
+func (s *OIDCService) Name() string {
+	return s.name
+}
+
+// DisplayName is shown on the login page
+func (s *OIDCService) DisplayName() string {
+	return s.displayName
+}
+
+// PKCE returns the PKCE mode used for logins
+func (s *OIDCService) PKCE() PKCEMode {
+	return s.pkce
+}
+
+// AuthURL implements Provider
+func (s *OIDCService) AuthURL(state *models.OAuthState) string {
+	return authCodeURL(s.config, s.pkce, s.options, state, NonceOption(state.Nonce))
+}
+
+// Exchange implements Provider
+func (s *OIDCService) Exchange(ctx context.Context, code string, state *models.OAuthState) (*oauth2.Token, error) {
+	return exchangeCode(ctx, s.config, s.pkce, s.httpClient, code, state)
+}
+
+// TokenSource implements Provider
+func (s *OIDCService) TokenSource(ctx context.Context, token *oauth2.Token) oauth2.TokenSource {
+	return refreshingTokenSource(ctx, s.config, s.httpClient, token)
+}
+
+// FetchIdentity builds the identity from the verified ID token in token,
+// calling the userinfo endpoint only for standard claims the ID token lacks
+func (s *OIDCService) FetchIdentity(ctx context.Context, token *oauth2.Token, state *models.OAuthState) (*models.Identity, error) {
+	rawIDToken, _ := token.Extra("id_token").(string)
+	if rawIDToken == "" {
+		return nil, ErrIDTokenMissing
+	}
+
+	claims, err := s.verifier.Verify(ctx, rawIDToken, state.Nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	if (claims.Email == "" || (claims.Name == "" && claims.PreferredUsername == "")) && s.metadata.UserInfoEndpoint != "" {
+		if err := s.fillFromUserInfo(ctx, token, claims); err != nil {
+			return nil, err
+		}
+	}
+
+	username := claims.PreferredUsername
+	if username == "" {
+		username = claims.Name
+	}
+	if username == "" {
+		username = claims.Email
+	}
+
+	return identityFromClaims(s.name, claims, username)
+}
+
+// fillFromUserInfo completes missing claims from the userinfo endpoint. The
+// response is only trusted if it describes the ID token's subject.
+func (s *OIDCService) fillFromUserInfo(ctx context.Context, token *oauth2.Token, claims *IDTokenClaims) error {
+	client := s.config.Client(context.WithValue(ctx, oauth2.HTTPClient, s.httpClient), token)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", s.metadata.UserInfoEndpoint, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to make request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response body: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s userinfo request failed with status: %d, body: %s", s.name, resp.StatusCode, string(body))
+	}
+
+	var userInfo IDTokenClaims
+	if err := json.Unmarshal(body, &userInfo); err != nil {
+		return fmt.Errorf("failed to decode JSON: %v, body: %s", err, string(body))
+	}
+
+	if userInfo.Subject != claims.Subject {
+		return fmt.Errorf("userinfo subject %q does not match id_token subject %q", userInfo.Subject, claims.Subject)
+	}
+
+	if claims.Email == "" {
+		claims.Email = userInfo.Email
+		claims.EmailVerified = userInfo.EmailVerified
+	}
+	if claims.Name == "" {
+		claims.Name = userInfo.Name
+	}
+	if claims.PreferredUsername == "" {
+		claims.PreferredUsername = userInfo.PreferredUsername
+	}
+	if claims.Picture == "" {
+		claims.Picture = userInfo.Picture
+	}
+
+	return nil
+}
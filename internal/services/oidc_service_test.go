@@ -0,0 +1,96 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"login-with-oauth/internal/models"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestDiscover(t *testing.T) {
+	issuer := newTestIssuer(t)
+
+	t.Run("Valid", func(t *testing.T) {
+		metadata, err := Discover(context.Background(), issuer.server.Client(), issuer.server.URL)
+
+		assert.NoError(t, err)
+		assert.Equal(t, issuer.server.URL+"/token", metadata.TokenEndpoint)
+		assert.Equal(t, issuer.server.URL+"/oauth2/v3/certs", metadata.JWKSURI)
+	})
+
+	t.Run("IssuerMismatch", func(t *testing.T) {
+		impostor := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(ProviderMetadata{
+				Issuer:                issuer.server.URL,
+				AuthorizationEndpoint: issuer.server.URL + "/authorize",
+				TokenEndpoint:         issuer.server.URL + "/token",
+				JWKSURI:               issuer.server.URL + "/oauth2/v3/certs",
+			})
+		}))
+		defer impostor.Close()
+
+		_, err := Discover(context.Background(), impostor.Client(), impostor.URL)
+
+		assert.Error(t, err)
+	})
+}
+
+func TestOIDCService(t *testing.T) {
+	issuer := newTestIssuer(t)
+
+	service, err := NewOIDCService(context.Background(), ProviderConfig{
+		Name:        "okta",
+		Issuer:      issuer.server.URL,
+		ClientID:    "test-client-id",
+		RedirectURL: "http://localhost:8080/callback/okta",
+		PKCE:        "require",
+	}, issuer.server.Client())
+	require.NoError(t, err)
+
+	t.Run("Endpoints", func(t *testing.T) {
+		state := &models.OAuthState{State: "test-state", Nonce: "test-nonce"}
+		authURL := service.AuthURL(state)
+
+		assert.Equal(t, "okta", service.Name())
+		assert.NotEmpty(t, state.CodeVerifier)
+		assert.Contains(t, authURL, "nonce=test-nonce")
+		assert.Contains(t, authURL, issuer.server.URL+"/authorize?")
+		assert.Contains(t, authURL, "scope=openid+email+profile")
+		assert.Contains(t, authURL, "code_challenge_method=S256")
+		assert.Equal(t, []string{"RS256"}, service.verifier.algorithms)
+	})
+
+	t.Run("FetchIdentity", func(t *testing.T) {
+		issuer.userInfo = map[string]any{"sub": "1234567890", "preferred_username": "tuser"}
+		claims := validGoogleClaims()
+		claims["iss"] = issuer.server.URL
+		delete(claims, "name")
+		token := (&oauth2.Token{AccessToken: "test-token"}).WithExtra(map[string]any{
+			"id_token": issuer.sign(t, claims),
+		})
+
+		identity, err := service.FetchIdentity(context.Background(), token, &models.OAuthState{Nonce: "test-nonce"})
+
+		assert.NoError(t, err)
+		assert.Equal(t, "okta", identity.Provider)
+		assert.Equal(t, "1234567890", identity.Subject)
+		assert.Equal(t, "tuser", identity.Username)
+		assert.Equal(t, "test@example.com", identity.Email)
+		assert.True(t, identity.EmailVerified)
+	})
+
+	t.Run("PKCERequiredWithoutS256", func(t *testing.T) {
+		_, err := PKCERequired.Resolve([]string{"plain"})
+		assert.Error(t, err)
+
+		mode, err := PKCEPreferred.Resolve([]string{"plain"})
+		assert.NoError(t, err)
+		assert.Equal(t, PKCEDisabled, mode)
+	})
+}
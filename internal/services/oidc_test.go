@@ -0,0 +1,278 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"login-with-oauth/internal/jwt"
+	"login-with-oauth/internal/models"
+	mocks "login-with-oauth/internal/repository/mock"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+// testIssuer is a fake OpenID provider serving its JWKS and a userinfo
+// endpoint from a local httptest server
+type testIssuer struct {
+	server   *httptest.Server
+	key      *rsa.PrivateKey
+	userInfo map[string]any
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	issuer := &testIssuer{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/v3/certs", func(w http.ResponseWriter, r *http.Request) {
+		jwk, _ := jwt.NewJWK(key.Public(), "test-key", "RS256")
+		json.NewEncoder(w).Encode(jwt.KeySet{Keys: []jwt.JWK{jwk}})
+	})
+	mux.HandleFunc("/oauth2/v2/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(issuer.userInfo)
+	})
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(ProviderMetadata{
+			Issuer:                           issuer.server.URL,
+			AuthorizationEndpoint:            issuer.server.URL + "/authorize",
+			TokenEndpoint:                    issuer.server.URL + "/token",
+			UserInfoEndpoint:                 issuer.server.URL + "/oauth2/v2/userinfo",
+			JWKSURI:                          issuer.server.URL + "/oauth2/v3/certs",
+			IDTokenSigningAlgValuesSupported: []string{"RS256", "HS256"},
+			CodeChallengeMethodsSupported:    []string{"plain", "S256"},
+		})
+	})
+	issuer.server = httptest.NewServer(mux)
+	t.Cleanup(issuer.server.Close)
+
+	return issuer
+}
+
+// client returns an http.Client that sends every request to the fake issuer
+func (i *testIssuer) client() *http.Client {
+	return &http.Client{Transport: &rewriteTransport{target: i.server}}
+}
+
+func (i *testIssuer) sign(t *testing.T, claims map[string]any) string {
+	t.Helper()
+
+	raw, err := jwt.Sign("RS256", "test-key", i.key, claims)
+	require.NoError(t, err)
+	return raw
+}
+
+// rewriteTransport redirects requests for any host to a test server
+type rewriteTransport struct {
+	target *httptest.Server
+}
+
+func (t *rewriteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req.URL.Scheme = "http"
+	req.URL.Host = strings.TrimPrefix(t.target.URL, "http://")
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func validGoogleClaims() map[string]any {
+	now := time.Now()
+	return map[string]any{
+		"iss":            "https://accounts.google.com",
+		"aud":            "test-client-id",
+		"sub":            "1234567890",
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Hour).Unix(),
+		"nonce":          "test-nonce",
+		"email":          "test@example.com",
+		"email_verified": true,
+		"name":           "Test User",
+		"picture":        "https://example.com/avatar.jpg",
+	}
+}
+
+func TestIDTokenVerifier(t *testing.T) {
+	issuer := newTestIssuer(t)
+	verifier := NewIDTokenVerifier(
+		jwt.NewRemoteKeySet(issuer.server.URL+"/oauth2/v3/certs", issuer.server.Client()),
+		"test-client-id",
+		googleIssuers...,
+	)
+
+	t.Run("Valid", func(t *testing.T) {
+		claims, err := verifier.Verify(context.Background(), issuer.sign(t, validGoogleClaims()), "test-nonce")
+
+		assert.NoError(t, err)
+		assert.Equal(t, "1234567890", claims.Subject)
+		assert.Equal(t, "test@example.com", claims.Email)
+		assert.True(t, bool(claims.EmailVerified))
+	})
+
+	invalid := map[string]func(claims map[string]any){
+		"WrongIssuer":   func(c map[string]any) { c["iss"] = "https://evil.example.com" },
+		"WrongAudience": func(c map[string]any) { c["aud"] = "other-client" },
+		"WrongAzp":      func(c map[string]any) { c["aud"] = []string{"test-client-id", "other"}; c["azp"] = "other" },
+		"Expired":       func(c map[string]any) { c["exp"] = time.Now().Add(-time.Hour).Unix() },
+		"FutureIat":     func(c map[string]any) { c["iat"] = time.Now().Add(time.Hour).Unix() },
+		"StaleIat":      func(c map[string]any) { c["iat"] = time.Now().Add(-time.Hour).Unix() },
+		"WrongNonce":    func(c map[string]any) { c["nonce"] = "replayed-nonce" },
+		"MissingSub":    func(c map[string]any) { delete(c, "sub") },
+	}
+	for name, mutate := range invalid {
+		t.Run(name, func(t *testing.T) {
+			claims := validGoogleClaims()
+			mutate(claims)
+
+			_, err := verifier.Verify(context.Background(), issuer.sign(t, claims), "test-nonce")
+
+			assert.ErrorIs(t, err, ErrInvalidIDToken)
+		})
+	}
+
+	t.Run("ForeignKey", func(t *testing.T) {
+		other := newTestIssuer(t)
+
+		_, err := verifier.Verify(context.Background(), other.sign(t, validGoogleClaims()), "test-nonce")
+
+		assert.ErrorIs(t, err, ErrInvalidIDToken)
+	})
+}
+
+func TestGoogleGetUserData(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	issuer := newTestIssuer(t)
+	mockRepo := mocks.NewMockUserRepository(ctrl)
+	service := NewGoogleService("test-client-id", "test-client-secret", mockRepo)
+	service.SetHTTPClient(issuer.client())
+
+	saveUser := func(identity models.Identity, user models.User) (*models.User, error) {
+		assert.Equal(t, "google", identity.Provider)
+		assert.Equal(t, "1234567890", identity.Subject)
+		assert.NotEmpty(t, identity.ID)
+		return &user, nil
+	}
+
+	t.Run("FromIDToken", func(t *testing.T) {
+		mockRepo.EXPECT().ResolveIdentity(gomock.Any(), gomock.Any()).DoAndReturn(saveUser)
+		token := (&oauth2.Token{AccessToken: "test-token"}).WithExtra(map[string]any{
+			"id_token": issuer.sign(t, validGoogleClaims()),
+		})
+
+		user, err := service.GetUserData(context.Background(), token, "test-nonce")
+
+		assert.NoError(t, err)
+		// Users get their own IDs rather than the provider's subject
+		assert.Regexp(t, `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, user.ID)
+		assert.Equal(t, "test@example.com", user.Email)
+		assert.Equal(t, "Test User", user.Username)
+		// A first login is counted on the new user
+		assert.Equal(t, 1, user.LoginCount)
+		require.NotNil(t, user.LastLoginAt)
+		assert.Equal(t, user.CreatedAt, *user.LastLoginAt)
+	})
+
+	t.Run("FallsBackToUserInfo", func(t *testing.T) {
+		mockRepo.EXPECT().ResolveIdentity(gomock.Any(), gomock.Any()).DoAndReturn(saveUser)
+		issuer.userInfo = map[string]any{"id": "1234567890", "email": "test@example.com", "name": "From Userinfo", "verified_email": true}
+		claims := validGoogleClaims()
+		delete(claims, "name")
+		token := (&oauth2.Token{AccessToken: "test-token"}).WithExtra(map[string]any{
+			"id_token": issuer.sign(t, claims),
+		})
+
+		user, err := service.GetUserData(context.Background(), token, "test-nonce")
+
+		assert.NoError(t, err)
+		assert.Equal(t, "From Userinfo", user.Username)
+	})
+
+	t.Run("UserInfoForOtherSubject", func(t *testing.T) {
+		issuer.userInfo = map[string]any{"id": "someone-else", "name": "Mallory"}
+		claims := validGoogleClaims()
+		delete(claims, "name")
+		token := (&oauth2.Token{AccessToken: "test-token"}).WithExtra(map[string]any{
+			"id_token": issuer.sign(t, claims),
+		})
+
+		_, err := service.GetUserData(context.Background(), token, "test-nonce")
+
+		assert.Error(t, err)
+	})
+
+	t.Run("MissingIDToken", func(t *testing.T) {
+		_, err := service.GetUserData(context.Background(), &oauth2.Token{AccessToken: "test-token"}, "test-nonce")
+
+		assert.ErrorIs(t, err, ErrIDTokenMissing)
+	})
+}
+
+func TestGoogleHostedDomains(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	issuer := newTestIssuer(t)
+	service := NewGoogleService("test-client-id", "test-client-secret", mocks.NewMockUserRepository(ctrl))
+	service.SetHTTPClient(issuer.client())
+	service.SetHostedDomains("Example.com")
+
+	workspaceClaims := func() map[string]any {
+		claims := validGoogleClaims()
+		claims["hd"] = "example.com"
+		return claims
+	}
+	fetch := func(claims map[string]any) (*models.Identity, error) {
+		token := (&oauth2.Token{AccessToken: "test-token"}).WithExtra(map[string]any{
+			"id_token": issuer.sign(t, claims),
+		})
+		return service.FetchIdentity(context.Background(), token, &models.OAuthState{Nonce: "test-nonce"})
+	}
+
+	t.Run("AuthURLHint", func(t *testing.T) {
+		authURL, err := url.Parse(service.AuthURL(&models.OAuthState{State: "test-state"}))
+		require.NoError(t, err)
+		assert.Equal(t, "example.com", authURL.Query().Get("hd"))
+
+		service.SetHostedDomains("example.com", "example.org")
+		defer service.SetHostedDomains("example.com")
+
+		authURL, err = url.Parse(service.AuthURL(&models.OAuthState{State: "test-state"}))
+		require.NoError(t, err)
+		assert.Equal(t, "*", authURL.Query().Get("hd"))
+	})
+
+	t.Run("Allowed", func(t *testing.T) {
+		identity, err := fetch(workspaceClaims())
+
+		require.NoError(t, err)
+		assert.Equal(t, "test@example.com", identity.Email)
+	})
+
+	denied := map[string]func(claims map[string]any){
+		"ConsumerAccount":  func(c map[string]any) { delete(c, "hd") },
+		"OtherDomain":      func(c map[string]any) { c["hd"] = "other.com"; c["email"] = "test@other.com" },
+		"UnverifiedEmail":  func(c map[string]any) { c["email_verified"] = false },
+		"EmailOfOtherHost": func(c map[string]any) { c["email"] = "test@other.com" },
+	}
+	for name, mutate := range denied {
+		t.Run(name, func(t *testing.T) {
+			claims := workspaceClaims()
+			mutate(claims)
+
+			_, err := fetch(claims)
+
+			assert.ErrorIs(t, err, ErrHostedDomainNotAllowed)
+		})
+	}
+}
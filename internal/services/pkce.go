@@ -0,0 +1,81 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"golang.org/x/oauth2"
+)
+
+// PKCEMode controls whether a provider's authorization-code flow uses PKCE
+type PKCEMode string
+
+const (
+	// PKCERequired always sends a S256 challenge and refuses to exchange a
+	// code without the matching verifier
+	PKCERequired PKCEMode = "require"
+	// PKCEPreferred sends a S256 challenge but still exchanges codes whose
+	// login was started without one
+	PKCEPreferred PKCEMode = "prefer"
+	// PKCEDisabled never uses PKCE
+	PKCEDisabled PKCEMode = "disable"
+)
+
+var ErrPKCEVerifierMissing = errors.New("PKCE is required but the login has no code verifier")
+
+// ParsePKCEMode parses a configured PKCE mode, defaulting to PKCEPreferred
+func ParsePKCEMode(value string) (PKCEMode, error) {
+	switch mode := PKCEMode(value); mode {
+	case "":
+		return PKCEPreferred, nil
+	case PKCERequired, PKCEPreferred, PKCEDisabled:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown PKCE mode %q, expected require, prefer or disable", value)
+	}
+}
+
+// NewVerifier returns a fresh code verifier, or an empty string when PKCE is disabled
+func (m PKCEMode) NewVerifier() string {
+	if m == PKCEDisabled {
+		return ""
+	}
+	return oauth2.GenerateVerifier()
+}
+
+// AuthCodeOptions returns the options that add the S256 code_challenge for
+// verifier to the authorize redirect
+func (m PKCEMode) AuthCodeOptions(verifier string) []oauth2.AuthCodeOption {
+	if m == PKCEDisabled || verifier == "" {
+		return nil
+	}
+	return []oauth2.AuthCodeOption{oauth2.S256ChallengeOption(verifier)}
+}
+
+// ExchangeOptions returns the options that send verifier with the token request
+func (m PKCEMode) ExchangeOptions(verifier string) ([]oauth2.AuthCodeOption, error) {
+	if m == PKCEDisabled {
+		return nil, nil
+	}
+	if verifier == "" {
+		if m == PKCERequired {
+			return nil, ErrPKCEVerifierMissing
+		}
+		return nil, nil
+	}
+	return []oauth2.AuthCodeOption{oauth2.VerifierOption(verifier)}, nil
+}
+
+// Resolve adapts the mode to the code_challenge_methods_supported a provider
+// advertises. An empty list means the provider did not say, in which case
+// the mode is kept as configured.
+func (m PKCEMode) Resolve(supportedMethods []string) (PKCEMode, error) {
+	if m == PKCEDisabled || len(supportedMethods) == 0 || slices.Contains(supportedMethods, "S256") {
+		return m, nil
+	}
+	if m == PKCERequired {
+		return "", errors.New("PKCE is required but the provider does not support S256")
+	}
+	return PKCEDisabled, nil
+}
@@ -0,0 +1,101 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"login-with-oauth/internal/models"
+	"login-with-oauth/internal/repository/mock"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestParsePKCEMode(t *testing.T) {
+	mode, err := ParsePKCEMode("")
+	assert.NoError(t, err)
+	assert.Equal(t, PKCEPreferred, mode)
+
+	mode, err = ParsePKCEMode("require")
+	assert.NoError(t, err)
+	assert.Equal(t, PKCERequired, mode)
+
+	_, err = ParsePKCEMode("sometimes")
+	assert.Error(t, err)
+}
+
+func TestPKCEModeOptions(t *testing.T) {
+	t.Run("Disabled", func(t *testing.T) {
+		assert.Empty(t, PKCEDisabled.NewVerifier())
+		assert.Empty(t, PKCEDisabled.AuthCodeOptions("verifier"))
+
+		opts, err := PKCEDisabled.ExchangeOptions("verifier")
+		assert.NoError(t, err)
+		assert.Empty(t, opts)
+	})
+
+	t.Run("PreferredWithoutVerifier", func(t *testing.T) {
+		opts, err := PKCEPreferred.ExchangeOptions("")
+		assert.NoError(t, err)
+		assert.Empty(t, opts)
+	})
+
+	t.Run("RequiredWithoutVerifier", func(t *testing.T) {
+		_, err := PKCERequired.ExchangeOptions("")
+		assert.ErrorIs(t, err, ErrPKCEVerifierMissing)
+	})
+}
+
+func TestGithubServicePKCE(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	service := NewGitHubService("test-client-id", "test-client-secret", mock.NewMockUserRepository(ctrl))
+	service.SetPKCEMode(PKCERequired)
+
+	state := &models.OAuthState{State: "test-state"}
+	authURL, err := url.Parse(service.AuthURL(state))
+	require.NoError(t, err)
+
+	verifier := state.CodeVerifier
+	require.NotEmpty(t, verifier)
+
+	sum := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	t.Run("AuthURLCarriesChallenge", func(t *testing.T) {
+		assert.Equal(t, challenge, authURL.Query().Get("code_challenge"))
+		assert.Equal(t, "S256", authURL.Query().Get("code_challenge_method"))
+	})
+
+	t.Run("ExchangeSendsVerifier", func(t *testing.T) {
+		var received url.Values
+		tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.ParseForm()
+			received = r.PostForm
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"access_token":"test-token","token_type":"bearer"}`))
+		}))
+		defer tokenServer.Close()
+
+		service.config.Endpoint = oauth2.Endpoint{TokenURL: tokenServer.URL}
+
+		token, err := service.Exchange(context.Background(), "test-code", state)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "test-token", token.AccessToken)
+		assert.Equal(t, verifier, received.Get("code_verifier"))
+	})
+
+	t.Run("ExchangeWithoutVerifier", func(t *testing.T) {
+		_, err := service.Exchange(context.Background(), "test-code", &models.OAuthState{State: "test-state"})
+
+		assert.ErrorIs(t, err, ErrPKCEVerifierMissing)
+	})
+}
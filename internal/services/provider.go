@@ -0,0 +1,279 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"login-with-oauth/internal/models"
+	"login-with-oauth/internal/repository"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// Provider is an upstream identity provider users can log in with
+type Provider interface {
+	// Name identifies the provider in routes and stored states
+	Name() string
+	// DisplayName is shown to users on the login page
+	DisplayName() string
+	// AuthURL records the per-login secrets (PKCE verifier, nonce) on state
+	// and returns the URL to send the browser to. It must be called before
+	// the state is issued.
+	AuthURL(state *models.OAuthState) string
+	// Exchange trades the callback code for a token
+	Exchange(ctx context.Context, code string, state *models.OAuthState) (*oauth2.Token, error)
+	// FetchIdentity describes the user the token was issued for
+	FetchIdentity(ctx context.Context, token *oauth2.Token, state *models.OAuthState) (*models.Identity, error)
+	// TokenSource returns token until it expires, then refreshes it with
+	// the provider
+	TokenSource(ctx context.Context, token *oauth2.Token) oauth2.TokenSource
+}
+
+// ProviderConfig describes a provider in the configuration file
+type ProviderConfig struct {
+	Name         string   `mapstructure:"name"`
+	DisplayName  string   `mapstructure:"displayName"`
+	Type         string   `mapstructure:"type"`
+	Issuer       string   `mapstructure:"issuer"`
+	ClientID     string   `mapstructure:"clientID"`
+	ClientSecret string   `mapstructure:"clientSecret"`
+	RedirectURL  string   `mapstructure:"redirectURL"`
+	Scopes       []string `mapstructure:"scopes"`
+	PKCE         string   `mapstructure:"pkce"`
+	// HostedDomains restricts google providers to Workspace accounts of
+	// these domains
+	HostedDomains []string `mapstructure:"hostedDomains"`
+	// Organizations and Teams ("org/team-slug") restrict github providers
+	// to their members
+	Organizations []string `mapstructure:"organizations"`
+	Teams         []string `mapstructure:"teams"`
+	AuthOptions   `mapstructure:",squash"`
+}
+
+// AuthOptions are the extra authorize parameters a provider is configured
+// with
+type AuthOptions struct {
+	// Offline asks for a refresh token with access_type=offline
+	Offline bool `mapstructure:"offline"`
+	// Prompt is sent on every login, e.g. consent or select_account
+	Prompt string `mapstructure:"prompt"`
+	// IncludeGrantedScopes asks Google to keep the scopes granted before
+	IncludeGrantedScopes bool `mapstructure:"includeGrantedScopes"`
+}
+
+// authCodeOptions are the parameters for the authorize URL of state. The
+// state's prompt and login hint win over the configured ones.
+func (o AuthOptions) authCodeOptions(state *models.OAuthState) []oauth2.AuthCodeOption {
+	var opts []oauth2.AuthCodeOption
+	if o.Offline {
+		opts = append(opts, oauth2.AccessTypeOffline)
+	}
+	prompt := o.Prompt
+	if state.Prompt != "" {
+		prompt = state.Prompt
+	}
+	if prompt != "" {
+		opts = append(opts, oauth2.SetAuthURLParam("prompt", prompt))
+	}
+	if o.IncludeGrantedScopes {
+		opts = append(opts, oauth2.SetAuthURLParam("include_granted_scopes", "true"))
+	}
+	if state.LoginHint != "" {
+		opts = append(opts, oauth2.SetAuthURLParam("login_hint", state.LoginHint))
+	}
+	return opts
+}
+
+// NewProvider builds the provider described by cfg. Type selects google,
+// github or oidc; oidc providers are discovered from their issuer.
+func NewProvider(ctx context.Context, cfg ProviderConfig, userRepository repository.UserRepository) (Provider, error) {
+	pkce, err := ParsePKCEMode(cfg.PKCE)
+	if err != nil {
+		return nil, fmt.Errorf("provider %s: %v", cfg.Name, err)
+	}
+
+	switch cfg.Type {
+	case "google":
+		service := NewGoogleService(cfg.ClientID, cfg.ClientSecret, userRepository)
+		applyProviderConfig(service.config, cfg)
+		service.pkce = pkce
+		service.options = cfg.AuthOptions
+		service.SetHostedDomains(cfg.HostedDomains...)
+		return service, nil
+	case "github":
+		service := NewGitHubService(cfg.ClientID, cfg.ClientSecret, userRepository)
+		applyProviderConfig(service.config, cfg)
+		service.pkce = pkce
+		service.options = cfg.AuthOptions
+		service.SetRequiredMemberships(cfg.Organizations, cfg.Teams)
+		return service, nil
+	case "oidc":
+		return NewOIDCService(ctx, cfg, nil)
+	default:
+		return nil, fmt.Errorf("provider %s: unknown type %q", cfg.Name, cfg.Type)
+	}
+}
+
+// applyProviderConfig overrides a built-in provider's defaults with
+// whatever the configuration sets
+func applyProviderConfig(config *oauth2.Config, cfg ProviderConfig) {
+	if cfg.RedirectURL != "" {
+		config.RedirectURL = cfg.RedirectURL
+	}
+	if len(cfg.Scopes) > 0 {
+		config.Scopes = cfg.Scopes
+	}
+}
+
+// Registry holds the providers users can log in with
+type Registry struct {
+	providers map[string]Provider
+	order     []string
+}
+
+// NewRegistry creates an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{providers: make(map[string]Provider)}
+}
+
+// Register adds a provider; names must be unique
+func (r *Registry) Register(provider Provider) error {
+	name := provider.Name()
+	if name == "" {
+		return fmt.Errorf("provider has no name")
+	}
+	if _, exists := r.providers[name]; exists {
+		return fmt.Errorf("provider %s is already registered", name)
+	}
+
+	r.providers[name] = provider
+	r.order = append(r.order, name)
+
+	return nil
+}
+
+// Get returns the provider registered under name
+func (r *Registry) Get(name string) (Provider, bool) {
+	provider, ok := r.providers[name]
+	return provider, ok
+}
+
+// Providers returns every provider in registration order
+func (r *Registry) Providers() []Provider {
+	providers := make([]Provider, 0, len(r.order))
+	for _, name := range r.order {
+		providers = append(providers, r.providers[name])
+	}
+	return providers
+}
+
+// authCodeURL is the AuthURL shared by all providers: it generates the PKCE
+// verifier for state and adds its challenge and the provider's options to
+// the authorize URL. A state that already has Scopes asks for those on top
+// of the provider's own, which the user granted before; providers only ask
+// the user about the new ones.
+func authCodeURL(config *oauth2.Config, pkce PKCEMode, options AuthOptions, state *models.OAuthState, opts ...oauth2.AuthCodeOption) string {
+	state.CodeVerifier = pkce.NewVerifier()
+	opts = append(opts, pkce.AuthCodeOptions(state.CodeVerifier)...)
+
+	if len(state.Scopes) > 0 {
+		incremental := *config
+		incremental.Scopes = slices.Clone(config.Scopes)
+		for _, scope := range state.Scopes {
+			if !slices.Contains(incremental.Scopes, scope) {
+				incremental.Scopes = append(incremental.Scopes, scope)
+			}
+		}
+		config = &incremental
+		options.IncludeGrantedScopes = true
+	}
+	state.Scopes = config.Scopes
+
+	opts = append(opts, options.authCodeOptions(state)...)
+	return config.AuthCodeURL(state.State, opts...)
+}
+
+// GrantedScopes are the scopes the provider granted with token. Providers
+// that do not say are assumed to have granted what state asked for.
+func GrantedScopes(token *oauth2.Token, state *models.OAuthState) []string {
+	// GitHub separates scopes with commas
+	scope, _ := token.Extra("scope").(string)
+	granted := strings.FieldsFunc(scope, func(r rune) bool {
+		return r == ' ' || r == ','
+	})
+	if len(granted) == 0 && state != nil {
+		return state.Scopes
+	}
+	return granted
+}
+
+// exchangeCode is the Exchange shared by all providers: it sends the PKCE
+// verifier remembered in state along with the code
+func exchangeCode(ctx context.Context, config *oauth2.Config, pkce PKCEMode, client *http.Client, code string, state *models.OAuthState) (*oauth2.Token, error) {
+	opts, err := pkce.ExchangeOptions(state.CodeVerifier)
+	if err != nil {
+		return nil, err
+	}
+	if client != nil {
+		ctx = context.WithValue(ctx, oauth2.HTTPClient, client)
+	}
+	return config.Exchange(ctx, code, opts...)
+}
+
+// refreshingTokenSource is the TokenSource shared by all providers
+func refreshingTokenSource(ctx context.Context, config *oauth2.Config, client *http.Client, token *oauth2.Token) oauth2.TokenSource {
+	if client != nil {
+		ctx = context.WithValue(ctx, oauth2.HTTPClient, client)
+	}
+	return config.TokenSource(ctx, token)
+}
+
+// resolveUser returns the user identity logs in as, creating one with a new
+// ID if the identity is not linked to anybody yet. It fails with
+// ErrEmailInUse if it may not be merged with the owner of its email.
+func resolveUser(userRepository repository.UserRepository, identity *models.Identity) (*models.User, error) {
+	identityID, err := newUUID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate identity ID: %v", err)
+	}
+	userID, err := newUUID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate user ID: %v", err)
+	}
+
+	linked := *identity
+	linked.ID = identityID
+	linked.LinkedAt = time.Now()
+
+	user := userFromIdentity(identity)
+	user.ID = userID
+
+	savedUser, err := userRepository.ResolveIdentity(linked, user)
+	if errors.Is(err, repository.ErrEmailTaken) {
+		return nil, ErrEmailInUse
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s identity: %v", identity.Provider, err)
+	}
+	return savedUser, nil
+}
+
+// userFromIdentity maps an identity onto the users table as a user logging
+// in for the first time
+func userFromIdentity(identity *models.Identity) models.User {
+	now := time.Now()
+	return models.User{
+		Username:      identity.Username,
+		Email:         identity.Email,
+		EmailVerified: identity.EmailVerified,
+		AvatarURL:     identity.AvatarURL,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+		LastLoginAt:   &now,
+		LoginCount:    1,
+	}
+}
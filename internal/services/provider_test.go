@@ -0,0 +1,143 @@
+package services
+
+import (
+	"context"
+	"login-with-oauth/internal/models"
+	"login-with-oauth/internal/repository/mock"
+	"net/url"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestRegistry(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepo := mock.NewMockUserRepository(ctrl)
+	registry := NewRegistry()
+
+	github := NewGitHubService("gh-client", "gh-secret", mockRepo)
+	google := NewGoogleService("gl-client", "gl-secret", mockRepo)
+
+	require.NoError(t, registry.Register(github))
+	require.NoError(t, registry.Register(google))
+
+	t.Run("Duplicate", func(t *testing.T) {
+		assert.Error(t, registry.Register(NewGitHubService("other", "other", mockRepo)))
+	})
+
+	t.Run("Get", func(t *testing.T) {
+		provider, ok := registry.Get("google")
+		assert.True(t, ok)
+		assert.Equal(t, google, provider)
+
+		_, ok = registry.Get("unknown")
+		assert.False(t, ok)
+	})
+
+	t.Run("ProvidersInOrder", func(t *testing.T) {
+		providers := registry.Providers()
+		require.Len(t, providers, 2)
+		assert.Equal(t, "github", providers[0].Name())
+		assert.Equal(t, "google", providers[1].Name())
+	})
+}
+
+func TestNewProvider(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	t.Run("OverridesDefaults", func(t *testing.T) {
+		provider, err := NewProvider(context.Background(), ProviderConfig{
+			Name:        "github",
+			Type:        "github",
+			ClientID:    "gh-client",
+			RedirectURL: "http://localhost:8080/callback/github",
+			Scopes:      []string{"read:user"},
+			PKCE:        "require",
+		}, mock.NewMockUserRepository(ctrl))
+		require.NoError(t, err)
+
+		state := &models.OAuthState{State: "test-state"}
+		authURL, err := url.Parse(provider.AuthURL(state))
+		require.NoError(t, err)
+
+		assert.Equal(t, "http://localhost:8080/callback/github", authURL.Query().Get("redirect_uri"))
+		assert.Equal(t, "read:user", authURL.Query().Get("scope"))
+		assert.NotEmpty(t, state.CodeVerifier)
+	})
+
+	t.Run("AuthOptions", func(t *testing.T) {
+		provider, err := NewProvider(context.Background(), ProviderConfig{
+			Name:     "google",
+			Type:     "google",
+			ClientID: "gl-client",
+			AuthOptions: AuthOptions{
+				Offline:              true,
+				Prompt:               "select_account",
+				IncludeGrantedScopes: true,
+			},
+		}, mock.NewMockUserRepository(ctrl))
+		require.NoError(t, err)
+
+		authURL, err := url.Parse(provider.AuthURL(&models.OAuthState{State: "test-state"}))
+		require.NoError(t, err)
+
+		assert.Equal(t, "offline", authURL.Query().Get("access_type"))
+		assert.Equal(t, "select_account", authURL.Query().Get("prompt"))
+		assert.Equal(t, "true", authURL.Query().Get("include_granted_scopes"))
+		assert.Empty(t, authURL.Query().Get("login_hint"))
+
+		// A reconsent asks for consent for the identity's account
+		authURL, err = url.Parse(provider.AuthURL(&models.OAuthState{State: "test-state", Prompt: "consent", LoginHint: "test@example.com"}))
+		require.NoError(t, err)
+
+		assert.Equal(t, "consent", authURL.Query().Get("prompt"))
+		assert.Equal(t, "test@example.com", authURL.Query().Get("login_hint"))
+	})
+
+	t.Run("AdditionalScopes", func(t *testing.T) {
+		provider, err := NewProvider(context.Background(), ProviderConfig{
+			Name:     "github",
+			Type:     "github",
+			ClientID: "gh-client",
+		}, mock.NewMockUserRepository(ctrl))
+		require.NoError(t, err)
+
+		state := &models.OAuthState{State: "test-state", Scopes: []string{"repo"}}
+		authURL, err := url.Parse(provider.AuthURL(state))
+		require.NoError(t, err)
+
+		// The base scopes are still needed to identify the user
+		assert.Equal(t, "user:email user:avatar repo", authURL.Query().Get("scope"))
+		assert.Equal(t, "true", authURL.Query().Get("include_granted_scopes"))
+		assert.Equal(t, []string{"user:email", "user:avatar", "repo"}, state.Scopes)
+	})
+
+	t.Run("UnknownType", func(t *testing.T) {
+		_, err := NewProvider(context.Background(), ProviderConfig{Name: "x", Type: "saml"}, mock.NewMockUserRepository(ctrl))
+		assert.Error(t, err)
+	})
+}
+
+func TestGrantedScopes(t *testing.T) {
+	state := &models.OAuthState{Scopes: []string{"openid", "email"}}
+
+	t.Run("SpaceSeparated", func(t *testing.T) {
+		token := (&oauth2.Token{}).WithExtra(map[string]any{"scope": "openid email drive"})
+		assert.Equal(t, []string{"openid", "email", "drive"}, GrantedScopes(token, state))
+	})
+
+	t.Run("CommaSeparated", func(t *testing.T) {
+		token := (&oauth2.Token{}).WithExtra(map[string]any{"scope": "repo,user:email"})
+		assert.Equal(t, []string{"repo", "user:email"}, GrantedScopes(token, state))
+	})
+
+	t.Run("FallsBackToRequested", func(t *testing.T) {
+		assert.Equal(t, []string{"openid", "email"}, GrantedScopes(&oauth2.Token{}, state))
+	})
+}
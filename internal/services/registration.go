@@ -0,0 +1,247 @@
+package services
+
+import (
+	"crypto/subtle"
+	"errors"
+	"login-with-oauth/internal/models"
+	"slices"
+	"strings"
+)
+
+var (
+	ErrInvalidInitialAccessToken = errors.New("initial access token is invalid")
+	ErrInvalidRegistrationToken  = errors.New("registration access token is invalid")
+)
+
+// ClientMetadata is the client description of RFC 7591 section 2
+type ClientMetadata struct {
+	RedirectURIs            []string `json:"redirect_uris"`
+	ClientName              string   `json:"client_name,omitempty"`
+	GrantTypes              []string `json:"grant_types,omitempty"`
+	ResponseTypes           []string `json:"response_types,omitempty"`
+	TokenEndpointAuthMethod string   `json:"token_endpoint_auth_method,omitempty"`
+	Scope                   string   `json:"scope,omitempty"`
+}
+
+// RegistrationResponse is the client information response of RFC 7591
+// section 3.2.1. The secret and registration access token are only known
+// when they are issued, since we keep just their hashes.
+type RegistrationResponse struct {
+	ClientID              string `json:"client_id"`
+	ClientSecret          string `json:"client_secret,omitempty"`
+	ClientIDIssuedAt      int64  `json:"client_id_issued_at"`
+	ClientSecretExpiresAt *int64 `json:"client_secret_expires_at,omitempty"`
+	RegistrationToken     string `json:"registration_access_token,omitempty"`
+	RegistrationClientURI string `json:"registration_client_uri"`
+	ClientMetadata
+}
+
+// RegistrationService implements dynamic client registration (RFC 7591)
+// and registration management (RFC 7592) on top of the ClientService
+type RegistrationService struct {
+	clients *ClientService
+	// initialAccessTokenHashes are the hashes of the tokens that allow
+	// registering a client
+	initialAccessTokenHashes []string
+	issuer                   string
+}
+
+// NewRegistrationService creates a new RegistrationService that accepts any
+// of initialAccessTokens
+func NewRegistrationService(clients *ClientService, initialAccessTokens []string, issuer string) (*RegistrationService, error) {
+	if len(initialAccessTokens) == 0 {
+		return nil, errors.New("registration needs at least one initial access token")
+	}
+
+	s := &RegistrationService{clients: clients, issuer: strings.TrimSuffix(issuer, "/")}
+	for _, token := range initialAccessTokens {
+		s.initialAccessTokenHashes = append(s.initialAccessTokenHashes, sha256Hex(token))
+	}
+	return s, nil
+}
+
+// Register creates a client for a request carrying initialAccessToken
+func (s *RegistrationService) Register(initialAccessToken string, metadata ClientMetadata) (*RegistrationResponse, error) {
+	if !s.validInitialAccessToken(initialAccessToken) {
+		return nil, ErrInvalidInitialAccessToken
+	}
+
+	input, err := clientInputFromMetadata(metadata)
+	if err != nil {
+		return nil, err
+	}
+	if err := keepAPIScopes(&input, nil); err != nil {
+		return nil, err
+	}
+
+	client, secret, registrationToken, err := s.clients.RegisterClient(input)
+	if err != nil {
+		return nil, err
+	}
+
+	response := s.response(client)
+	response.ClientSecret = secret
+	response.RegistrationToken = registrationToken
+	return response, nil
+}
+
+// Read returns the registration of a client
+func (s *RegistrationService) Read(clientID, registrationToken string) (*RegistrationResponse, error) {
+	client, err := s.authenticate(clientID, registrationToken)
+	if err != nil {
+		return nil, err
+	}
+	return s.response(client), nil
+}
+
+// Update replaces the metadata of a client. Settings only admins can make,
+// like FirstParty and API scopes, are kept. A client that switches from none to a secret
+// authentication method gets its secret in the response.
+func (s *RegistrationService) Update(clientID, registrationToken string, metadata ClientMetadata) (*RegistrationResponse, error) {
+	current, err := s.authenticate(clientID, registrationToken)
+	if err != nil {
+		return nil, err
+	}
+
+	input, err := clientInputFromMetadata(metadata)
+	if err != nil {
+		return nil, err
+	}
+	input.FirstParty = current.FirstParty
+	if err := keepAPIScopes(&input, current.Scopes); err != nil {
+		return nil, err
+	}
+
+	client, secret, err := s.clients.UpdateClient(clientID, input)
+	if err != nil {
+		return nil, err
+	}
+
+	response := s.response(client)
+	response.ClientSecret = secret
+	return response, nil
+}
+
+// Delete removes a client
+func (s *RegistrationService) Delete(clientID, registrationToken string) error {
+	if _, err := s.authenticate(clientID, registrationToken); err != nil {
+		return err
+	}
+	return s.clients.DeleteClient(clientID)
+}
+
+// authenticate checks a registration access token. Unknown clients get the
+// same error as bad tokens, so that client ids cannot be probed.
+func (s *RegistrationService) authenticate(clientID, registrationToken string) (*models.Client, error) {
+	client, err := s.clients.GetClient(clientID)
+	if errors.Is(err, ErrClientNotFound) {
+		return nil, ErrInvalidRegistrationToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if registrationToken == "" || client.RegistrationTokenHash == "" ||
+		subtle.ConstantTimeCompare([]byte(sha256Hex(registrationToken)), []byte(client.RegistrationTokenHash)) != 1 {
+		return nil, ErrInvalidRegistrationToken
+	}
+
+	return client, nil
+}
+
+func (s *RegistrationService) validInitialAccessToken(token string) bool {
+	if token == "" {
+		return false
+	}
+	hash := []byte(sha256Hex(token))
+	valid := false
+	for _, expected := range s.initialAccessTokenHashes {
+		if subtle.ConstantTimeCompare(hash, []byte(expected)) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
+
+func (s *RegistrationService) response(client *models.Client) *RegistrationResponse {
+	response := &RegistrationResponse{
+		ClientID:              client.ID,
+		ClientIDIssuedAt:      client.CreatedAt.Unix(),
+		RegistrationClientURI: s.issuer + "/register/" + client.ID,
+		ClientMetadata: ClientMetadata{
+			RedirectURIs:            client.RedirectURIs,
+			ClientName:              client.Name,
+			GrantTypes:              client.GrantTypes,
+			ResponseTypes:           []string{"code"},
+			TokenEndpointAuthMethod: "client_secret_basic",
+			Scope:                   strings.Join(client.Scopes, " "),
+		},
+	}
+	if client.Public {
+		response.TokenEndpointAuthMethod = "none"
+	} else {
+		// Our secrets do not expire
+		never := int64(0)
+		response.ClientSecretExpiresAt = &never
+	}
+	return response
+}
+
+// keepAPIScopes gives input the API scopes of current, which only admins
+// assign, so clients can neither add nor drop them themselves
+func keepAPIScopes(input *ClientInput, current []string) error {
+	var scopes, kept []string
+	for _, scope := range input.Scopes {
+		if !isAPIScope(scope) {
+			scopes = append(scopes, scope)
+		} else if !slices.Contains(current, scope) {
+			return &ClientValidationError{"invalid_client_metadata", "scope " + scope + " is unknown or only assigned by admins"}
+		}
+	}
+	for _, scope := range current {
+		if isAPIScope(scope) {
+			kept = append(kept, scope)
+		}
+	}
+	if len(kept) == 0 {
+		return nil
+	}
+
+	if len(scopes) == 0 {
+		scopes = slices.Clone(supportedScopes)
+	}
+	input.Scopes = append(scopes, kept...)
+	return nil
+}
+
+// clientInputFromMetadata maps RFC 7591 metadata onto a ClientInput
+func clientInputFromMetadata(metadata ClientMetadata) (ClientInput, error) {
+	input := ClientInput{
+		Name:         metadata.ClientName,
+		RedirectURIs: metadata.RedirectURIs,
+		Scopes:       strings.Fields(metadata.Scope),
+		GrantTypes:   metadata.GrantTypes,
+	}
+	if input.Name == "" && len(metadata.RedirectURIs) > 0 {
+		input.Name = metadata.RedirectURIs[0]
+	}
+	if len(input.GrantTypes) == 0 {
+		input.GrantTypes = []string{"authorization_code"}
+	}
+
+	for _, responseType := range metadata.ResponseTypes {
+		if responseType != "code" {
+			return input, &ClientValidationError{"invalid_client_metadata", "unsupported response type " + responseType}
+		}
+	}
+
+	switch metadata.TokenEndpointAuthMethod {
+	case "", "client_secret_basic", "client_secret_post":
+	case "none":
+		input.Public = true
+	default:
+		return input, &ClientValidationError{"invalid_client_metadata", "unsupported token endpoint auth method " + metadata.TokenEndpointAuthMethod}
+	}
+
+	return input, nil
+}
@@ -0,0 +1,191 @@
+package services
+
+import (
+	"login-with-oauth/internal/models"
+	"login-with-oauth/internal/repository"
+	"login-with-oauth/internal/repository/mock"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistrationService(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	clientRepo := mock.NewMockClientRepository(ctrl)
+	clientService := NewClientService(clientRepo, time.Hour)
+	require.NoError(t, clientService.SetAPIScopes([]APIScope{{"orders:read", "See your orders"}}))
+	service, err := NewRegistrationService(clientService, []string{"initial"}, "https://auth.example.com/")
+	require.NoError(t, err)
+
+	// stored records the clients the service writes
+	stored := map[string]models.Client{}
+	clientRepo.EXPECT().CreateClient(gomock.Any()).DoAndReturn(func(client models.Client) error {
+		stored[client.ID] = client
+		return nil
+	}).AnyTimes()
+	clientRepo.EXPECT().UpdateClient(gomock.Any()).DoAndReturn(func(client models.Client) error {
+		stored[client.ID] = client
+		return nil
+	}).AnyTimes()
+	clientRepo.EXPECT().GetClient(gomock.Any()).DoAndReturn(func(id string) (*models.Client, error) {
+		client, ok := stored[id]
+		if !ok {
+			return nil, repository.ErrNotFound
+		}
+		return &client, nil
+	}).AnyTimes()
+	clientRepo.EXPECT().DeleteClient(gomock.Any()).DoAndReturn(func(id string) error {
+		delete(stored, id)
+		return nil
+	}).AnyTimes()
+
+	metadata := ClientMetadata{
+		RedirectURIs: []string{"https://preview-42.example.com/cb"},
+		ClientName:   "Preview 42",
+		Scope:        "openid email",
+	}
+
+	t.Run("InitialAccessToken", func(t *testing.T) {
+		_, err := service.Register("", metadata)
+		assert.ErrorIs(t, err, ErrInvalidInitialAccessToken)
+
+		_, err = service.Register("wrong", metadata)
+		assert.ErrorIs(t, err, ErrInvalidInitialAccessToken)
+	})
+
+	t.Run("Lifecycle", func(t *testing.T) {
+		registered, err := service.Register("initial", metadata)
+		require.NoError(t, err)
+
+		assert.NotEmpty(t, registered.ClientSecret)
+		assert.NotEmpty(t, registered.RegistrationToken)
+		assert.Equal(t, "https://auth.example.com/register/"+registered.ClientID, registered.RegistrationClientURI)
+		assert.Equal(t, "client_secret_basic", registered.TokenEndpointAuthMethod)
+		assert.Equal(t, []string{"authorization_code"}, registered.GrantTypes)
+		assert.Equal(t, "openid email", registered.Scope)
+
+		_, err = AuthenticateClient(service.clients, registered.ClientID, registered.ClientSecret)
+		assert.NoError(t, err, "registered clients land in the client store")
+
+		_, err = service.Read(registered.ClientID, "wrong")
+		assert.ErrorIs(t, err, ErrInvalidRegistrationToken)
+
+		read, err := service.Read(registered.ClientID, registered.RegistrationToken)
+		require.NoError(t, err)
+		assert.Empty(t, read.ClientSecret)
+		assert.Equal(t, metadata.RedirectURIs, read.RedirectURIs)
+
+		updated := metadata
+		updated.RedirectURIs = []string{"https://preview-43.example.com/cb"}
+		response, err := service.Update(registered.ClientID, registered.RegistrationToken, updated)
+		require.NoError(t, err)
+		assert.Equal(t, updated.RedirectURIs, response.RedirectURIs)
+
+		require.NoError(t, service.Delete(registered.ClientID, registered.RegistrationToken))
+		_, err = service.Read(registered.ClientID, registered.RegistrationToken)
+		assert.ErrorIs(t, err, ErrInvalidRegistrationToken)
+	})
+
+	t.Run("PublicClient", func(t *testing.T) {
+		public := metadata
+		public.TokenEndpointAuthMethod = "none"
+
+		registered, err := service.Register("initial", public)
+		require.NoError(t, err)
+
+		assert.Empty(t, registered.ClientSecret)
+		assert.Nil(t, registered.ClientSecretExpiresAt)
+		assert.Equal(t, "none", registered.TokenEndpointAuthMethod)
+	})
+
+	t.Run("UpdateKeepsFirstParty", func(t *testing.T) {
+		registered, err := service.Register("initial", metadata)
+		require.NoError(t, err)
+		// An admin marks the client as one of our own apps
+		client := stored[registered.ClientID]
+		client.FirstParty = true
+		stored[registered.ClientID] = client
+
+		_, err = service.Update(registered.ClientID, registered.RegistrationToken, metadata)
+		require.NoError(t, err)
+
+		assert.True(t, stored[registered.ClientID].FirstParty)
+	})
+
+	t.Run("APIScopesAreAssignedByAdmins", func(t *testing.T) {
+		withAPIScope := metadata
+		withAPIScope.Scope = "openid orders:read"
+		_, err := service.Register("initial", withAPIScope)
+		var validationErr *ClientValidationError
+		assert.ErrorAs(t, err, &validationErr)
+
+		registered, err := service.Register("initial", metadata)
+		require.NoError(t, err)
+		// An admin lets the client read orders
+		client := stored[registered.ClientID]
+		client.Scopes = append(client.Scopes, "orders:read")
+		stored[registered.ClientID] = client
+
+		response, err := service.Update(registered.ClientID, registered.RegistrationToken, metadata)
+		require.NoError(t, err)
+		assert.Equal(t, "openid email orders:read", response.Scope)
+	})
+
+	t.Run("UpdatePublicToConfidential", func(t *testing.T) {
+		public := metadata
+		public.TokenEndpointAuthMethod = "none"
+		registered, err := service.Register("initial", public)
+		require.NoError(t, err)
+
+		confidential := metadata
+		confidential.TokenEndpointAuthMethod = "client_secret_basic"
+		response, err := service.Update(registered.ClientID, registered.RegistrationToken, confidential)
+		require.NoError(t, err)
+
+		assert.Equal(t, "client_secret_basic", response.TokenEndpointAuthMethod)
+		require.NotEmpty(t, response.ClientSecret)
+		_, err = AuthenticateClient(service.clients, registered.ClientID, response.ClientSecret)
+		assert.NoError(t, err)
+
+		// Later updates keep the secret and do not show it again
+		response, err = service.Update(registered.ClientID, registered.RegistrationToken, confidential)
+		require.NoError(t, err)
+		assert.Empty(t, response.ClientSecret)
+	})
+
+	t.Run("InvalidMetadata", func(t *testing.T) {
+		tests := []struct {
+			name     string
+			metadata ClientMetadata
+			code     string
+		}{
+			{"NoRedirectURIs", ClientMetadata{ClientName: "x"}, "invalid_redirect_uri"},
+			{"HTTPRedirectURI", ClientMetadata{RedirectURIs: []string{"http://preview.example.com/cb"}}, "invalid_redirect_uri"},
+			{"ImplicitFlow", ClientMetadata{RedirectURIs: metadata.RedirectURIs, ResponseTypes: []string{"token"}}, "invalid_client_metadata"},
+			{"PrivateKeyJWT", ClientMetadata{RedirectURIs: metadata.RedirectURIs, TokenEndpointAuthMethod: "private_key_jwt"}, "invalid_client_metadata"},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				_, err := service.Register("initial", tt.metadata)
+
+				var validationErr *ClientValidationError
+				require.ErrorAs(t, err, &validationErr)
+				assert.Equal(t, tt.code, validationErr.Code)
+			})
+		}
+	})
+
+	t.Run("ManuallyCreatedClient", func(t *testing.T) {
+		client, _, err := service.clients.CreateClient(ClientInput{Name: "Admin", RedirectURIs: []string{"https://admin.example.com/cb"}})
+		require.NoError(t, err)
+
+		_, err = service.Read(client.ID, "")
+		assert.ErrorIs(t, err, ErrInvalidRegistrationToken)
+	})
+}
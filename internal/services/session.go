@@ -0,0 +1,172 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"login-with-oauth/internal/logger"
+	"login-with-oauth/internal/models"
+	"login-with-oauth/internal/repository"
+	"net"
+	"net/http"
+	"time"
+)
+
+const sessionCookieName = "session"
+
+// touchInterval limits how often a session's last-seen time is written back
+const touchInterval = time.Minute
+
+var (
+	ErrNoSession      = errors.New("no session")
+	ErrSessionExpired = errors.New("session has expired")
+)
+
+// SessionStore keeps sessions and the cookie that refers to them
+type SessionStore interface {
+	// Create persists a new session and sets the session cookie
+	Create(w http.ResponseWriter, r *http.Request, session *models.Session) error
+	// Get returns the session named by the request's session cookie
+	Get(r *http.Request) (*models.Session, error)
+	// Touch records the LastSeenAt of a session returned by Get
+	Touch(w http.ResponseWriter, r *http.Request, session *models.Session) error
+	// Destroy deletes the request's session and clears the cookie. Requests
+	// without a session cookie are left alone.
+	Destroy(w http.ResponseWriter, r *http.Request) error
+	// DeleteExpired removes what is kept for sessions that expired before
+	// the given time
+	DeleteExpired(before time.Time) (int64, error)
+	// RevokeUser ends every session of a user
+	RevokeUser(userID string) (int64, error)
+}
+
+// SessionService starts, loads and ends the sessions of logged-in users
+type SessionService struct {
+	store          SessionStore
+	userRepository repository.UserRepository
+	ttl            time.Duration
+	idleTimeout    time.Duration
+	now            func() time.Time
+}
+
+// NewSessionService creates a new SessionService. Sessions end ttl after
+// login, or after idleTimeout without a request if idleTimeout is set.
+func NewSessionService(store SessionStore, userRepository repository.UserRepository, ttl, idleTimeout time.Duration) *SessionService {
+	return &SessionService{
+		store:          store,
+		userRepository: userRepository,
+		ttl:            ttl,
+		idleTimeout:    idleTimeout,
+		now:            time.Now,
+	}
+}
+
+// Start logs user in on this browser. Any session the browser already had
+// is destroyed first so that a planted session cookie cannot be carried
+// over into the login.
+func (s *SessionService) Start(w http.ResponseWriter, r *http.Request, user *models.User, provider string) (*models.Session, error) {
+	if err := s.store.Destroy(w, r); err != nil {
+		logger.Log.Warn("Failed to destroy previous session: " + err.Error())
+	}
+
+	csrfToken, err := randomString(32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate csrf token: %v", err)
+	}
+
+	now := s.now()
+	session := &models.Session{
+		UserID:     user.ID,
+		Provider:   provider,
+		CSRFToken:  csrfToken,
+		IP:         clientIP(r),
+		UserAgent:  r.UserAgent(),
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(s.ttl),
+	}
+
+	if err := s.store.Create(w, r, session); err != nil {
+		return nil, fmt.Errorf("failed to create session: %v", err)
+	}
+
+	return session, nil
+}
+
+// Current returns the request's session and its user. The session's
+// last-seen time is refreshed at most once per touchInterval.
+func (s *SessionService) Current(w http.ResponseWriter, r *http.Request) (*models.Session, *models.User, error) {
+	session, err := s.store.Get(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := s.now()
+	if now.After(session.ExpiresAt) || (s.idleTimeout > 0 && now.Sub(session.LastSeenAt) > s.idleTimeout) {
+		if err := s.store.Destroy(w, r); err != nil {
+			logger.Log.Warn("Failed to destroy expired session: " + err.Error())
+		}
+		return nil, nil, ErrSessionExpired
+	}
+
+	user, err := s.userRepository.GetUserByID(session.UserID)
+	if errors.Is(err, repository.ErrNotFound) {
+		if err := s.store.Destroy(w, r); err != nil {
+			logger.Log.Warn("Failed to destroy session of deleted user: " + err.Error())
+		}
+		return nil, nil, ErrNoSession
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load session user: %v", err)
+	}
+
+	if now.Sub(session.LastSeenAt) >= touchInterval {
+		session.LastSeenAt = now
+		if err := s.store.Touch(w, r, session); err != nil {
+			logger.Log.Warn("Failed to update session last seen time: " + err.Error())
+		}
+	}
+
+	return session, user, nil
+}
+
+// End destroys the request's session
+func (s *SessionService) End(w http.ResponseWriter, r *http.Request) error {
+	return s.store.Destroy(w, r)
+}
+
+// RevokeUser logs a user out everywhere
+func (s *SessionService) RevokeUser(userID string) (int64, error) {
+	revoked, err := s.store.RevokeUser(userID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to revoke sessions of user %s: %v", userID, err)
+	}
+	return revoked, nil
+}
+
+// RunSweeper deletes expired sessions every interval until ctx is done
+func (s *SessionService) RunSweeper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.store.DeleteExpired(s.now()); err != nil {
+				logger.Log.Warn("Failed to sweep expired sessions: " + err.Error())
+			}
+		}
+	}
+}
+
+// clientIP is the address of the connection's peer. Forwarded headers are
+// ignored since we cannot tell whether a trusted proxy set them.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
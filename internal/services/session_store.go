@@ -0,0 +1,356 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"login-with-oauth/internal/keyring"
+	"login-with-oauth/internal/models"
+	"login-with-oauth/internal/repository"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// sessionCookie sets and clears the session cookie for every SessionStore
+type sessionCookie struct {
+	secure bool
+}
+
+func (c sessionCookie) set(w http.ResponseWriter, value string, expiresAt time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    value,
+		Path:     "/",
+		Expires:  expiresAt,
+		HttpOnly: true,
+		Secure:   c.secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (c sessionCookie) clear(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   c.secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (c sessionCookie) value(r *http.Request) (string, error) {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil || cookie.Value == "" {
+		return "", ErrNoSession
+	}
+	return cookie.Value, nil
+}
+
+// MemorySessionStore keeps sessions in process memory. Sessions are lost on
+// restart, so it suits single-instance deployments and tests.
+type MemorySessionStore struct {
+	sessionCookie
+	mu       sync.Mutex
+	sessions map[string]models.Session
+}
+
+// NewMemorySessionStore creates a new in-memory SessionStore
+func NewMemorySessionStore(secureCookies bool) *MemorySessionStore {
+	return &MemorySessionStore{
+		sessionCookie: sessionCookie{secure: secureCookies},
+		sessions:      make(map[string]models.Session),
+	}
+}
+
+func (s *MemorySessionStore) Create(w http.ResponseWriter, r *http.Request, session *models.Session) error {
+	token, err := randomString(32)
+	if err != nil {
+		return fmt.Errorf("failed to generate session token: %v", err)
+	}
+	session.ID = sha256Hex(token)
+
+	s.mu.Lock()
+	s.sessions[session.ID] = *session
+	s.mu.Unlock()
+
+	s.set(w, token, session.ExpiresAt)
+
+	return nil
+}
+
+func (s *MemorySessionStore) Get(r *http.Request) (*models.Session, error) {
+	token, err := s.value(r)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	saved, ok := s.sessions[sha256Hex(token)]
+	if !ok {
+		return nil, ErrNoSession
+	}
+
+	return &saved, nil
+}
+
+func (s *MemorySessionStore) Touch(w http.ResponseWriter, r *http.Request, session *models.Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	saved, ok := s.sessions[session.ID]
+	if !ok {
+		return ErrNoSession
+	}
+	saved.LastSeenAt = session.LastSeenAt
+	s.sessions[session.ID] = saved
+
+	return nil
+}
+
+func (s *MemorySessionStore) Destroy(w http.ResponseWriter, r *http.Request) error {
+	token, err := s.value(r)
+	if err != nil {
+		return nil
+	}
+	s.clear(w)
+
+	s.mu.Lock()
+	delete(s.sessions, sha256Hex(token))
+	s.mu.Unlock()
+
+	return nil
+}
+
+func (s *MemorySessionStore) DeleteExpired(before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var deleted int64
+	for id, saved := range s.sessions {
+		if saved.ExpiresAt.Before(before) {
+			delete(s.sessions, id)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+func (s *MemorySessionStore) RevokeUser(userID string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var revoked int64
+	for id, saved := range s.sessions {
+		if saved.UserID == userID {
+			delete(s.sessions, id)
+			revoked++
+		}
+	}
+
+	return revoked, nil
+}
+
+// PostgresSessionStore keeps sessions in the sessions table, so that every
+// instance sees the same sessions and they can be revoked centrally.
+type PostgresSessionStore struct {
+	sessionCookie
+	sessionRepository repository.SessionRepository
+}
+
+// NewPostgresSessionStore creates a new SessionStore backed by the SessionRepository
+func NewPostgresSessionStore(sessionRepository repository.SessionRepository, secureCookies bool) *PostgresSessionStore {
+	return &PostgresSessionStore{
+		sessionCookie:     sessionCookie{secure: secureCookies},
+		sessionRepository: sessionRepository,
+	}
+}
+
+func (s *PostgresSessionStore) Create(w http.ResponseWriter, r *http.Request, session *models.Session) error {
+	token, err := randomString(32)
+	if err != nil {
+		return fmt.Errorf("failed to generate session token: %v", err)
+	}
+	session.ID = sha256Hex(token)
+
+	if err := s.sessionRepository.CreateSession(*session); err != nil {
+		return err
+	}
+
+	s.set(w, token, session.ExpiresAt)
+
+	return nil
+}
+
+func (s *PostgresSessionStore) Get(r *http.Request) (*models.Session, error) {
+	token, err := s.value(r)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := s.sessionRepository.GetSession(sha256Hex(token))
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrNoSession
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+func (s *PostgresSessionStore) Touch(w http.ResponseWriter, r *http.Request, session *models.Session) error {
+	err := s.sessionRepository.TouchSession(session.ID, session.LastSeenAt)
+	if errors.Is(err, repository.ErrNotFound) {
+		return ErrNoSession
+	}
+	return err
+}
+
+func (s *PostgresSessionStore) Destroy(w http.ResponseWriter, r *http.Request) error {
+	token, err := s.value(r)
+	if err != nil {
+		return nil
+	}
+	s.clear(w)
+
+	return s.sessionRepository.DeleteSession(sha256Hex(token))
+}
+
+func (s *PostgresSessionStore) DeleteExpired(before time.Time) (int64, error) {
+	return s.sessionRepository.DeleteExpiredSessions(before)
+}
+
+func (s *PostgresSessionStore) RevokeUser(userID string) (int64, error) {
+	return s.sessionRepository.DeleteUserSessions(userID)
+}
+
+// CookieSessionStore keeps the whole session in an encrypted cookie, so no
+// storage is needed. Cookies sealed with any key of the keyring are accepted
+// and are resealed with the primary key the next time they are touched.
+//
+// A cookie stays valid until it expires even after Destroy, since the
+// browser may have kept a copy. RevokeUser is only known to the instance it
+// was called on; deployments that must revoke sessions centrally should use
+// the Postgres store.
+type CookieSessionStore struct {
+	sessionCookie
+	keyring *keyring.Keyring
+	ttl     time.Duration
+	mu      sync.Mutex
+	revoked map[string]time.Time
+	now     func() time.Time
+}
+
+// NewCookieSessionStore creates a new SessionStore sealing cookies with
+// keys. ttl is the longest a session can live.
+func NewCookieSessionStore(keys *keyring.Keyring, ttl time.Duration, secureCookies bool) *CookieSessionStore {
+	return &CookieSessionStore{
+		sessionCookie: sessionCookie{secure: secureCookies},
+		keyring:       keys,
+		ttl:           ttl,
+		revoked:       make(map[string]time.Time),
+		now:           time.Now,
+	}
+}
+
+// sessionCookieData authenticates the cookie as a session cookie, so that
+// values sealed for other purposes with the same keys are rejected
+var sessionCookieData = []byte(sessionCookieName)
+
+func (s *CookieSessionStore) Create(w http.ResponseWriter, r *http.Request, session *models.Session) error {
+	token, err := randomString(32)
+	if err != nil {
+		return fmt.Errorf("failed to generate session token: %v", err)
+	}
+	session.ID = sha256Hex(token)
+
+	return s.write(w, session)
+}
+
+func (s *CookieSessionStore) Get(r *http.Request) (*models.Session, error) {
+	value, err := s.value(r)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, _, err := s.keyring.Open(value, sessionCookieData)
+	if err != nil {
+		return nil, ErrNoSession
+	}
+
+	var session models.Session
+	if err := json.Unmarshal(payload, &session); err != nil {
+		return nil, ErrNoSession
+	}
+
+	s.mu.Lock()
+	revokedAt, revoked := s.revoked[session.UserID]
+	s.mu.Unlock()
+	if revoked && !session.CreatedAt.After(revokedAt) {
+		return nil, ErrNoSession
+	}
+
+	return &session, nil
+}
+
+func (s *CookieSessionStore) Touch(w http.ResponseWriter, r *http.Request, session *models.Session) error {
+	return s.write(w, session)
+}
+
+func (s *CookieSessionStore) Destroy(w http.ResponseWriter, r *http.Request) error {
+	if _, err := s.value(r); err != nil {
+		return nil
+	}
+	s.clear(w)
+
+	return nil
+}
+
+// DeleteExpired forgets revocations that no longer matter because every
+// session they cover has expired
+func (s *CookieSessionStore) DeleteExpired(before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var deleted int64
+	for userID, revokedAt := range s.revoked {
+		if revokedAt.Add(s.ttl).Before(before) {
+			delete(s.revoked, userID)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+// RevokeUser rejects the user's sessions created until now. The number of
+// sessions is unknown, so it always reports 0.
+func (s *CookieSessionStore) RevokeUser(userID string) (int64, error) {
+	s.mu.Lock()
+	s.revoked[userID] = s.now()
+	s.mu.Unlock()
+
+	return 0, nil
+}
+
+func (s *CookieSessionStore) write(w http.ResponseWriter, session *models.Session) error {
+	payload, err := json.Marshal(session)
+	if err != nil {
+		return fmt.Errorf("failed to encode session: %v", err)
+	}
+
+	sealed, err := s.keyring.Seal(payload, sessionCookieData)
+	if err != nil {
+		return fmt.Errorf("failed to seal session: %v", err)
+	}
+
+	s.set(w, sealed, session.ExpiresAt)
+
+	return nil
+}
@@ -0,0 +1,344 @@
+package services
+
+import (
+	"login-with-oauth/internal/keyring"
+	"login-with-oauth/internal/models"
+	"login-with-oauth/internal/repository"
+	"login-with-oauth/internal/repository/mock"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startSession logs user in from a fresh browser and returns a follow-up
+// request carrying the cookies the browser would send back
+func startSession(t *testing.T, service *SessionService, user *models.User) (*models.Session, *http.Request) {
+	t.Helper()
+
+	login := httptest.NewRequest("GET", "/callback/github", nil)
+	login.Header.Set("User-Agent", "test-agent")
+
+	recorder := httptest.NewRecorder()
+	session, err := service.Start(recorder, login, user, "github")
+	require.NoError(t, err)
+
+	next := httptest.NewRequest("GET", "/account", nil)
+	for _, cookie := range recorder.Result().Cookies() {
+		next.AddCookie(cookie)
+	}
+
+	return session, next
+}
+
+func TestSessionService(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepo := mock.NewMockUserRepository(ctrl)
+	user := &models.User{ID: "123", Username: "testuser", Email: "test@example.com"}
+
+	newService := func() *SessionService {
+		return NewSessionService(NewMemorySessionStore(true), mockRepo, time.Hour, 10*time.Minute)
+	}
+
+	t.Run("Start", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		session, err := newService().Start(recorder, httptest.NewRequest("GET", "/callback/github", nil), user, "github")
+		require.NoError(t, err)
+
+		cookies := recorder.Result().Cookies()
+		require.Len(t, cookies, 1)
+		assert.Equal(t, "session", cookies[0].Name)
+		assert.True(t, cookies[0].HttpOnly)
+		assert.True(t, cookies[0].Secure)
+		assert.Equal(t, http.SameSiteLaxMode, cookies[0].SameSite)
+
+		// Only the hash of the cookie is kept
+		assert.NotEqual(t, cookies[0].Value, session.ID)
+		assert.Equal(t, sha256Hex(cookies[0].Value), session.ID)
+		assert.Equal(t, "123", session.UserID)
+		assert.NotEmpty(t, session.CSRFToken)
+		assert.Equal(t, "192.0.2.1", session.IP)
+	})
+
+	t.Run("Current", func(t *testing.T) {
+		service := newService()
+		started, request := startSession(t, service, user)
+		mockRepo.EXPECT().GetUserByID("123").Return(user, nil)
+
+		session, current, err := service.Current(httptest.NewRecorder(), request)
+
+		assert.NoError(t, err)
+		assert.Equal(t, started.ID, session.ID)
+		assert.Equal(t, "test-agent", session.UserAgent)
+		assert.Equal(t, user, current)
+	})
+
+	t.Run("NoCookie", func(t *testing.T) {
+		_, _, err := newService().Current(httptest.NewRecorder(), httptest.NewRequest("GET", "/account", nil))
+
+		assert.ErrorIs(t, err, ErrNoSession)
+	})
+
+	t.Run("ForgedCookie", func(t *testing.T) {
+		request := httptest.NewRequest("GET", "/account", nil)
+		request.AddCookie(&http.Cookie{Name: "session", Value: "forged"})
+
+		_, _, err := newService().Current(httptest.NewRecorder(), request)
+
+		assert.ErrorIs(t, err, ErrNoSession)
+	})
+
+	t.Run("Idle", func(t *testing.T) {
+		service := newService()
+		_, request := startSession(t, service, user)
+		service.now = func() time.Time { return time.Now().Add(11 * time.Minute) }
+
+		_, _, err := service.Current(httptest.NewRecorder(), request)
+
+		assert.ErrorIs(t, err, ErrSessionExpired)
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		service := NewSessionService(NewMemorySessionStore(true), mockRepo, time.Hour, 0)
+		_, request := startSession(t, service, user)
+		service.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+
+		_, _, err := service.Current(httptest.NewRecorder(), request)
+
+		assert.ErrorIs(t, err, ErrSessionExpired)
+	})
+
+	t.Run("Touch", func(t *testing.T) {
+		service := newService()
+		started, request := startSession(t, service, user)
+		later := started.LastSeenAt.Add(5 * time.Minute)
+		service.now = func() time.Time { return later }
+		mockRepo.EXPECT().GetUserByID("123").Return(user, nil).Times(2)
+
+		_, _, err := service.Current(httptest.NewRecorder(), request)
+		require.NoError(t, err)
+		session, _, err := service.Current(httptest.NewRecorder(), request)
+
+		assert.NoError(t, err)
+		assert.Equal(t, later, session.LastSeenAt)
+	})
+
+	t.Run("DeletedUser", func(t *testing.T) {
+		service := newService()
+		_, request := startSession(t, service, user)
+		mockRepo.EXPECT().GetUserByID("123").Return(nil, repository.ErrNotFound)
+
+		_, _, err := service.Current(httptest.NewRecorder(), request)
+
+		assert.ErrorIs(t, err, ErrNoSession)
+	})
+
+	t.Run("End", func(t *testing.T) {
+		service := newService()
+		_, request := startSession(t, service, user)
+
+		recorder := httptest.NewRecorder()
+		require.NoError(t, service.End(recorder, request))
+
+		cookies := recorder.Result().Cookies()
+		require.Len(t, cookies, 1)
+		assert.Equal(t, -1, cookies[0].MaxAge)
+
+		_, _, err := service.Current(httptest.NewRecorder(), request)
+		assert.ErrorIs(t, err, ErrNoSession)
+	})
+
+	t.Run("StartReplacesPreviousSession", func(t *testing.T) {
+		service := newService()
+		_, planted := startSession(t, service, user)
+
+		_, err := service.Start(httptest.NewRecorder(), planted, user, "github")
+		require.NoError(t, err)
+
+		_, _, err = service.Current(httptest.NewRecorder(), planted)
+		assert.ErrorIs(t, err, ErrNoSession)
+	})
+}
+
+// createSession runs Create for a fresh browser and returns a follow-up
+// request carrying the session cookie
+func createSession(t *testing.T, store SessionStore, userID string) (*models.Session, *http.Request) {
+	t.Helper()
+
+	now := time.Now()
+	session := &models.Session{
+		UserID:     userID,
+		Provider:   "github",
+		CreatedAt:  now.Add(-time.Second),
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(time.Hour),
+	}
+
+	recorder := httptest.NewRecorder()
+	require.NoError(t, store.Create(recorder, httptest.NewRequest("GET", "/callback/github", nil), session))
+
+	next := httptest.NewRequest("GET", "/account", nil)
+	for _, cookie := range recorder.Result().Cookies() {
+		next.AddCookie(cookie)
+	}
+
+	return session, next
+}
+
+func testKeyring(t *testing.T, keys ...keyring.Key) *keyring.Keyring {
+	t.Helper()
+
+	if len(keys) == 0 {
+		keys = []keyring.Key{{ID: "k1", Secret: []byte("0123456789abcdef0123456789abcdef")}}
+	}
+	ring, err := keyring.New(keys...)
+	require.NoError(t, err)
+	return ring
+}
+
+func TestSessionStores(t *testing.T) {
+	stores := map[string]func() SessionStore{
+		"memory": func() SessionStore {
+			return NewMemorySessionStore(true)
+		},
+		"cookie": func() SessionStore {
+			return NewCookieSessionStore(testKeyring(t), time.Hour, true)
+		},
+	}
+
+	for name, newStore := range stores {
+		t.Run(name+"/RoundTrip", func(t *testing.T) {
+			store := newStore()
+			created, request := createSession(t, store, "123")
+
+			session, err := store.Get(request)
+
+			assert.NoError(t, err)
+			assert.Equal(t, created.ID, session.ID)
+			assert.Equal(t, "123", session.UserID)
+		})
+
+		t.Run(name+"/RevokeUser", func(t *testing.T) {
+			store := newStore()
+			_, revoked := createSession(t, store, "123")
+			_, other := createSession(t, store, "456")
+
+			_, err := store.RevokeUser("123")
+			require.NoError(t, err)
+
+			_, err = store.Get(revoked)
+			assert.ErrorIs(t, err, ErrNoSession)
+			_, err = store.Get(other)
+			assert.NoError(t, err)
+		})
+	}
+
+	t.Run("memory/DeleteExpired", func(t *testing.T) {
+		store := NewMemorySessionStore(true)
+		_, request := createSession(t, store, "123")
+
+		deleted, err := store.DeleteExpired(time.Now().Add(2 * time.Hour))
+
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), deleted)
+		_, err = store.Get(request)
+		assert.ErrorIs(t, err, ErrNoSession)
+	})
+
+	t.Run("cookie/Tampered", func(t *testing.T) {
+		store := NewCookieSessionStore(testKeyring(t), time.Hour, true)
+		request := httptest.NewRequest("GET", "/account", nil)
+		request.AddCookie(&http.Cookie{Name: "session", Value: "k1.forged"})
+
+		_, err := store.Get(request)
+
+		assert.ErrorIs(t, err, ErrNoSession)
+	})
+
+	t.Run("cookie/KeyRotation", func(t *testing.T) {
+		oldKey := keyring.Key{ID: "k1", Secret: []byte("0123456789abcdef0123456789abcdef")}
+		newKey := keyring.Key{ID: "k2", Secret: []byte("fedcba9876543210fedcba9876543210")}
+		_, request := createSession(t, NewCookieSessionStore(testKeyring(t, oldKey), time.Hour, true), "123")
+
+		rotated := NewCookieSessionStore(testKeyring(t, newKey, oldKey), time.Hour, true)
+		session, err := rotated.Get(request)
+		require.NoError(t, err)
+
+		recorder := httptest.NewRecorder()
+		require.NoError(t, rotated.Touch(recorder, request, session))
+		cookies := recorder.Result().Cookies()
+		require.Len(t, cookies, 1)
+		assert.True(t, strings.HasPrefix(cookies[0].Value, "k2."))
+
+		_, err = NewCookieSessionStore(testKeyring(t, oldKey), time.Hour, true).Get(request)
+		assert.NoError(t, err, "the old cookie is still accepted where k1 is known")
+	})
+
+	t.Run("cookie/DeleteExpiredForgetsOldRevocations", func(t *testing.T) {
+		store := NewCookieSessionStore(testKeyring(t), time.Hour, true)
+		_, err := store.RevokeUser("123")
+		require.NoError(t, err)
+
+		deleted, err := store.DeleteExpired(time.Now().Add(30 * time.Minute))
+		assert.NoError(t, err)
+		assert.Equal(t, int64(0), deleted)
+
+		deleted, err = store.DeleteExpired(time.Now().Add(2 * time.Hour))
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), deleted)
+	})
+}
+
+func TestPostgresSessionStore(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepo := mock.NewMockSessionRepository(ctrl)
+	store := NewPostgresSessionStore(mockRepo, true)
+
+	var saved models.Session
+	mockRepo.EXPECT().CreateSession(gomock.Any()).DoAndReturn(func(session models.Session) error {
+		saved = session
+		return nil
+	})
+	created, request := createSession(t, store, "123")
+
+	t.Run("Get", func(t *testing.T) {
+		mockRepo.EXPECT().GetSession(created.ID).Return(&saved, nil)
+
+		session, err := store.Get(request)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "123", session.UserID)
+	})
+
+	t.Run("Unknown", func(t *testing.T) {
+		mockRepo.EXPECT().GetSession(created.ID).Return(nil, repository.ErrNotFound)
+
+		_, err := store.Get(request)
+
+		assert.ErrorIs(t, err, ErrNoSession)
+	})
+
+	t.Run("Destroy", func(t *testing.T) {
+		mockRepo.EXPECT().DeleteSession(created.ID).Return(nil)
+
+		assert.NoError(t, store.Destroy(httptest.NewRecorder(), request))
+	})
+
+	t.Run("RevokeUser", func(t *testing.T) {
+		mockRepo.EXPECT().DeleteUserSessions("123").Return(int64(2), nil)
+
+		revoked, err := store.RevokeUser("123")
+
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), revoked)
+	})
+}
@@ -0,0 +1,321 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"login-with-oauth/internal/logger"
+	"login-with-oauth/internal/models"
+	"login-with-oauth/internal/repository"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	bindingCookieName     = "oauth_binding"
+	stateCookieNamePrefix = "oauth_state_"
+)
+
+var (
+	ErrStateMissing  = errors.New("state parameter is missing")
+	ErrStateNotFound = errors.New("state is unknown or has already been used")
+	ErrStateExpired  = errors.New("state has expired")
+	ErrStateMismatch = errors.New("state was issued to a different browser")
+)
+
+// StateStore keeps OAuth states between the login redirect and the callback.
+// Every state is bound to the browser that started the login, can be
+// consumed once and expires after the store's TTL.
+type StateStore interface {
+	// Issue persists the state and binds it to the requesting browser
+	Issue(w http.ResponseWriter, r *http.Request, state *models.OAuthState) error
+	// Consume removes the state and returns it if it is valid for this browser
+	Consume(w http.ResponseWriter, r *http.Request, state string) (*models.OAuthState, error)
+}
+
+// NewOAuthState creates a state with fresh random state and nonce values for
+// the given provider
+func NewOAuthState(provider string) (*models.OAuthState, error) {
+	value, err := randomString(32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate state: %v", err)
+	}
+	nonce, err := randomString(32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %v", err)
+	}
+
+	return &models.OAuthState{
+		State:    value,
+		Provider: provider,
+		Nonce:    nonce,
+	}, nil
+}
+
+// stateCookies holds what every StateStore needs to bind states to a browser
+type stateCookies struct {
+	ttl    time.Duration
+	secure bool
+	now    func() time.Time
+}
+
+func newStateCookies(ttl time.Duration, secure bool) stateCookies {
+	return stateCookies{ttl: ttl, secure: secure, now: time.Now}
+}
+
+// bind stamps the state with its lifetime and the browser binding, setting
+// the binding cookie if the browser does not have one yet
+func (c stateCookies) bind(w http.ResponseWriter, r *http.Request, state *models.OAuthState) error {
+	value := ""
+	if cookie, err := r.Cookie(bindingCookieName); err == nil && cookie.Value != "" {
+		value = cookie.Value
+	} else {
+		value, err = randomString(32)
+		if err != nil {
+			return fmt.Errorf("failed to generate browser binding: %v", err)
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     bindingCookieName,
+			Value:    value,
+			Path:     "/",
+			HttpOnly: true,
+			Secure:   c.secure,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+
+	now := c.now()
+	state.Binding = sha256Hex(value)
+	state.CreatedAt = now
+	state.ExpiresAt = now.Add(c.ttl)
+
+	return nil
+}
+
+// check validates a stored state against the browser presenting it
+func (c stateCookies) check(r *http.Request, state *models.OAuthState) error {
+	if c.now().After(state.ExpiresAt) {
+		return ErrStateExpired
+	}
+
+	cookie, err := r.Cookie(bindingCookieName)
+	if err != nil || cookie.Value == "" {
+		return ErrStateMismatch
+	}
+	if subtle.ConstantTimeCompare([]byte(sha256Hex(cookie.Value)), []byte(state.Binding)) != 1 {
+		return ErrStateMismatch
+	}
+
+	return nil
+}
+
+func sha256Hex(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+// MemoryStateStore keeps states in process memory. It suits single-instance
+// deployments and tests.
+type MemoryStateStore struct {
+	stateCookies
+	mu     sync.Mutex
+	states map[string]models.OAuthState
+}
+
+// NewMemoryStateStore creates a new in-memory StateStore
+func NewMemoryStateStore(ttl time.Duration, secureCookies bool) *MemoryStateStore {
+	return &MemoryStateStore{
+		stateCookies: newStateCookies(ttl, secureCookies),
+		states:       make(map[string]models.OAuthState),
+	}
+}
+
+func (s *MemoryStateStore) Issue(w http.ResponseWriter, r *http.Request, state *models.OAuthState) error {
+	if err := s.bind(w, r, state); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Drop abandoned logins while we hold the lock
+	now := s.now()
+	for key, pending := range s.states {
+		if now.After(pending.ExpiresAt) {
+			delete(s.states, key)
+		}
+	}
+	s.states[state.State] = *state
+
+	return nil
+}
+
+func (s *MemoryStateStore) Consume(w http.ResponseWriter, r *http.Request, state string) (*models.OAuthState, error) {
+	if state == "" {
+		return nil, ErrStateMissing
+	}
+
+	s.mu.Lock()
+	saved, ok := s.states[state]
+	delete(s.states, state)
+	s.mu.Unlock()
+
+	if !ok {
+		return nil, ErrStateNotFound
+	}
+	if err := s.check(r, &saved); err != nil {
+		return nil, err
+	}
+
+	return &saved, nil
+}
+
+// PostgresStateStore keeps states in the oauth_states table, so that the
+// callback may be served by a different instance than the login redirect.
+type PostgresStateStore struct {
+	stateCookies
+	stateRepository repository.StateRepository
+}
+
+// NewPostgresStateStore creates a new StateStore backed by the StateRepository
+func NewPostgresStateStore(stateRepository repository.StateRepository, ttl time.Duration, secureCookies bool) *PostgresStateStore {
+	return &PostgresStateStore{
+		stateCookies:    newStateCookies(ttl, secureCookies),
+		stateRepository: stateRepository,
+	}
+}
+
+func (s *PostgresStateStore) Issue(w http.ResponseWriter, r *http.Request, state *models.OAuthState) error {
+	if err := s.bind(w, r, state); err != nil {
+		return err
+	}
+
+	if _, err := s.stateRepository.DeleteExpiredStates(s.now()); err != nil {
+		logger.Log.Warn("Failed to sweep expired states: " + err.Error())
+	}
+
+	return s.stateRepository.SaveState(*state)
+}
+
+func (s *PostgresStateStore) Consume(w http.ResponseWriter, r *http.Request, state string) (*models.OAuthState, error) {
+	if state == "" {
+		return nil, ErrStateMissing
+	}
+
+	saved, err := s.stateRepository.TakeState(state)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrStateNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := s.check(r, saved); err != nil {
+		return nil, err
+	}
+
+	return saved, nil
+}
+
+// CookieStateStore keeps each state in its own HMAC-signed cookie, so no
+// server-side storage is needed. A state is single use because the cookie
+// is cleared as soon as the callback consumes it.
+type CookieStateStore struct {
+	stateCookies
+	secret []byte
+}
+
+// NewCookieStateStore creates a new StateStore that signs cookies with secret
+func NewCookieStateStore(secret []byte, ttl time.Duration, secureCookies bool) *CookieStateStore {
+	return &CookieStateStore{
+		stateCookies: newStateCookies(ttl, secureCookies),
+		secret:       secret,
+	}
+}
+
+func (s *CookieStateStore) Issue(w http.ResponseWriter, r *http.Request, state *models.OAuthState) error {
+	if err := s.bind(w, r, state); err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to encode state: %v", err)
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	http.SetCookie(w, &http.Cookie{
+		Name:     s.cookieName(state.State),
+		Value:    encoded + "." + s.sign(encoded),
+		Path:     "/",
+		MaxAge:   int(s.ttl.Seconds()),
+		HttpOnly: true,
+		Secure:   s.secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return nil
+}
+
+func (s *CookieStateStore) Consume(w http.ResponseWriter, r *http.Request, state string) (*models.OAuthState, error) {
+	if state == "" {
+		return nil, ErrStateMissing
+	}
+
+	name := s.cookieName(state)
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return nil, ErrStateNotFound
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   s.secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	encoded, signature, ok := strings.Cut(cookie.Value, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(s.sign(encoded))) {
+		return nil, ErrStateMismatch
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrStateMismatch
+	}
+
+	var saved models.OAuthState
+	if err := json.Unmarshal(payload, &saved); err != nil {
+		return nil, ErrStateMismatch
+	}
+	if subtle.ConstantTimeCompare([]byte(saved.State), []byte(state)) != 1 {
+		return nil, ErrStateMismatch
+	}
+	if err := s.check(r, &saved); err != nil {
+		return nil, err
+	}
+
+	return &saved, nil
+}
+
+// cookieName gives every pending login its own cookie so that logins
+// started in several tabs do not overwrite each other
+func (s *CookieStateStore) cookieName(state string) string {
+	return stateCookieNamePrefix + sha256Hex(state)[:16]
+}
+
+func (s *CookieStateStore) sign(value string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,188 @@
+package services
+
+import (
+	"login-with-oauth/internal/models"
+	"login-with-oauth/internal/repository"
+	"login-with-oauth/internal/repository/mock"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// issueState runs Issue for a fresh browser and returns the state together
+// with a callback request carrying the cookies the browser would send back
+func issueState(t *testing.T, store StateStore) (*models.OAuthState, *http.Request) {
+	t.Helper()
+
+	state, err := NewOAuthState("github")
+	require.NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	require.NoError(t, store.Issue(recorder, httptest.NewRequest("GET", "/login-gh", nil), state))
+
+	callback := httptest.NewRequest("GET", "/gh-cb?state="+state.State, nil)
+	for _, cookie := range recorder.Result().Cookies() {
+		callback.AddCookie(cookie)
+	}
+
+	return state, callback
+}
+
+func TestStateStores(t *testing.T) {
+	stores := map[string]func() StateStore{
+		"memory": func() StateStore {
+			return NewMemoryStateStore(time.Minute, true)
+		},
+		"cookie": func() StateStore {
+			return NewCookieStateStore([]byte("0123456789abcdef0123456789abcdef"), time.Minute, true)
+		},
+	}
+
+	for name, newStore := range stores {
+		t.Run(name+"/RoundTrip", func(t *testing.T) {
+			store := newStore()
+			state, callback := issueState(t, store)
+
+			saved, err := store.Consume(httptest.NewRecorder(), callback, state.State)
+
+			assert.NoError(t, err)
+			assert.Equal(t, "github", saved.Provider)
+			assert.Equal(t, state.State, saved.State)
+		})
+
+		t.Run(name+"/Missing", func(t *testing.T) {
+			store := newStore()
+			_, callback := issueState(t, store)
+
+			_, err := store.Consume(httptest.NewRecorder(), callback, "")
+
+			assert.ErrorIs(t, err, ErrStateMissing)
+		})
+
+		t.Run(name+"/Unknown", func(t *testing.T) {
+			store := newStore()
+			_, callback := issueState(t, store)
+
+			_, err := store.Consume(httptest.NewRecorder(), callback, "forged-state")
+
+			assert.Error(t, err)
+		})
+
+		t.Run(name+"/OtherBrowser", func(t *testing.T) {
+			store := newStore()
+			state, _ := issueState(t, store)
+
+			_, err := store.Consume(httptest.NewRecorder(), httptest.NewRequest("GET", "/gh-cb", nil), state.State)
+
+			assert.Error(t, err)
+		})
+
+		t.Run(name+"/Expired", func(t *testing.T) {
+			store := newStore()
+			state, callback := issueState(t, store)
+
+			later := func() time.Time { return time.Now().Add(time.Hour) }
+			switch s := store.(type) {
+			case *MemoryStateStore:
+				s.now = later
+			case *CookieStateStore:
+				s.now = later
+			}
+
+			_, err := store.Consume(httptest.NewRecorder(), callback, state.State)
+
+			assert.ErrorIs(t, err, ErrStateExpired)
+		})
+	}
+
+	t.Run("memory/Replay", func(t *testing.T) {
+		store := NewMemoryStateStore(time.Minute, true)
+		state, callback := issueState(t, store)
+
+		_, err := store.Consume(httptest.NewRecorder(), callback, state.State)
+		assert.NoError(t, err)
+
+		_, err = store.Consume(httptest.NewRecorder(), callback, state.State)
+		assert.ErrorIs(t, err, ErrStateNotFound)
+	})
+
+	t.Run("cookie/ClearsCookie", func(t *testing.T) {
+		store := NewCookieStateStore([]byte("0123456789abcdef0123456789abcdef"), time.Minute, true)
+		state, callback := issueState(t, store)
+
+		recorder := httptest.NewRecorder()
+		_, err := store.Consume(recorder, callback, state.State)
+		assert.NoError(t, err)
+
+		cleared := recorder.Result().Cookies()
+		assert.Len(t, cleared, 1)
+		assert.Equal(t, -1, cleared[0].MaxAge)
+	})
+
+	t.Run("cookie/Tampered", func(t *testing.T) {
+		store := NewCookieStateStore([]byte("0123456789abcdef0123456789abcdef"), time.Minute, true)
+		state, callback := issueState(t, store)
+
+		forged := httptest.NewRequest("GET", "/gh-cb", nil)
+		for _, cookie := range callback.Cookies() {
+			if cookie.Name == store.cookieName(state.State) {
+				cookie.Value = strings.Replace(cookie.Value, ".", "x.", 1)
+			}
+			forged.AddCookie(cookie)
+		}
+
+		_, err := store.Consume(httptest.NewRecorder(), forged, state.State)
+
+		assert.ErrorIs(t, err, ErrStateMismatch)
+	})
+}
+
+func TestPostgresStateStore(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepo := mock.NewMockStateRepository(ctrl)
+	store := NewPostgresStateStore(mockRepo, time.Minute, true)
+
+	var saved models.OAuthState
+	mockRepo.EXPECT().DeleteExpiredStates(gomock.Any()).Return(int64(0), nil)
+	mockRepo.EXPECT().SaveState(gomock.Any()).DoAndReturn(func(state models.OAuthState) error {
+		saved = state
+		return nil
+	})
+
+	state, callback := issueState(t, store)
+	assert.Equal(t, state.State, saved.State)
+	assert.NotEmpty(t, saved.Binding)
+
+	t.Run("Valid", func(t *testing.T) {
+		mockRepo.EXPECT().TakeState(state.State).Return(&saved, nil)
+
+		result, err := store.Consume(httptest.NewRecorder(), callback, state.State)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "github", result.Provider)
+	})
+
+	t.Run("Replay", func(t *testing.T) {
+		mockRepo.EXPECT().TakeState(state.State).Return(nil, repository.ErrNotFound)
+
+		_, err := store.Consume(httptest.NewRecorder(), callback, state.State)
+
+		assert.ErrorIs(t, err, ErrStateNotFound)
+	})
+
+	t.Run("OtherBrowser", func(t *testing.T) {
+		mockRepo.EXPECT().TakeState(state.State).Return(&saved, nil)
+
+		_, err := store.Consume(httptest.NewRecorder(), httptest.NewRequest("GET", "/gh-cb", nil), state.State)
+
+		assert.ErrorIs(t, err, ErrStateMismatch)
+	})
+}
@@ -0,0 +1,597 @@
+package services
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+	"login-with-oauth/internal/jwt"
+	"login-with-oauth/internal/logger"
+	"login-with-oauth/internal/models"
+	"login-with-oauth/internal/repository"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidGrant       = errors.New("refresh token is invalid, expired or revoked")
+	ErrRefreshTokenReused = errors.New("refresh token was already used")
+	ErrInvalidToken       = errors.New("access token is invalid or expired")
+	ErrMissingRole        = errors.New("user does not have the required role")
+	ErrInvalidScope       = errors.New("scope was not assigned to the client")
+)
+
+// accessTokenClaims are the optional claims tokens.claims can select
+var accessTokenClaims = []string{"email", "username", "provider", "roles"}
+
+// TokenSigner signs the JWTs we issue and publishes the keys to verify them
+type TokenSigner interface {
+	Sign(claims any) (string, error)
+	// KeySet lists the public keys of every key that may have signed a
+	// token that is still valid
+	KeySet() jwt.KeySet
+}
+
+// StaticSigner signs with a single configured key
+type StaticSigner struct {
+	algorithm string
+	keyID     string
+	key       crypto.Signer
+	jwk       jwt.JWK
+}
+
+// NewStaticSigner creates a TokenSigner for key. An empty algorithm picks
+// the usual one for the key type. The kid is the key's thumbprint.
+func NewStaticSigner(key crypto.Signer, algorithm string) (*StaticSigner, error) {
+	if algorithm == "" {
+		var err error
+		if algorithm, err = jwt.DefaultAlgorithm(key.Public()); err != nil {
+			return nil, err
+		}
+	}
+	if !jwt.Supported(algorithm) {
+		return nil, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+
+	jwk, err := jwt.NewJWK(key.Public(), "", algorithm)
+	if err != nil {
+		return nil, err
+	}
+	keyID, err := jwk.Thumbprint()
+	if err != nil {
+		return nil, err
+	}
+	jwk.KeyID = keyID
+
+	return &StaticSigner{algorithm: algorithm, keyID: keyID, key: key, jwk: jwk}, nil
+}
+
+// Sign implements TokenSigner
+func (s *StaticSigner) Sign(claims any) (string, error) {
+	return jwt.Sign(s.algorithm, s.keyID, s.key, claims)
+}
+
+// KeySet implements TokenSigner
+func (s *StaticSigner) KeySet() jwt.KeySet {
+	return jwt.KeySet{Keys: []jwt.JWK{s.jwk}}
+}
+
+// TokenConfig controls the tokens issued by TokenService
+type TokenConfig struct {
+	Issuer          string
+	Audience        []string
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+	// Claims selects the optional access token claims: email, username,
+	// provider and roles. sub is always included.
+	Claims []string
+	// Roles maps each role to the verified emails of the users who have
+	// it, and to provider memberships like "github:acme/admins" whose
+	// members have it
+	Roles map[string][]string
+	// AccessTokenFormat is jwt (the default) or opaque. Opaque access tokens
+	// are stored, so they can be revoked, and checked with introspection.
+	AccessTokenFormat string
+}
+
+// AccessTokenClaims are the claims of our JWT access tokens
+type AccessTokenClaims struct {
+	jwt.Claims
+	Email    string   `json:"email,omitempty"`
+	Username string   `json:"preferred_username,omitempty"`
+	Provider string   `json:"provider,omitempty"`
+	Roles    []string `json:"roles,omitempty"`
+	ClientID string   `json:"client_id,omitempty"`
+	Scope    string   `json:"scope,omitempty"`
+}
+
+// TokenResponse is the successful response of a token request
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+// Grant is what a user allowed a client to receive
+type Grant struct {
+	ClientID string
+	Provider string
+	Scope    string
+	// Nonce and AuthTime go into the ID token of an OpenID Connect login
+	Nonce    string
+	AuthTime time.Time
+}
+
+// SessionRevoker logs a user out everywhere, like SessionService does
+type SessionRevoker interface {
+	RevokeUser(userID string) (int64, error)
+}
+
+// TokenService issues access tokens and rotating refresh tokens to our own
+// clients
+type TokenService struct {
+	signer                 TokenSigner
+	refreshTokenRepository repository.RefreshTokenRepository
+	accessTokenRepository  repository.AccessTokenRepository
+	userRepository         repository.UserRepository
+	sessions               SessionRevoker
+	config                 TokenConfig
+	now                    func() time.Time
+}
+
+// NewTokenService creates a new TokenService
+func NewTokenService(signer TokenSigner, refreshTokenRepository repository.RefreshTokenRepository, accessTokenRepository repository.AccessTokenRepository, userRepository repository.UserRepository, config TokenConfig) (*TokenService, error) {
+	for _, claim := range config.Claims {
+		if !slices.Contains(accessTokenClaims, claim) {
+			return nil, fmt.Errorf("unknown access token claim %q", claim)
+		}
+	}
+	switch config.AccessTokenFormat {
+	case "", "jwt", "opaque":
+	default:
+		return nil, fmt.Errorf("unknown access token format %q, expected jwt or opaque", config.AccessTokenFormat)
+	}
+
+	return &TokenService{
+		signer:                 signer,
+		refreshTokenRepository: refreshTokenRepository,
+		accessTokenRepository:  accessTokenRepository,
+		userRepository:         userRepository,
+		config:                 config,
+		now:                    time.Now,
+	}, nil
+}
+
+// SetSessionRevoker makes a reused refresh token also end the login
+// sessions of its user
+func (s *TokenService) SetSessionRevoker(sessions SessionRevoker) {
+	s.sessions = sessions
+}
+
+// Issue mints an access token and starts a new refresh token family for a
+// user who just logged in with provider
+func (s *TokenService) Issue(user *models.User, provider string) (*TokenResponse, error) {
+	familyID, err := randomString(16)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate token family: %v", err)
+	}
+
+	return s.issue(user, Grant{Provider: provider}, familyID)
+}
+
+// IssueGrant mints the tokens of a client's grant: an access token, an ID
+// token if the openid scope was granted and a refresh token if
+// offline_access was
+func (s *TokenService) IssueGrant(user *models.User, grant Grant) (*TokenResponse, error) {
+	familyID := ""
+	if hasScope(grant.Scope, "offline_access") {
+		var err error
+		if familyID, err = randomString(16); err != nil {
+			return nil, fmt.Errorf("failed to generate token family: %v", err)
+		}
+	}
+
+	return s.issue(user, grant, familyID)
+}
+
+// IssueClientCredentials mints an access token for a client acting on its
+// own behalf (RFC 6749 section 4.4). The token's subject is the client and
+// it is limited to the requested API scopes, or to all API scopes assigned
+// to the client if none were requested. The OpenID Connect scopes are about
+// a user, so such tokens never carry them; no refresh token is issued
+// either.
+func (s *TokenService) IssueClientCredentials(client *models.Client, scope string) (*TokenResponse, error) {
+	scopes := strings.Fields(scope)
+	if len(scopes) == 0 {
+		for _, assigned := range client.Scopes {
+			if isAPIScope(assigned) {
+				scopes = append(scopes, assigned)
+			}
+		}
+	}
+	for _, requested := range scopes {
+		if !slices.Contains(client.Scopes, requested) || !isAPIScope(requested) {
+			return nil, ErrInvalidScope
+		}
+	}
+	scope = strings.Join(scopes, " ")
+
+	accessToken, err := s.accessToken(nil, Grant{ClientID: client.ID, Scope: scope}, "")
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Log.Info("Issued client credentials token to client " + client.ID + " with scope \"" + scope + "\"")
+
+	return &TokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(s.config.AccessTokenTTL.Seconds()),
+		Scope:       scope,
+	}, nil
+}
+
+// Refresh trades a refresh token for a new access token and refresh token.
+// The token must have been issued to clientID, which is empty for tokens
+// from our own login. Presenting a token that was already used revokes its
+// whole family, since either the client or an attacker holds a stolen copy.
+func (s *TokenService) Refresh(refreshToken, clientID string) (*TokenResponse, error) {
+	saved, err := s.refreshTokenRepository.GetRefreshToken(sha256Hex(refreshToken))
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrInvalidGrant
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.now()
+	if saved.RevokedAt != nil || now.After(saved.ExpiresAt) || saved.ClientID != clientID {
+		return nil, ErrInvalidGrant
+	}
+	if saved.UsedAt != nil {
+		return nil, s.revokeReused(saved)
+	}
+
+	marked, err := s.refreshTokenRepository.MarkRefreshTokenUsed(saved.ID, now)
+	if err != nil {
+		return nil, err
+	}
+	if !marked {
+		return nil, s.revokeReused(saved)
+	}
+
+	user, err := s.userRepository.GetUserByID(saved.UserID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrInvalidGrant
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load token user: %v", err)
+	}
+
+	return s.issue(user, Grant{
+		ClientID: saved.ClientID,
+		Provider: saved.Provider,
+		Scope:    saved.Scope,
+	}, saved.FamilyID)
+}
+
+func (s *TokenService) revokeReused(token *models.RefreshToken) error {
+	logger.Log.Warn("Refresh token reused, revoking token family " + token.FamilyID + " of user " + token.UserID)
+
+	if err := s.revokeFamily(token.FamilyID); err != nil {
+		return fmt.Errorf("failed to revoke reused token family: %v", err)
+	}
+
+	// Whoever stole the refresh token may have the user's session too
+	if s.sessions != nil {
+		if _, err := s.sessions.RevokeUser(token.UserID); err != nil {
+			logger.Log.Warn("Failed to revoke sessions of user " + token.UserID + ": " + err.Error())
+		}
+	}
+
+	return ErrRefreshTokenReused
+}
+
+// revokeFamily revokes the refresh tokens of a family and the opaque access
+// tokens issued with them
+func (s *TokenService) revokeFamily(familyID string) error {
+	now := s.now()
+	if _, err := s.refreshTokenRepository.RevokeRefreshTokenFamily(familyID, now); err != nil {
+		return err
+	}
+	if _, err := s.accessTokenRepository.RevokeAccessTokenFamily(familyID, now); err != nil {
+		return err
+	}
+	return nil
+}
+
+// issue signs the tokens of a grant. A refresh token is stored in familyID
+// unless it is empty.
+func (s *TokenService) issue(user *models.User, grant Grant, familyID string) (*TokenResponse, error) {
+	accessToken, err := s.accessToken(user, grant, familyID)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &TokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(s.config.AccessTokenTTL.Seconds()),
+		Scope:       grant.Scope,
+	}
+
+	if grant.ClientID != "" && hasScope(grant.Scope, "openid") {
+		if response.IDToken, err = s.idToken(user, grant); err != nil {
+			return nil, err
+		}
+	}
+
+	if familyID != "" {
+		refreshToken, err := randomString(32)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate refresh token: %v", err)
+		}
+
+		now := s.now()
+		if err := s.refreshTokenRepository.CreateRefreshToken(models.RefreshToken{
+			ID:        sha256Hex(refreshToken),
+			FamilyID:  familyID,
+			UserID:    user.ID,
+			ClientID:  grant.ClientID,
+			Provider:  grant.Provider,
+			Scope:     grant.Scope,
+			CreatedAt: now,
+			ExpiresAt: now.Add(s.config.RefreshTokenTTL),
+		}); err != nil {
+			return nil, err
+		}
+		response.RefreshToken = refreshToken
+	}
+
+	return response, nil
+}
+
+// idToken signs the OpenID Connect ID token of a grant, with the profile
+// and email claims its scopes allow
+func (s *TokenService) idToken(user *models.User, grant Grant) (string, error) {
+	now := s.now()
+	claims := IDTokenClaims{
+		Claims: jwt.Claims{
+			Issuer:    s.config.Issuer,
+			Subject:   user.ID,
+			Audience:  jwt.Audience{grant.ClientID},
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(s.config.AccessTokenTTL).Unix(),
+		},
+		Nonce: grant.Nonce,
+	}
+	if !grant.AuthTime.IsZero() {
+		claims.AuthTime = grant.AuthTime.Unix()
+	}
+	if hasScope(grant.Scope, "email") {
+		claims.Email = user.Email
+	}
+	if hasScope(grant.Scope, "profile") {
+		claims.PreferredUsername = user.Username
+		claims.Picture = user.AvatarURL
+	}
+
+	token, err := s.signer.Sign(claims)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign id token: %v", err)
+	}
+
+	return token, nil
+}
+
+// VerifyAccessToken checks an access token we issued and returns its claims
+func (s *TokenService) VerifyAccessToken(raw string) (*AccessTokenClaims, error) {
+	if !strings.Contains(raw, ".") {
+		return s.verifyOpaqueAccessToken(raw)
+	}
+
+	token, err := jwt.Parse(raw)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	if err := s.signer.KeySet().VerifyWith(token); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	var claims AccessTokenClaims
+	if err := token.Claims(&claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+	if claims.Issuer != s.config.Issuer || s.now().Unix() >= claims.ExpiresAt {
+		return nil, ErrInvalidToken
+	}
+
+	return &claims, nil
+}
+
+// RequireRole checks an access token from our own login and returns its
+// user if they hold role. Tokens issued to clients are refused, so that an
+// app cannot use them to act as the user here.
+func (s *TokenService) RequireRole(raw, role string) (*models.User, error) {
+	claims, err := s.VerifyAccessToken(raw)
+	if err != nil {
+		return nil, err
+	}
+	if claims.ClientID != "" {
+		return nil, ErrInvalidToken
+	}
+
+	user, err := s.userRepository.GetUserByID(claims.Subject)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(s.roles(user), role) {
+		return nil, ErrMissingRole
+	}
+
+	return user, nil
+}
+
+// verifyOpaqueAccessToken looks up an opaque access token and describes it
+// with the claims a JWT access token would carry
+func (s *TokenService) verifyOpaqueAccessToken(raw string) (*AccessTokenClaims, error) {
+	saved, err := s.accessTokenRepository.GetAccessToken(sha256Hex(raw))
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if saved.RevokedAt != nil || !s.now().Before(saved.ExpiresAt) {
+		return nil, ErrInvalidToken
+	}
+
+	// Client credentials tokens are the client's own
+	subject := saved.UserID
+	if subject == "" {
+		subject = saved.ClientID
+	}
+
+	return &AccessTokenClaims{
+		Claims: jwt.Claims{
+			Issuer:    s.config.Issuer,
+			Subject:   subject,
+			Audience:  s.config.Audience,
+			IssuedAt:  saved.CreatedAt.Unix(),
+			ExpiresAt: saved.ExpiresAt.Unix(),
+		},
+		Provider: saved.Provider,
+		ClientID: saved.ClientID,
+		Scope:    saved.Scope,
+	}, nil
+}
+
+// accessToken issues the access token of a grant in the configured format.
+// user is nil for a client acting on its own behalf.
+func (s *TokenService) accessToken(user *models.User, grant Grant, familyID string) (string, error) {
+	if s.config.AccessTokenFormat != "opaque" {
+		return s.jwtAccessToken(user, grant.Provider, grant.ClientID, grant.Scope)
+	}
+
+	token, err := randomString(32)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate access token: %v", err)
+	}
+
+	var userID string
+	if user != nil {
+		userID = user.ID
+	}
+
+	now := s.now()
+	if err := s.accessTokenRepository.CreateAccessToken(models.AccessToken{
+		ID:        sha256Hex(token),
+		FamilyID:  familyID,
+		UserID:    userID,
+		ClientID:  grant.ClientID,
+		Provider:  grant.Provider,
+		Scope:     grant.Scope,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.config.AccessTokenTTL),
+	}); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+func (s *TokenService) jwtAccessToken(user *models.User, provider, clientID, scope string) (string, error) {
+	id, err := randomString(16)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate token id: %v", err)
+	}
+
+	subject := clientID
+	if user != nil {
+		subject = user.ID
+	}
+
+	now := s.now()
+	claims := AccessTokenClaims{
+		Claims: jwt.Claims{
+			Issuer:    s.config.Issuer,
+			Subject:   subject,
+			Audience:  s.config.Audience,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(s.config.AccessTokenTTL).Unix(),
+			ID:        id,
+		},
+		ClientID: clientID,
+		Scope:    scope,
+	}
+	// Client credentials tokens describe no user
+	if user != nil {
+		for _, claim := range s.config.Claims {
+			switch claim {
+			case "email":
+				claims.Email = user.Email
+			case "username":
+				claims.Username = user.Username
+			case "provider":
+				claims.Provider = provider
+			case "roles":
+				claims.Roles = s.roles(user)
+			}
+		}
+	}
+
+	token, err := s.signer.Sign(claims)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign access token: %v", err)
+	}
+
+	return token, nil
+}
+
+// roles lists the configured roles granted to the user's email or
+// memberships. An email the provider did not verify could be anyone's, so
+// it grants nothing.
+func (s *TokenService) roles(user *models.User) []string {
+	var roles []string
+	for role, members := range s.config.Roles {
+		if (user.EmailVerified && slices.Contains(members, user.Email)) || slices.ContainsFunc(user.Memberships, func(membership string) bool {
+			return slices.Contains(members, membership)
+		}) {
+			roles = append(roles, role)
+		}
+	}
+	slices.Sort(roles)
+	return roles
+}
+
+// RunSweeper deletes expired refresh and access tokens every interval until ctx is done
+func (s *TokenService) RunSweeper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.refreshTokenRepository.DeleteExpiredRefreshTokens(s.now()); err != nil {
+				logger.Log.Warn("Failed to sweep expired refresh tokens: " + err.Error())
+			}
+			if _, err := s.accessTokenRepository.DeleteExpiredAccessTokens(s.now()); err != nil {
+				logger.Log.Warn("Failed to sweep expired access tokens: " + err.Error())
+			}
+		}
+	}
+}
+
+// hasScope reports whether a space-separated scope string contains scope
+func hasScope(scopes, scope string) bool {
+	return slices.Contains(strings.Fields(scopes), scope)
+}
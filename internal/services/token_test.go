@@ -0,0 +1,188 @@
+package services
+
+import (
+	"login-with-oauth/internal/jwt"
+	"login-with-oauth/internal/models"
+	"login-with-oauth/internal/repository"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenService(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	user := &models.User{ID: "123", Username: "testuser", Email: "test@example.com", EmailVerified: true}
+
+	service := newTestTokenService(t, ctrl, TokenConfig{
+		Audience: []string{"api"},
+		Claims:   []string{"email", "provider", "roles"},
+		Roles:    map[string][]string{"admin": {"test@example.com"}, "auditor": {"other@example.com", "github:acme/auditors"}},
+	})
+
+	// revokedSessions records the users whose sessions were revoked
+	var revokedSessions []string
+	service.SetSessionRevoker(sessionRevokerFunc(func(userID string) (int64, error) {
+		revokedSessions = append(revokedSessions, userID)
+		return 1, nil
+	}))
+
+	t.Run("Issue", func(t *testing.T) {
+		response, err := service.Issue(user, "github")
+		require.NoError(t, err)
+
+		assert.Equal(t, "Bearer", response.TokenType)
+		assert.Equal(t, int64(900), response.ExpiresIn)
+		require.Contains(t, service.refreshTokens, sha256Hex(response.RefreshToken))
+
+		token, err := jwt.Parse(response.AccessToken)
+		require.NoError(t, err)
+		assert.Equal(t, "ES256", token.Header.Algorithm)
+		assert.Equal(t, service.signer.keyID, token.Header.KeyID)
+		require.NoError(t, token.Verify(service.signer.key.Public()))
+
+		var claims AccessTokenClaims
+		require.NoError(t, token.Claims(&claims))
+		assert.Equal(t, "123", claims.Subject)
+		assert.Equal(t, "https://auth.example.com", claims.Issuer)
+		assert.True(t, claims.Audience.Contains("api"))
+		assert.Equal(t, "test@example.com", claims.Email)
+		assert.Equal(t, "github", claims.Provider)
+		assert.Equal(t, []string{"admin"}, claims.Roles)
+		assert.Empty(t, claims.Username)
+	})
+
+	t.Run("NoRolesForUnverifiedEmail", func(t *testing.T) {
+		impostor := &models.User{ID: "789", Email: "test@example.com"}
+
+		response, err := service.Issue(impostor, "oidc")
+		require.NoError(t, err)
+
+		claims, err := service.VerifyAccessToken(response.AccessToken)
+		require.NoError(t, err)
+		assert.Empty(t, claims.Roles)
+	})
+
+	t.Run("RolesFromMemberships", func(t *testing.T) {
+		member := &models.User{ID: "456", Email: "member@example.com", Memberships: []string{"github:acme", "github:acme/auditors"}}
+
+		response, err := service.Issue(member, "github")
+		require.NoError(t, err)
+
+		claims, err := service.VerifyAccessToken(response.AccessToken)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"auditor"}, claims.Roles)
+	})
+
+	t.Run("Refresh", func(t *testing.T) {
+		issued, err := service.Issue(user, "github")
+		require.NoError(t, err)
+		first := service.refreshTokens[sha256Hex(issued.RefreshToken)]
+
+		service.refreshRepo.EXPECT().GetRefreshToken(first.ID).Return(&first, nil)
+		service.refreshRepo.EXPECT().MarkRefreshTokenUsed(first.ID, gomock.Any()).Return(true, nil)
+		service.userRepo.EXPECT().GetUserByID("123").Return(user, nil)
+
+		refreshed, err := service.Refresh(issued.RefreshToken, "")
+		require.NoError(t, err)
+
+		assert.NotEqual(t, issued.RefreshToken, refreshed.RefreshToken)
+		second := service.refreshTokens[sha256Hex(refreshed.RefreshToken)]
+		assert.Equal(t, first.FamilyID, second.FamilyID)
+		assert.Equal(t, "github", second.Provider)
+	})
+
+	t.Run("ClientCredentials", func(t *testing.T) {
+		client := &models.Client{ID: "batch", Scopes: []string{"openid", "email", "orders:read", "orders:write"}, GrantTypes: []string{"client_credentials"}}
+
+		issued, err := service.IssueClientCredentials(client, "")
+		require.NoError(t, err)
+
+		assert.Empty(t, issued.RefreshToken)
+		assert.Empty(t, issued.IDToken)
+		assert.Equal(t, "orders:read orders:write", issued.Scope)
+		claims, err := service.VerifyAccessToken(issued.AccessToken)
+		require.NoError(t, err)
+		assert.Equal(t, "batch", claims.Subject)
+		assert.Equal(t, "batch", claims.ClientID)
+		assert.Equal(t, "orders:read orders:write", claims.Scope)
+		assert.Empty(t, claims.Roles)
+
+		issued, err = service.IssueClientCredentials(client, "orders:read")
+		require.NoError(t, err)
+		assert.Equal(t, "orders:read", issued.Scope)
+
+		// The OpenID Connect scopes are about users
+		_, err = service.IssueClientCredentials(client, "email")
+		assert.ErrorIs(t, err, ErrInvalidScope)
+
+		_, err = service.IssueClientCredentials(client, "orders:delete")
+		assert.ErrorIs(t, err, ErrInvalidScope)
+	})
+
+	t.Run("ReuseRevokesFamily", func(t *testing.T) {
+		issued, err := service.Issue(user, "github")
+		require.NoError(t, err)
+		used := service.refreshTokens[sha256Hex(issued.RefreshToken)]
+		usedAt := time.Now()
+		used.UsedAt = &usedAt
+
+		service.refreshRepo.EXPECT().GetRefreshToken(used.ID).Return(&used, nil)
+		service.refreshRepo.EXPECT().RevokeRefreshTokenFamily(used.FamilyID, gomock.Any()).Return(int64(2), nil)
+		service.accessRepo.EXPECT().RevokeAccessTokenFamily(used.FamilyID, gomock.Any()).Return(int64(0), nil)
+
+		_, err = service.Refresh(issued.RefreshToken, "")
+
+		assert.ErrorIs(t, err, ErrRefreshTokenReused)
+		assert.Equal(t, []string{"123"}, revokedSessions)
+	})
+
+	t.Run("ConcurrentUseRevokesFamily", func(t *testing.T) {
+		issued, err := service.Issue(user, "github")
+		require.NoError(t, err)
+		saved := service.refreshTokens[sha256Hex(issued.RefreshToken)]
+
+		service.refreshRepo.EXPECT().GetRefreshToken(saved.ID).Return(&saved, nil)
+		service.refreshRepo.EXPECT().MarkRefreshTokenUsed(saved.ID, gomock.Any()).Return(false, nil)
+		service.refreshRepo.EXPECT().RevokeRefreshTokenFamily(saved.FamilyID, gomock.Any()).Return(int64(1), nil)
+		service.accessRepo.EXPECT().RevokeAccessTokenFamily(saved.FamilyID, gomock.Any()).Return(int64(0), nil)
+
+		_, err = service.Refresh(issued.RefreshToken, "")
+
+		assert.ErrorIs(t, err, ErrRefreshTokenReused)
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		expired := models.RefreshToken{ID: sha256Hex("expired"), FamilyID: "f", UserID: "123", ExpiresAt: time.Now().Add(-time.Minute)}
+		service.refreshRepo.EXPECT().GetRefreshToken(expired.ID).Return(&expired, nil)
+
+		_, err := service.Refresh("expired", "")
+
+		assert.ErrorIs(t, err, ErrInvalidGrant)
+	})
+
+	t.Run("Unknown", func(t *testing.T) {
+		service.refreshRepo.EXPECT().GetRefreshToken(sha256Hex("unknown")).Return(nil, repository.ErrNotFound)
+
+		_, err := service.Refresh("unknown", "")
+
+		assert.ErrorIs(t, err, ErrInvalidGrant)
+	})
+
+	t.Run("UnknownClaim", func(t *testing.T) {
+		_, err := NewTokenService(service.signer, service.refreshRepo, service.accessRepo, service.userRepo, TokenConfig{Claims: []string{"password"}})
+
+		assert.Error(t, err)
+	})
+}
+
+// sessionRevokerFunc adapts a function to SessionRevoker
+type sessionRevokerFunc func(userID string) (int64, error)
+
+func (f sessionRevokerFunc) RevokeUser(userID string) (int64, error) {
+	return f(userID)
+}
@@ -0,0 +1,236 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"login-with-oauth/internal/keyring"
+	"login-with-oauth/internal/logger"
+	"login-with-oauth/internal/models"
+	"login-with-oauth/internal/repository"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+var (
+	ErrNoProviderToken   = errors.New("no provider token is stored for the user")
+	ErrReconsentRequired = errors.New("the provider no longer accepts the user's refresh token")
+)
+
+// TokenVault keeps the tokens upstream providers issued at login, so that
+// we can call their APIs on the user's behalf later. Every token is
+// encrypted with a data key of its own, and the data key with the vault's
+// keyring, so rotating the keyring only reseals the small data keys.
+type TokenVault struct {
+	repository repository.ProviderTokenRepository
+	keyring    *keyring.Keyring
+	registry   *Registry
+	now        func() time.Time
+}
+
+// NewTokenVault creates a TokenVault that refreshes tokens with the
+// providers in registry
+func NewTokenVault(repo repository.ProviderTokenRepository, keys *keyring.Keyring, registry *Registry) *TokenVault {
+	return &TokenVault{
+		repository: repo,
+		keyring:    keys,
+		registry:   registry,
+		now:        time.Now,
+	}
+}
+
+// Store keeps the token a provider issued for identity, replacing the one
+// stored before. Providers like Google only send a refresh token the first
+// time a user consents, so a token without one keeps the stored one. Only a
+// new refresh token ends the need for reconsent.
+func (v *TokenVault) Store(identity *models.Identity, token *oauth2.Token) error {
+	reconsented := token.RefreshToken != ""
+	if !reconsented {
+		stored, err := v.repository.GetIdentityProviderToken(identity.Provider, identity.Subject)
+		switch {
+		case err == nil && !stored.ReconsentRequired:
+			previous, err := v.open(stored)
+			if err != nil {
+				return err
+			}
+			merged := *token
+			merged.RefreshToken = previous.RefreshToken
+			token = &merged
+		case err != nil && !errors.Is(err, repository.ErrNotFound):
+			return err
+		}
+	}
+
+	if err := v.save(identity.Provider, identity.Subject, token); err != nil {
+		return err
+	}
+	if reconsented {
+		return v.repository.ClearReconsentRequired(identity.Provider, identity.Subject)
+	}
+	return nil
+}
+
+// TokenSource returns the user's token for a provider, refreshing it when
+// it expires. Refreshed tokens are stored again, so that rotated refresh
+// tokens are not lost. Once the provider rejects the refresh token, the
+// identity is marked and ErrReconsentRequired is returned until the user
+// logs in with it again.
+func (v *TokenVault) TokenSource(ctx context.Context, userID, providerName string) (oauth2.TokenSource, error) {
+	provider, ok := v.registry.Get(providerName)
+	if !ok {
+		return nil, fmt.Errorf("provider %s is not registered", providerName)
+	}
+
+	stored, err := v.repository.GetProviderToken(userID, providerName)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrNoProviderToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if stored.ReconsentRequired {
+		return nil, ErrReconsentRequired
+	}
+
+	token, err := v.open(stored)
+	if err != nil {
+		return nil, err
+	}
+
+	return &vaultTokenSource{
+		vault:    v,
+		provider: stored.Provider,
+		subject:  stored.Subject,
+		source:   provider.TokenSource(ctx, token),
+		last:     token.AccessToken,
+	}, nil
+}
+
+// Client returns an http.Client that calls a provider's API as the user
+func (v *TokenVault) Client(ctx context.Context, userID, provider string) (*http.Client, error) {
+	source, err := v.TokenSource(ctx, userID, provider)
+	if err != nil {
+		return nil, err
+	}
+	return oauth2.NewClient(ctx, source), nil
+}
+
+func (v *TokenVault) save(provider, subject string, token *oauth2.Token) error {
+	payload, err := json.Marshal(token)
+	if err != nil {
+		return fmt.Errorf("failed to encode provider token: %v", err)
+	}
+
+	dataKey := make([]byte, keyring.KeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return fmt.Errorf("failed to generate data key: %v", err)
+	}
+	ring, err := keyring.New(keyring.Key{ID: "data", Secret: dataKey})
+	if err != nil {
+		return err
+	}
+
+	// Bind both to the identity, so that sealed values cannot be swapped
+	// between rows
+	additionalData := vaultAdditionalData(provider, subject)
+	sealedToken, err := ring.Seal(payload, additionalData)
+	if err != nil {
+		return fmt.Errorf("failed to seal provider token: %v", err)
+	}
+	sealedKey, err := v.keyring.Seal(dataKey, additionalData)
+	if err != nil {
+		return fmt.Errorf("failed to seal data key: %v", err)
+	}
+
+	var expiresAt *time.Time
+	if !token.Expiry.IsZero() {
+		expiresAt = &token.Expiry
+	}
+
+	return v.repository.SaveProviderToken(models.ProviderToken{
+		Provider:  provider,
+		Subject:   subject,
+		DataKey:   sealedKey,
+		Token:     sealedToken,
+		ExpiresAt: expiresAt,
+		UpdatedAt: v.now(),
+	})
+}
+
+// open decrypts a stored token, resealing its data key if it was sealed
+// before the keyring was rotated
+func (v *TokenVault) open(stored *models.ProviderToken) (*oauth2.Token, error) {
+	additionalData := vaultAdditionalData(stored.Provider, stored.Subject)
+	dataKey, keyID, err := v.keyring.Open(stored.DataKey, additionalData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open data key: %v", err)
+	}
+	ring, err := keyring.New(keyring.Key{ID: "data", Secret: dataKey})
+	if err != nil {
+		return nil, fmt.Errorf("data key: %v", err)
+	}
+	payload, _, err := ring.Open(stored.Token, additionalData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open provider token: %v", err)
+	}
+
+	var token oauth2.Token
+	if err := json.Unmarshal(payload, &token); err != nil {
+		return nil, fmt.Errorf("failed to decode provider token: %v", err)
+	}
+
+	if keyID != v.keyring.PrimaryID() {
+		if err := v.save(stored.Provider, stored.Subject, &token); err != nil {
+			logger.Log.Warn("Failed to reseal " + stored.Provider + " token: " + err.Error())
+		}
+	}
+
+	return &token, nil
+}
+
+func vaultAdditionalData(provider, subject string) []byte {
+	return []byte(provider + " " + subject)
+}
+
+// vaultTokenSource stores the tokens its source refreshes
+type vaultTokenSource struct {
+	vault    *TokenVault
+	provider string
+	subject  string
+	source   oauth2.TokenSource
+	mu       sync.Mutex
+	last     string
+}
+
+func (s *vaultTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, err := s.source.Token()
+	var retrieveErr *oauth2.RetrieveError
+	if errors.As(err, &retrieveErr) && retrieveErr.ErrorCode == "invalid_grant" {
+		logger.Log.Info("The " + s.provider + " refresh token of " + s.subject + " was rejected, reconsent is required")
+		if err := s.vault.repository.MarkReconsentRequired(s.provider, s.subject); err != nil {
+			logger.Log.Warn("Failed to mark " + s.provider + " identity for reconsent: " + err.Error())
+		}
+		return nil, fmt.Errorf("%w: %v", ErrReconsentRequired, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if token.AccessToken != s.last {
+		if err := s.vault.save(s.provider, s.subject, token); err != nil {
+			logger.Log.Warn("Failed to store refreshed " + s.provider + " token: " + err.Error())
+		} else {
+			s.last = token.AccessToken
+		}
+	}
+
+	return token, nil
+}
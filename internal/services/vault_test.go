@@ -0,0 +1,193 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"login-with-oauth/internal/keyring"
+	"login-with-oauth/internal/models"
+	"login-with-oauth/internal/repository"
+	"login-with-oauth/internal/repository/mock"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestTokenVault(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// The provider's token endpoint hands out a new access and refresh token
+	// for refresh-1, and its API expects the new access token
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			require.NoError(t, r.ParseForm())
+			if r.PostForm.Get("refresh_token") != "refresh-1" {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"error": "invalid_grant"}`))
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"access_token": "access-2", "refresh_token": "refresh-2", "token_type": "Bearer", "expires_in": 3600}`))
+		case "/user":
+			if r.Header.Get("Authorization") != "Bearer access-2" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(`{"login": "testuser"}`))
+		}
+	}))
+	defer server.Close()
+
+	github := NewGitHubService("test-client-id", "test-client-secret", nil)
+	github.config.Endpoint.TokenURL = server.URL + "/token"
+	registry := NewRegistry()
+	require.NoError(t, registry.Register(github))
+
+	ring, err := keyring.New(keyring.Key{ID: "k1", Secret: bytes.Repeat([]byte{1}, keyring.KeySize)})
+	require.NoError(t, err)
+
+	repo := mock.NewMockProviderTokenRepository(ctrl)
+	vault := NewTokenVault(repo, ring, registry)
+
+	// stored stands in for the provider_tokens table
+	var stored *models.ProviderToken
+	repo.EXPECT().SaveProviderToken(gomock.Any()).DoAndReturn(func(token models.ProviderToken) error {
+		token.IdentityID, token.UserID = "identity-1", "user-1"
+		if stored != nil {
+			token.ReconsentRequired = stored.ReconsentRequired
+		}
+		stored = &token
+		return nil
+	}).AnyTimes()
+	repo.EXPECT().GetProviderToken(gomock.Any(), gomock.Any()).DoAndReturn(func(userID, provider string) (*models.ProviderToken, error) {
+		if stored == nil || userID != stored.UserID || provider != stored.Provider {
+			return nil, repository.ErrNotFound
+		}
+		saved := *stored
+		return &saved, nil
+	}).AnyTimes()
+	repo.EXPECT().GetIdentityProviderToken(gomock.Any(), gomock.Any()).DoAndReturn(func(provider, subject string) (*models.ProviderToken, error) {
+		if stored == nil || provider != stored.Provider || subject != stored.Subject {
+			return nil, repository.ErrNotFound
+		}
+		saved := *stored
+		return &saved, nil
+	}).AnyTimes()
+	repo.EXPECT().MarkReconsentRequired(gomock.Any(), gomock.Any()).DoAndReturn(func(provider, subject string) error {
+		stored.ReconsentRequired = true
+		return nil
+	}).AnyTimes()
+	repo.EXPECT().ClearReconsentRequired(gomock.Any(), gomock.Any()).DoAndReturn(func(provider, subject string) error {
+		stored.ReconsentRequired = false
+		return nil
+	}).AnyTimes()
+
+	identity := &models.Identity{Provider: "github", Subject: "12345"}
+
+	t.Run("NoToken", func(t *testing.T) {
+		_, err := vault.Client(context.Background(), "user-1", "github")
+
+		assert.ErrorIs(t, err, ErrNoProviderToken)
+	})
+
+	t.Run("Store", func(t *testing.T) {
+		require.NoError(t, vault.Store(identity, &oauth2.Token{AccessToken: "access-1", RefreshToken: "refresh-1", Expiry: time.Now().Add(time.Hour)}))
+
+		require.NotNil(t, stored)
+		assert.NotContains(t, stored.Token, "access-1")
+		assert.NotContains(t, stored.Token, "refresh-1")
+		assert.True(t, strings.HasPrefix(stored.DataKey, "k1."))
+		assert.NotNil(t, stored.ExpiresAt)
+
+		source, err := vault.TokenSource(context.Background(), "user-1", "github")
+		require.NoError(t, err)
+		token, err := source.Token()
+		require.NoError(t, err)
+		assert.Equal(t, "access-1", token.AccessToken)
+	})
+
+	t.Run("SwappedRow", func(t *testing.T) {
+		other := *stored
+		other.Subject = "67890"
+
+		_, err := vault.open(&other)
+
+		assert.Error(t, err)
+	})
+
+	t.Run("RefreshIsStored", func(t *testing.T) {
+		require.NoError(t, vault.Store(identity, &oauth2.Token{AccessToken: "access-1", RefreshToken: "refresh-1", Expiry: time.Now().Add(-time.Minute)}))
+
+		client, err := vault.Client(context.Background(), "user-1", "github")
+		require.NoError(t, err)
+		resp, err := client.Get(server.URL + "/user")
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		token, err := vault.open(stored)
+		require.NoError(t, err)
+		assert.Equal(t, "access-2", token.AccessToken)
+		assert.Equal(t, "refresh-2", token.RefreshToken)
+	})
+
+	t.Run("KeepsRefreshToken", func(t *testing.T) {
+		// Google leaves the refresh token out once the user has consented
+		require.NoError(t, vault.Store(identity, &oauth2.Token{AccessToken: "access-3", Expiry: time.Now().Add(time.Hour)}))
+
+		token, err := vault.open(stored)
+		require.NoError(t, err)
+		assert.Equal(t, "access-3", token.AccessToken)
+		assert.Equal(t, "refresh-2", token.RefreshToken)
+	})
+
+	t.Run("InvalidGrant", func(t *testing.T) {
+		require.NoError(t, vault.Store(identity, &oauth2.Token{AccessToken: "access-1", RefreshToken: "revoked", Expiry: time.Now().Add(-time.Minute)}))
+
+		source, err := vault.TokenSource(context.Background(), "user-1", "github")
+		require.NoError(t, err)
+		_, err = source.Token()
+		assert.ErrorIs(t, err, ErrReconsentRequired)
+		assert.True(t, stored.ReconsentRequired)
+
+		_, err = vault.TokenSource(context.Background(), "user-1", "github")
+		assert.ErrorIs(t, err, ErrReconsentRequired)
+
+		// The refresh token of a token needing reconsent is not kept
+		require.NoError(t, vault.Store(identity, &oauth2.Token{AccessToken: "access-4"}))
+		token, err := vault.open(stored)
+		require.NoError(t, err)
+		assert.Empty(t, token.RefreshToken)
+	})
+
+	t.Run("LoginWithoutRefreshTokenKeepsReconsent", func(t *testing.T) {
+		require.True(t, stored.ReconsentRequired)
+
+		// A login that brings no new refresh token leaves nothing to refresh
+		// with, so the identity still needs reconsent
+		require.NoError(t, vault.Store(identity, &oauth2.Token{AccessToken: "access-5"}))
+		assert.True(t, stored.ReconsentRequired)
+		_, err := vault.TokenSource(context.Background(), "user-1", "github")
+		assert.ErrorIs(t, err, ErrReconsentRequired)
+
+		require.NoError(t, vault.Store(identity, &oauth2.Token{AccessToken: "access-6", RefreshToken: "refresh-1"}))
+		assert.False(t, stored.ReconsentRequired)
+		_, err = vault.TokenSource(context.Background(), "user-1", "github")
+		assert.NoError(t, err)
+	})
+
+	t.Run("UnknownProvider", func(t *testing.T) {
+		_, err := vault.TokenSource(context.Background(), "user-1", "google")
+
+		assert.Error(t, err)
+	})
+}